@@ -3,9 +3,11 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/bookamovie/book/internal/utils"
 	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
@@ -33,13 +35,19 @@ func main() {
 	}
 	defer os.Unsetenv(sEnvName)
 
-	file, err := utils.OpenFile(storage)
-	if err != nil {
-		panic(err)
+	// PostgreSQL URLs are used as is, anything else is a path to a SQLite database, created if missing.
+	database := storage
+	if !strings.HasPrefix(storage, "postgres://") && !strings.HasPrefix(storage, "postgresql://") {
+		file, err := utils.OpenFile(storage)
+		if err != nil {
+			panic(err)
+		}
+		file.Close()
+
+		database = "sqlite3://" + storage
 	}
-	file.Close()
 
-	m, err := migrate.New("file://"+migrations, "sqlite3://"+storage)
+	m, err := migrate.New("file://"+migrations, database)
 	if err != nil {
 		panic(err)
 	}
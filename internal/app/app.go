@@ -1,33 +1,66 @@
 package app
 
 import (
+	"fmt"
 	"log/slog"
+	"net/url"
 	"os"
 	"os/signal"
 	"syscall"
 
 	bookapp "github.com/bookamovie/book/internal/app/book"
+	"github.com/bookamovie/book/internal/app/consumer"
+	metricsapp "github.com/bookamovie/book/internal/app/metrics"
+	"github.com/bookamovie/book/internal/app/relay"
+	"github.com/bookamovie/book/internal/app/sweeper"
+	"github.com/bookamovie/book/internal/broker/fanout"
+	"github.com/bookamovie/book/internal/broker/jsonl"
 	broker "github.com/bookamovie/book/internal/broker/kafka"
+	"github.com/bookamovie/book/internal/broker/webhook"
 	"github.com/bookamovie/book/internal/lib/logger"
+	"github.com/bookamovie/book/internal/lib/metrics"
+	"github.com/bookamovie/book/internal/lib/tracing"
 	bookservice "github.com/bookamovie/book/internal/services/book"
-	storage "github.com/bookamovie/book/internal/storage/sqlite"
+	"github.com/bookamovie/book/internal/storage/memory"
+	"github.com/bookamovie/book/internal/storage/postgres"
+	"github.com/bookamovie/book/internal/storage/sqlite"
 	"github.com/bookamovie/book/internal/utils"
 )
 
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
+
+	DriverKafka   = "kafka"
+	DriverWebhook = "webhook"
+	DriverFile    = "file"
+)
+
+var (
+	ErrUnknownDriver = fmt.Errorf("driver is unknown")
+	ErrNoBroker      = fmt.Errorf("at least one broker driver must be set")
+)
+
 // App{} coordinates the main components of the bookamovie service.
 //
-// It contains the gRPC application logic, storage backend, broker, and shared logger/config.
+// It contains the gRPC application logic, the expired holds sweeper, the outbox relay, the inbound events consumer, the metrics listener, the tracer provider, storage backend, broker, and shared logger/config.
 type App struct {
-	Book    *bookapp.App
-	Storage bookservice.Querier
-	Broker  bookservice.Brokerer
-	Log     *logger.Logger
-	Config  utils.Config
+	Book     *bookapp.App
+	Sweeper  *sweeper.App
+	Relay    *relay.App
+	Consumer *consumer.App
+	Metrics  *metricsapp.App
+	Tracing  *tracing.Provider
+	Storage  bookservice.Querier
+	Broker   bookservice.Brokerer
+	Log      *logger.Logger
+	Config   utils.Config
 }
 
 // New() initializes the App with all necessary components.
 //
-// It loads config, sets up logging, storage, broker, and gRPC logic. Returns a pointer to App or an error on failure.
+// It loads config, sets up logging, tracing, storage, broker, and gRPC logic. Returns a pointer to App or an error on failure.
 func New() (*App, error) {
 	cfg, err := utils.LoadConfig()
 	if err != nil {
@@ -39,28 +72,163 @@ func New() (*App, error) {
 		return &App{}, err
 	}
 
-	s, err := storage.New(cfg, log)
+	tp, err := tracing.New(cfg, log)
 	if err != nil {
 		return &App{}, err
 	}
 
-	br, err := broker.New(cfg, log)
+	s, err := newStorage(cfg, log)
 	if err != nil {
 		return &App{}, err
 	}
 
-	book := bookapp.New(log, cfg, s, br)
+	br, err := newBroker(cfg, log)
+	if err != nil {
+		return &App{}, err
+	}
+
+	tickets, err := bookservice.NewTicketGenerator(cfg)
+	if err != nil {
+		return &App{}, err
+	}
+
+	keys, err := bookservice.NewKeyRing(cfg)
+	if err != nil {
+		return &App{}, err
+	}
+
+	book := bookapp.New(log, cfg, s, br, tickets, keys)
+
+	sw := sweeper.New(log, cfg, s)
+
+	rl := relay.New(log, cfg, s, br)
+
+	cons, err := consumer.New(log, cfg, s)
+	if err != nil {
+		return &App{}, err
+	}
 
 	return &App{
-		Book:    book,
-		Storage: s,
-		Broker:  br,
-		Log:     log,
-		Config:  cfg,
+		Book:     book,
+		Sweeper:  sw,
+		Relay:    rl,
+		Consumer: cons,
+		Metrics:  metricsapp.New(log, cfg),
+		Tracing:  tp,
+		Storage:  s,
+		Broker:   br,
+		Log:      log,
+		Config:   cfg,
 	}, nil
 }
 
-// Run() starts the App, launching the gRPC server and listening for OS shutdown signals.
+// newStorage() initializes the storage backend selected by the storage driver.
+//
+// The connection pool stats of SQL databases are exposed as metrics.
+func newStorage(cfg utils.Config, log *logger.Logger) (bookservice.Querier, error) {
+	switch cfg.StorageConfig.Driver {
+	case DriverSQLite:
+		s, err := sqlite.New(cfg, log)
+		if err != nil {
+			return nil, err
+		}
+
+		return s, metrics.RegisterDB(DriverSQLite, s.DB)
+
+	case DriverPostgres:
+		s, err := postgres.New(cfg, log)
+		if err != nil {
+			return nil, err
+		}
+
+		return s, metrics.RegisterDB(DriverPostgres, s.DB)
+
+	case DriverMemory:
+		return memory.New(cfg, log), nil
+
+	default:
+		return nil, fmt.Errorf("%w: storage %s", ErrUnknownDriver, cfg.StorageConfig.Driver)
+	}
+}
+
+// newBroker() initializes the brokers selected by the broker drivers, and fans out to all of them.
+//
+// Every broker is a sink of the outbox named after its driver, except webhooks: every URL is a sink of its own.
+//
+// Returns ErrNoBroker if there would be no sink at all, as the outbox relay would then never publish the events bookings store.
+func newBroker(cfg utils.Config, log *logger.Logger) (bookservice.Brokerer, error) {
+	if len(cfg.BrokerConfig.Drivers) == 0 {
+		return nil, ErrNoBroker
+	}
+
+	var sinks []bookservice.Sink
+
+	for _, driver := range cfg.BrokerConfig.Drivers {
+		var driverSinks []bookservice.Sink
+		var err error
+
+		switch driver {
+		case DriverKafka:
+			var br *broker.Broker
+
+			br, err = broker.New(cfg, log)
+			driverSinks = []bookservice.Sink{{Name: driver, Broker: br}}
+
+		case DriverWebhook:
+			driverSinks, err = webhookSinks(cfg, log)
+
+		case DriverFile:
+			var br *jsonl.Broker
+
+			br, err = jsonl.New(cfg, log)
+			driverSinks = []bookservice.Sink{{Name: driver, Broker: br}}
+
+		default:
+			err = fmt.Errorf("%w: broker %s", ErrUnknownDriver, driver)
+		}
+		if err != nil {
+			fanout.New(sinks...).Shutdown()
+
+			return nil, err
+		}
+
+		sinks = append(sinks, driverSinks...)
+	}
+
+	return fanout.New(sinks...), nil
+}
+
+// webhookSinks() returns a sink for every webhook URL, named after the URL without its password, so one partner being down doesn't hold back the others.
+func webhookSinks(cfg utils.Config, log *logger.Logger) ([]bookservice.Sink, error) {
+	// The webhook settings are validated as a whole first.
+	_, err := webhook.New(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+
+	var sinks []bookservice.Sink
+
+	for _, raw := range cfg.WebhookConfig.URLs {
+		single := cfg
+		single.WebhookConfig.URLs = []string{raw}
+
+		br, err := webhook.New(single, log)
+		if err != nil {
+			return nil, err
+		}
+
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		sinks = append(sinks, bookservice.Sink{Name: DriverWebhook + " " + u.Redacted(), Broker: br})
+	}
+
+	return sinks, nil
+}
+
+// Run() starts the App, launching the gRPC server, the expired holds sweeper, the outbox relay, the inbound events consumer and the metrics listener, and listening for OS shutdown signals.
 //
 // It blocks until an interrupt or error occurs, then gracefully shuts everything down.
 func (a *App) Run() {
@@ -84,6 +252,34 @@ func (a *App) Run() {
 		}
 	}()
 
+	go func() {
+		err := a.Sweeper.Run()
+		if err != nil {
+			errChan <- err
+		}
+	}()
+
+	go func() {
+		err := a.Relay.Run()
+		if err != nil {
+			errChan <- err
+		}
+	}()
+
+	go func() {
+		err := a.Consumer.Run()
+		if err != nil {
+			errChan <- err
+		}
+	}()
+
+	go func() {
+		err := a.Metrics.Run()
+		if err != nil {
+			errChan <- err
+		}
+	}()
+
 	select {
 	case <-sigChan:
 		a.Log.Logs.AppLog.Info(
@@ -109,10 +305,19 @@ func (a *App) Run() {
 
 // shutdown() gracefully shuts down all services in the correct order:
 //
-// broker → storage → gRPC app → logger.
+// sweeper → relay → consumer → broker → storage → gRPC app → metrics listener → tracer provider → logger.
+//
+// The gRPC app is reported as NOT_SERVING first, so orchestrators stop routing calls to it while the rest shuts down.
 func (a *App) Shutdown() {
+	a.Book.Health.Shutdown()
+
+	a.Sweeper.Shutdown()
+	a.Relay.Shutdown()
+	a.Consumer.Shutdown()
 	a.Broker.Shutdown()
 	a.Storage.Shutdown()
 	a.Book.Shutdown()
+	a.Metrics.Shutdown()
+	a.Tracing.Shutdown()
 	a.Log.Shutdown()
 }
@@ -4,44 +4,80 @@ import (
 	"context"
 	"errors"
 	"net"
+	"sync/atomic"
+	"time"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 
 	"github.com/bookamovie/book/internal/lib/logger"
+	"github.com/bookamovie/book/internal/lib/metrics"
 	bookservice "github.com/bookamovie/book/internal/services/book"
 	"github.com/bookamovie/book/internal/utils"
+	"github.com/bookamovie/book/pkg/ticket"
 	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
 )
 
+// idempotencyKeyHeader is the gRPC metadata header carrying the idempotency key of a Book request.
+const idempotencyKeyHeader = "idempotency-key"
+
 // App{} represents the gRPC server application for the book service.
 //
-// It handles configuration, logging, and startup/shutdown lifecycle.
+// It handles configuration, logging, and startup/shutdown lifecycle. Health serves the grpc.health.v1 service, reporting whether the storage and the broker are usable.
 type App struct {
 	Server *grpc.Server
+	Health *health.Server
 	Log    *logger.Logger
 
-	config utils.Config
+	storage bookservice.Querier
+	broker  bookservice.Brokerer
+	status  healthpb.HealthCheckResponse_ServingStatus
+	config  utils.Config
+	running atomic.Bool
+	stop    chan struct{}
+	done    chan struct{}
 }
 
 // New() initializes and returns a new instance of the book gRPC App.
 //
-// It wires together logging, configuration, storage, and message broker.
-func New(log *logger.Logger, cfg utils.Config, storage bookservice.Querier, broker bookservice.Brokerer) *App {
-	server := grpc.NewServer()
+// It wires together logging, configuration, storage, message broker, ticket generator, and the keys tickets are signed with. Every call goes through the interceptors of UnaryInterceptors() or StreamInterceptors(), and every call but health checks is traced with a server span, continuing the W3C trace context sent by the client if any. The health and reflection services are registered along with the book one, and the service is reported as NOT_SERVING until its dependencies are first checked.
+func New(log *logger.Logger, cfg utils.Config, storage bookservice.Querier, broker bookservice.Brokerer, tickets bookservice.TicketGenerator, keys *ticket.KeyRing) *App {
+	server := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithFilter(filters.Not(filters.HealthCheck())))),
+		grpc.ChainUnaryInterceptor(UnaryInterceptors(log)...),
+		grpc.ChainStreamInterceptor(StreamInterceptors(log)...),
+	)
 
-	bookrpc.RegisterBookServer(server, &Api{Service: bookservice.New(cfg, log, storage, broker)})
+	bookrpc.RegisterBookServer(server, &Api{Service: bookservice.New(cfg, log, storage, broker, tickets, keys)})
 
-	return &App{
+	a := &App{
 		Server: server,
+		Health: health.NewServer(),
 		Log:    log,
 
-		config: cfg,
+		storage: storage,
+		broker:  broker,
+		config:  cfg,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
 	}
+
+	a.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+
+	healthpb.RegisterHealthServer(server, a.Health)
+	reflection.Register(server)
+
+	return a
 }
 
-// Run() starts the gRPC server using the configured network and address.
+// Run() starts the gRPC server using the configured network and address, and the checks of its dependencies.
 //
 // It blocks and returns any critical error if the server fails to start.
 func (a *App) Run() error {
@@ -50,6 +86,9 @@ func (a *App) Run() error {
 		return err
 	}
 
+	a.running.Store(true)
+	go a.watch()
+
 	err = a.Server.Serve(listener)
 	if err != nil {
 		return err
@@ -58,8 +97,17 @@ func (a *App) Run() error {
 	return nil
 }
 
-// Shutdown() gracefully stops the gRPC server.
+// Shutdown() reports the service as NOT_SERVING, so orchestrators stop routing calls to it, then gracefully stops the gRPC server.
 func (a *App) Shutdown() {
+	// Once shut down, the health server ignores status updates, so checks still in progress can't report the service as serving again.
+	a.Health.Shutdown()
+
+	close(a.stop)
+
+	if a.running.Load() {
+		<-a.done
+	}
+
 	a.Server.GracefulStop()
 }
 
@@ -68,6 +116,18 @@ func (a *App) Shutdown() {
 // It is implemented by the internal book service layer.
 type Servicer interface {
 	Book(ctx context.Context, data *bookrpc.BookRequest) (*bookrpc.BookResponse, error)
+	BookMany(ctx context.Context, data *bookrpc.BookManyRequest) (*bookrpc.BookManyResponse, error)
+	GetBooking(ctx context.Context, data *bookrpc.GetBookingRequest) (*bookrpc.GetBookingResponse, error)
+	ListBookings(ctx context.Context, data *bookrpc.ListBookingsRequest) (*bookrpc.ListBookingsResponse, error)
+	GetSeatAvailability(ctx context.Context, data *bookrpc.GetSeatAvailabilityRequest) (*bookrpc.GetSeatAvailabilityResponse, error)
+	CancelBooking(ctx context.Context, data *bookrpc.CancelBookingRequest) (*bookrpc.CancelBookingResponse, error)
+	HoldSeats(ctx context.Context, data *bookrpc.HoldSeatsRequest) (*bookrpc.HoldSeatsResponse, error)
+	ConfirmHold(ctx context.Context, data *bookrpc.ConfirmHoldRequest) (*bookrpc.BookResponse, error)
+	ReserveIdempotencyKey(ctx context.Context, key string, data *bookrpc.BookRequest) (*bookservice.IdempotentResult, string, error)
+	SaveIdempotencyKey(ctx context.Context, key string, token string, result *bookservice.IdempotentResult) error
+	ReleaseIdempotencyKey(ctx context.Context, key string, token string) error
+	VerifyTicket(ctx context.Context, data *bookrpc.VerifyTicketRequest) (*bookrpc.VerifyTicketResponse, error)
+	RenderTicket(ctx context.Context, data *bookrpc.RenderTicketRequest) (*bookrpc.RenderTicketResponse, error)
 }
 
 // api{} is the gRPC handler for the Book service.
@@ -82,18 +142,95 @@ type Api struct {
 // Book() handles incoming gRPC requests to book a movie ticket.
 //
 // It validates input and delegates to the business logic service layer. Returns appropriate gRPC errors for invalid or duplicate requests.
-func (a *Api) Book(ctx context.Context, req *bookrpc.BookRequest) (*bookrpc.BookResponse, error) {
+//
+// Requests carrying an idempotency-key header are processed once per key: later requests with the same key and payload get the first result, ticket or error, while a different payload gets InvalidArgument. Internal errors aren't kept, so the request can be retried.
+//
+// Every call is counted by outcome, and its latency observed.
+func (a *Api) Book(ctx context.Context, req *bookrpc.BookRequest) (resp *bookrpc.BookResponse, err error) {
+	start := time.Now()
+	defer func() {
+		metrics.Since(metrics.OperationAPIBook, start)
+		metrics.Bookings.WithLabelValues(metrics.Outcome(status.Code(err))).Inc()
+	}()
+
 	ok := utils.ValidateBookRequest(req)
 	if !ok {
 		return &bookrpc.BookResponse{}, status.Error(codes.InvalidArgument, "required request arguments must be specified")
 	}
 
+	var key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if keys := md.Get(idempotencyKeyHeader); len(keys) > 0 {
+			key = keys[0]
+		}
+	}
+	if key == "" {
+		return a.book(ctx, req)
+	}
+
+	result, token, err := a.Service.ReserveIdempotencyKey(ctx, key, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, bookservice.ErrKeyReused):
+			return &bookrpc.BookResponse{}, status.Error(codes.InvalidArgument, bookservice.ErrKeyReused.Error())
+
+		case errors.Is(err, bookservice.ErrKeyInProgress):
+			return &bookrpc.BookResponse{}, status.Error(codes.Aborted, bookservice.ErrKeyInProgress.Error())
+
+		default:
+			return &bookrpc.BookResponse{}, status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	if result != nil {
+		if codes.Code(result.Code) != codes.OK {
+			return &bookrpc.BookResponse{}, status.Error(codes.Code(result.Code), result.Message)
+		}
+
+		return &bookrpc.BookResponse{
+			Order: &bookrpc.Order{
+				Ticket: result.Ticket,
+			},
+			Token: result.Token,
+		}, nil
+	}
+
+	resp, err = a.book(ctx, req)
+
+	// The storage takes the context of the request, so the outcome is stored even if the client gave up meanwhile.
+	done := context.WithoutCancel(ctx)
+
+	st := status.Convert(err)
+	if st.Code() == codes.Internal {
+		_ = a.Service.ReleaseIdempotencyKey(done, key, token)
+
+		return resp, err
+	}
+
+	// The storage logs failures. The booking is done either way, so its result is returned regardless.
+	_ = a.Service.SaveIdempotencyKey(done, key, token, &bookservice.IdempotentResult{
+		Ticket:  resp.GetOrder().GetTicket(),
+		Code:    uint32(st.Code()),
+		Message: st.Message(),
+	})
+
+	return resp, err
+}
+
+// book() books a movie ticket through the service layer and maps its errors to gRPC ones.
+func (a *Api) book(ctx context.Context, req *bookrpc.BookRequest) (*bookrpc.BookResponse, error) {
 	resp, err := a.Service.Book(ctx, req)
 	if err != nil {
 		switch {
 		case errors.Is(err, bookservice.ErrDuplicate):
 			return &bookrpc.BookResponse{}, status.Error(codes.AlreadyExists, bookservice.ErrDuplicate.Error())
 
+		case errors.Is(err, bookservice.ErrSeatNotInLayout):
+			return &bookrpc.BookResponse{}, status.Error(codes.InvalidArgument, bookservice.ErrSeatNotInLayout.Error())
+
+		case errors.Is(err, bookservice.ErrScreenFull):
+			return &bookrpc.BookResponse{}, status.Error(codes.ResourceExhausted, bookservice.ErrScreenFull.Error())
+
 		default:
 			return &bookrpc.BookResponse{}, status.Error(codes.Internal, "internal error")
 		}
@@ -101,3 +238,254 @@ func (a *Api) Book(ctx context.Context, req *bookrpc.BookRequest) (*bookrpc.Book
 
 	return resp, nil
 }
+
+// BookMany() handles incoming gRPC requests to book several seats of one session at once.
+//
+// It validates input and delegates to the business logic service layer. Either all seats are booked, or none is and AlreadyExists is returned.
+//
+// Every call is counted by outcome once per requested seat, like as many Book calls, or once if it has none, and its latency observed.
+func (a *Api) BookMany(ctx context.Context, req *bookrpc.BookManyRequest) (resp *bookrpc.BookManyResponse, err error) {
+	start := time.Now()
+	defer func() {
+		metrics.Since(metrics.OperationAPIBookMany, start)
+		metrics.Bookings.WithLabelValues(metrics.Outcome(status.Code(err))).Add(float64(max(len(req.GetSeats()), 1)))
+	}()
+
+	ok := utils.ValidateBookManyRequest(req)
+	if !ok {
+		return &bookrpc.BookManyResponse{}, status.Error(codes.InvalidArgument, "required request arguments must be specified")
+	}
+
+	resp, err = a.Service.BookMany(ctx, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, bookservice.ErrDuplicate):
+			return &bookrpc.BookManyResponse{}, status.Error(codes.AlreadyExists, bookservice.ErrDuplicate.Error())
+
+		case errors.Is(err, bookservice.ErrSeatNotInLayout):
+			return &bookrpc.BookManyResponse{}, status.Error(codes.InvalidArgument, bookservice.ErrSeatNotInLayout.Error())
+
+		case errors.Is(err, bookservice.ErrScreenFull):
+			return &bookrpc.BookManyResponse{}, status.Error(codes.ResourceExhausted, bookservice.ErrScreenFull.Error())
+
+		default:
+			return &bookrpc.BookManyResponse{}, status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	return resp, nil
+}
+
+// GetBooking() handles incoming gRPC requests to look up a booking by its ticket.
+//
+// It validates input and delegates to the business logic service layer. Returns NotFound for unknown tickets.
+func (a *Api) GetBooking(ctx context.Context, req *bookrpc.GetBookingRequest) (*bookrpc.GetBookingResponse, error) {
+	ok := utils.ValidateGetBookingRequest(req)
+	if !ok {
+		return &bookrpc.GetBookingResponse{}, status.Error(codes.InvalidArgument, "required request arguments must be specified")
+	}
+
+	resp, err := a.Service.GetBooking(ctx, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, bookservice.ErrNotFound):
+			return &bookrpc.GetBookingResponse{}, status.Error(codes.NotFound, bookservice.ErrNotFound.Error())
+
+		default:
+			return &bookrpc.GetBookingResponse{}, status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	return resp, nil
+}
+
+// ListBookings() handles incoming gRPC requests to list bookings, one page at a time.
+//
+// It validates input and delegates to the business logic service layer. Returns InvalidArgument for malformed filters or page tokens.
+func (a *Api) ListBookings(ctx context.Context, req *bookrpc.ListBookingsRequest) (*bookrpc.ListBookingsResponse, error) {
+	ok := utils.ValidateListBookingsRequest(req)
+	if !ok {
+		return &bookrpc.ListBookingsResponse{}, status.Error(codes.InvalidArgument, "request arguments are malformed")
+	}
+
+	resp, err := a.Service.ListBookings(ctx, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, bookservice.ErrInvalidPageToken):
+			return &bookrpc.ListBookingsResponse{}, status.Error(codes.InvalidArgument, bookservice.ErrInvalidPageToken.Error())
+
+		default:
+			return &bookrpc.ListBookingsResponse{}, status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	return resp, nil
+}
+
+// GetSeatAvailability() handles incoming gRPC requests for the taken and free seats of a screen session.
+//
+// It validates input and delegates to the business logic service layer.
+func (a *Api) GetSeatAvailability(ctx context.Context, req *bookrpc.GetSeatAvailabilityRequest) (*bookrpc.GetSeatAvailabilityResponse, error) {
+	ok := utils.ValidateGetSeatAvailabilityRequest(req)
+	if !ok {
+		return &bookrpc.GetSeatAvailabilityResponse{}, status.Error(codes.InvalidArgument, "required request arguments must be specified")
+	}
+
+	resp, err := a.Service.GetSeatAvailability(ctx, req)
+	if err != nil {
+		return &bookrpc.GetSeatAvailabilityResponse{}, status.Error(codes.Internal, "internal error")
+	}
+
+	return resp, nil
+}
+
+// CancelBooking() handles incoming gRPC requests to cancel a booked ticket.
+//
+// It validates input and delegates to the business logic service layer. Returns NotFound for unknown tickets and FailedPrecondition for tickets that are already cancelled.
+func (a *Api) CancelBooking(ctx context.Context, req *bookrpc.CancelBookingRequest) (*bookrpc.CancelBookingResponse, error) {
+	ok := utils.ValidateCancelBookingRequest(req)
+	if !ok {
+		return &bookrpc.CancelBookingResponse{}, status.Error(codes.InvalidArgument, "required request arguments must be specified")
+	}
+
+	resp, err := a.Service.CancelBooking(ctx, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, bookservice.ErrNotFound):
+			return &bookrpc.CancelBookingResponse{}, status.Error(codes.NotFound, bookservice.ErrNotFound.Error())
+
+		case errors.Is(err, bookservice.ErrAlreadyCancelled):
+			return &bookrpc.CancelBookingResponse{}, status.Error(codes.FailedPrecondition, bookservice.ErrAlreadyCancelled.Error())
+
+		default:
+			return &bookrpc.CancelBookingResponse{}, status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	return resp, nil
+}
+
+// HoldSeats() handles incoming gRPC requests to temporarily hold several seats of one session.
+//
+// It validates input and delegates to the business logic service layer. Either all seats are held, or none is and AlreadyExists is returned.
+func (a *Api) HoldSeats(ctx context.Context, req *bookrpc.HoldSeatsRequest) (*bookrpc.HoldSeatsResponse, error) {
+	ok := utils.ValidateHoldSeatsRequest(req)
+	if !ok {
+		return &bookrpc.HoldSeatsResponse{}, status.Error(codes.InvalidArgument, "required request arguments must be specified")
+	}
+
+	resp, err := a.Service.HoldSeats(ctx, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, bookservice.ErrDuplicate):
+			return &bookrpc.HoldSeatsResponse{}, status.Error(codes.AlreadyExists, bookservice.ErrDuplicate.Error())
+
+		case errors.Is(err, bookservice.ErrSeatNotInLayout):
+			return &bookrpc.HoldSeatsResponse{}, status.Error(codes.InvalidArgument, bookservice.ErrSeatNotInLayout.Error())
+
+		case errors.Is(err, bookservice.ErrScreenFull):
+			return &bookrpc.HoldSeatsResponse{}, status.Error(codes.ResourceExhausted, bookservice.ErrScreenFull.Error())
+
+		default:
+			return &bookrpc.HoldSeatsResponse{}, status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	return resp, nil
+}
+
+// ConfirmHold() handles incoming gRPC requests to turn a hold into a booking.
+//
+// It validates input and delegates to the business logic service layer. Returns NotFound for unknown or expired holds.
+func (a *Api) ConfirmHold(ctx context.Context, req *bookrpc.ConfirmHoldRequest) (*bookrpc.BookResponse, error) {
+	ok := utils.ValidateConfirmHoldRequest(req)
+	if !ok {
+		return &bookrpc.BookResponse{}, status.Error(codes.InvalidArgument, "required request arguments must be specified")
+	}
+
+	resp, err := a.Service.ConfirmHold(ctx, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, bookservice.ErrHoldNotFound):
+			return &bookrpc.BookResponse{}, status.Error(codes.NotFound, bookservice.ErrHoldNotFound.Error())
+
+		case errors.Is(err, bookservice.ErrDuplicate):
+			return &bookrpc.BookResponse{}, status.Error(codes.AlreadyExists, bookservice.ErrDuplicate.Error())
+
+		case errors.Is(err, bookservice.ErrSeatNotInLayout):
+			return &bookrpc.BookResponse{}, status.Error(codes.InvalidArgument, bookservice.ErrSeatNotInLayout.Error())
+
+		case errors.Is(err, bookservice.ErrScreenFull):
+			return &bookrpc.BookResponse{}, status.Error(codes.ResourceExhausted, bookservice.ErrScreenFull.Error())
+
+		default:
+			return &bookrpc.BookResponse{}, status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	return resp, nil
+}
+
+// VerifyTicket() handles incoming gRPC requests to verify a signed ticket token.
+//
+// It validates input and delegates to the business logic service layer. Returns InvalidArgument for tokens that can't be trusted, and NotFound for tickets that don't exist.
+func (a *Api) VerifyTicket(ctx context.Context, req *bookrpc.VerifyTicketRequest) (*bookrpc.VerifyTicketResponse, error) {
+	ok := utils.ValidateVerifyTicketRequest(req)
+	if !ok {
+		return &bookrpc.VerifyTicketResponse{}, status.Error(codes.InvalidArgument, "required request arguments must be specified")
+	}
+
+	resp, err := a.Service.VerifyTicket(ctx, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, bookservice.ErrInvalidToken):
+			return &bookrpc.VerifyTicketResponse{}, status.Error(codes.InvalidArgument, bookservice.ErrInvalidToken.Error())
+
+		case errors.Is(err, bookservice.ErrNotFound):
+			return &bookrpc.VerifyTicketResponse{}, status.Error(codes.NotFound, bookservice.ErrNotFound.Error())
+
+		case errors.Is(err, bookservice.ErrSigningDisabled):
+			return &bookrpc.VerifyTicketResponse{}, status.Error(codes.FailedPrecondition, bookservice.ErrSigningDisabled.Error())
+
+		default:
+			return &bookrpc.VerifyTicketResponse{}, status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	return resp, nil
+}
+
+// RenderTicket() handles incoming gRPC requests to draw a ticket as a QR code or a barcode.
+//
+// It validates input and delegates to the business logic service layer. Returns InvalidArgument for options that can't be rendered, NotFound for unknown tickets, and FailedPrecondition for cancelled ones.
+func (a *Api) RenderTicket(ctx context.Context, req *bookrpc.RenderTicketRequest) (*bookrpc.RenderTicketResponse, error) {
+	ok := utils.ValidateRenderTicketRequest(req)
+	if !ok {
+		return &bookrpc.RenderTicketResponse{}, status.Error(codes.InvalidArgument, "required request arguments must be specified")
+	}
+
+	resp, err := a.Service.RenderTicket(ctx, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, bookservice.ErrNotFound):
+			return &bookrpc.RenderTicketResponse{}, status.Error(codes.NotFound, bookservice.ErrNotFound.Error())
+
+		case errors.Is(err, bookservice.ErrAlreadyCancelled):
+			return &bookrpc.RenderTicketResponse{}, status.Error(codes.FailedPrecondition, bookservice.ErrAlreadyCancelled.Error())
+
+		case errors.Is(err, bookservice.ErrUnknownFormat):
+			return &bookrpc.RenderTicketResponse{}, status.Error(codes.InvalidArgument, bookservice.ErrUnknownFormat.Error())
+
+		case errors.Is(err, bookservice.ErrUnknownSymbology):
+			return &bookrpc.RenderTicketResponse{}, status.Error(codes.InvalidArgument, bookservice.ErrUnknownSymbology.Error())
+
+		case errors.Is(err, bookservice.ErrRenderTooSmall):
+			return &bookrpc.RenderTicketResponse{}, status.Error(codes.InvalidArgument, bookservice.ErrRenderTooSmall.Error())
+
+		default:
+			return &bookrpc.RenderTicketResponse{}, status.Error(codes.Internal, "internal error")
+		}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,75 @@
+package book
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+)
+
+// watch() checks the dependencies of the service every configured health interval, until Shutdown() is called.
+func (a *App) watch() {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.config.BookConfig.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		a.Check()
+
+		select {
+		case <-a.stop:
+			return
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check() pings the storage and the broker, and reports the service as SERVING if both succeed, or NOT_SERVING otherwise.
+//
+// Each ping times out after the configured health interval. Status changes are logged.
+func (a *App) Check() healthpb.HealthCheckResponse_ServingStatus {
+	const op = "Check()"
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.BookConfig.HealthInterval)
+	defer cancel()
+
+	err := errors.Join(a.storage.Ping(ctx), a.broker.Ping(ctx))
+
+	status := healthpb.HealthCheckResponse_SERVING
+	if err != nil {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	if status != a.status {
+		if err != nil {
+			a.Log.Logs.BookLog.Error(
+				"the service is not serving",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+		} else {
+			a.Log.Logs.BookLog.Info(
+				"the service is serving",
+				slog.String("op", op),
+			)
+		}
+	}
+
+	a.setStatus(status)
+
+	return status
+}
+
+// setStatus() reports the status of the service, both overall and for the book service.
+func (a *App) setStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	a.status = status
+
+	a.Health.SetServingStatus("", status)
+	a.Health.SetServingStatus(bookrpc.Book_ServiceDesc.ServiceName, status)
+}
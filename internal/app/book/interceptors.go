@@ -0,0 +1,208 @@
+package book
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/bookamovie/book/internal/lib/logger"
+)
+
+// RequestIDHeader is the gRPC metadata header carrying the ID of a request, both ways.
+const RequestIDHeader = "x-request-id"
+
+// maxRequestIDLength is the length above which incoming request IDs are replaced.
+const maxRequestIDLength = 128
+
+// UnaryInterceptors() returns the interceptors every unary call goes through, outermost first.
+//
+// The request ID is set first, so every log of the call carries it. Panics are recovered innermost, so the call is logged with the Internal code they turn into.
+func UnaryInterceptors(log *logger.Logger) []grpc.UnaryServerInterceptor {
+	return []grpc.UnaryServerInterceptor{
+		UnaryRequestID(),
+		UnaryLogging(log),
+		UnaryRecovery(log),
+	}
+}
+
+// StreamInterceptors() returns the interceptors every streaming call goes through, outermost first, in the same order as UnaryInterceptors().
+func StreamInterceptors(log *logger.Logger) []grpc.StreamServerInterceptor {
+	return []grpc.StreamServerInterceptor{
+		StreamRequestID(),
+		StreamLogging(log),
+		StreamRecovery(log),
+	}
+}
+
+// requestID() returns the request ID sent by the client, or a new ULID if there's none or it isn't valid.
+//
+// Valid request IDs are up to maxRequestIDLength printable ASCII characters, so they can't break log lines.
+func requestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDHeader); len(ids) > 0 && validRequestID(ids[0]) {
+			return ids[0]
+		}
+	}
+
+	return ulid.Make().String()
+}
+
+// validRequestID() reports whether a request ID sent by a client can be kept.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// UnaryRequestID() returns an interceptor putting the ID of every request in its context, where loggers find it, and sending it back in the response headers.
+func UnaryRequestID() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		id := requestID(ctx)
+
+		// Fails only outside of a gRPC server, where there's no client to send the header to.
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+
+		return handler(logger.WithRequestID(ctx, id), req)
+	}
+}
+
+// StreamRequestID() is the streaming counterpart of UnaryRequestID().
+func StreamRequestID() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		id := requestID(ss.Context())
+
+		_ = ss.SetHeader(metadata.Pairs(RequestIDHeader, id))
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: logger.WithRequestID(ss.Context(), id)})
+	}
+}
+
+// serverStream{} is a grpc.ServerStream with a context of its own, since the context of a stream can't be replaced otherwise.
+type serverStream struct {
+	grpc.ServerStream
+
+	ctx context.Context
+}
+
+// Context() returns the context of the stream.
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// level() returns the level a call ending with the given code is logged at.
+//
+// Only codes meaning something is wrong with the server are errors. The others are the client's doing.
+func level(code codes.Code) slog.Level {
+	switch code {
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		return slog.LevelError
+
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// logCall() logs a call to the book log, with its method, code and duration.
+func logCall(ctx context.Context, log *logger.Logger, op string, method string, start time.Time, err error) {
+	st := status.Convert(err)
+
+	attrs := []slog.Attr{
+		slog.String("op", op),
+		slog.String("method", method),
+		slog.String("code", st.Code().String()),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", st.Message()))
+	}
+
+	log.Logs.BookLog.LogAttrs(ctx, level(st.Code()), "handled a call", attrs...)
+}
+
+// UnaryLogging() returns an interceptor logging every unary call once it is handled.
+func UnaryLogging(log *logger.Logger) grpc.UnaryServerInterceptor {
+	const op = "UnaryLogging()"
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+		logCall(ctx, log, op, info.FullMethod, start, err)
+
+		return resp, err
+	}
+}
+
+// StreamLogging() returns an interceptor logging every streaming call once it is handled.
+func StreamLogging(log *logger.Logger) grpc.StreamServerInterceptor {
+	const op = "StreamLogging()"
+
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+
+		err := handler(srv, ss)
+		logCall(ss.Context(), log, op, info.FullMethod, start, err)
+
+		return err
+	}
+}
+
+// recovered() logs a panic recovered from a call, with its stack, and returns the error the call ends with instead.
+func recovered(ctx context.Context, log *logger.Logger, op string, method string, p any) error {
+	log.Logs.BookLog.ErrorContext(
+		ctx,
+		"recovered from a panic",
+		slog.String("op", op),
+		slog.String("method", method),
+		slog.String("panic", fmt.Sprint(p)),
+		slog.String("stack", string(debug.Stack())),
+	)
+
+	return status.Error(codes.Internal, "internal error")
+}
+
+// UnaryRecovery() returns an interceptor turning panics of unary calls into Internal errors, so a single call can't bring the server down.
+func UnaryRecovery(log *logger.Logger) grpc.UnaryServerInterceptor {
+	const op = "UnaryRecovery()"
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				resp, err = nil, recovered(ctx, log, op, info.FullMethod, p)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamRecovery() returns an interceptor turning panics of streaming calls into Internal errors.
+func StreamRecovery(log *logger.Logger) grpc.StreamServerInterceptor {
+	const op = "StreamRecovery()"
+
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = recovered(ss.Context(), log, op, info.FullMethod, p)
+			}
+		}()
+
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,356 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"github.com/bookamovie/book/internal/broker/kafka"
+	"github.com/bookamovie/book/internal/lib/logger"
+	bookservice "github.com/bookamovie/book/internal/services/book"
+	"github.com/bookamovie/book/internal/utils"
+)
+
+const (
+	PaymentDeclinedEventType    = "payment.declined"
+	ScreeningCancelledEventType = "screening.cancelled"
+)
+
+var (
+	ErrUnknownEvent = fmt.Errorf("inbound event is unknown")
+	ErrInvalidEvent = fmt.Errorf("inbound event is invalid")
+)
+
+// Transitioner{} abstracts the service applying inbound events to bookings.
+type Transitioner interface {
+	DeclinePayment(ctx context.Context, ticket string) error
+	CancelScreening(ctx context.Context, screening *bookservice.Screening) (int, error)
+}
+
+// App{} represents the Kafka consumer group applying inbound payment and cinema events to bookings.
+//
+// It handles configuration, logging, and startup/shutdown lifecycle. Without configured topics, Group is nil and the App does nothing.
+type App struct {
+	Group   sarama.ConsumerGroup
+	Handler *Handler
+	Log     *logger.Logger
+
+	config  utils.Config
+	running atomic.Bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// New() initializes and returns a new instance of the consumer App, joining the configured consumer group.
+func New(log *logger.Logger, cfg utils.Config, storage bookservice.Querier) (*App, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	a := &App{
+		// The consumer only queues events: the outbox relay publishes them, so it needs no broker.
+		Handler: NewHandler(log, cfg, bookservice.New(cfg, log, storage, nil, nil, nil)),
+		Log:     log,
+
+		config: cfg,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	if len(cfg.ConsumerConfig.Topics) == 0 {
+		return a, nil
+	}
+
+	saramaCfg, err := kafka.NewConsumerConfig(cfg)
+	if err != nil {
+		cancel()
+
+		return &App{}, err
+	}
+
+	a.Group, err = sarama.NewConsumerGroup(cfg.KafkaConfig.Addresses, cfg.ConsumerConfig.Group, saramaCfg)
+	if err != nil {
+		cancel()
+
+		return &App{}, err
+	}
+
+	return a, nil
+}
+
+// Run() consumes the configured topics as a member of the consumer group.
+//
+// A session ends whenever the group rebalances, and a new one is joined right away. After a failure, it waits the configured backoff before joining again. It blocks until Shutdown() is called.
+func (a *App) Run() error {
+	const op = "Run()"
+
+	if a.Group == nil {
+		return nil
+	}
+
+	a.running.Store(true)
+	defer close(a.done)
+
+	for {
+		err := a.Group.Consume(a.ctx, a.config.ConsumerConfig.Topics, a.Handler)
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) || a.ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			a.Log.Logs.BrokerLog.Error(
+				"can't consume",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+
+			select {
+			case <-a.ctx.Done():
+				return nil
+
+			case <-time.After(a.config.ConsumerConfig.Backoff):
+			}
+		}
+	}
+}
+
+// Shutdown() stops consuming, waits for the event in progress, if any, to be applied, and leaves the consumer group.
+func (a *App) Shutdown() {
+	const op = "Shutdown()"
+
+	a.cancel()
+
+	if a.Group == nil {
+		return
+	}
+
+	if a.running.Load() {
+		<-a.done
+	}
+
+	err := a.Group.Close()
+	if err != nil {
+		a.Log.Logs.BrokerLog.Error(
+			"can't leave the consumer group",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// Handler{} implements sarama.ConsumerGroupHandler, applying the inbound events of every claimed partition in order.
+//
+// A message is marked only once its event is applied, and only marked offsets are committed, in batches, so events are applied at least once. Events that can never be applied, because they are malformed, unknown, or about bookings that don't exist or aren't booked anymore, are logged and skipped. Others are retried until they succeed, holding back the rest of their partition.
+type Handler struct {
+	Service Transitioner
+	Log     *logger.Logger
+
+	config utils.Config
+}
+
+// NewHandler() initializes and returns a new Handler applying events through the given service.
+func NewHandler(log *logger.Logger, cfg utils.Config, service Transitioner) *Handler {
+	return &Handler{
+		Service: service,
+		Log:     log,
+
+		config: cfg,
+	}
+}
+
+// Setup() is called when a session starts. There's nothing to set up.
+func (h *Handler) Setup(session sarama.ConsumerGroupSession) error { return nil }
+
+// Cleanup() is called when a session ends. There's nothing to clean up.
+func (h *Handler) Cleanup(session sarama.ConsumerGroupSession) error { return nil }
+
+// ConsumeClaim() applies the events of a claimed partition one by one, marking each of them once applied.
+//
+// Marked offsets are committed in the background rather than after every event, which would make a round trip to Kafka per event. It returns once the session ends, without marking the event in progress, which the next owner of the partition gets again.
+func (h *Handler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+
+			if !h.handle(session.Context(), msg) {
+				return nil
+			}
+
+			session.MarkMessage(msg, "")
+		}
+	}
+}
+
+// handle() applies the event of a message, retrying failures worth retrying with backoff.
+//
+// Reports false if the context ended before the event could be applied.
+func (h *Handler) handle(ctx context.Context, msg *sarama.ConsumerMessage) bool {
+	const op = "handle()"
+
+	backoff := h.config.ConsumerConfig.Backoff
+
+	for {
+		err := h.apply(ctx, msg)
+		if err == nil {
+			return true
+		}
+
+		if permanent(err) {
+			h.Log.Logs.BrokerLog.Warn(
+				"skipping an inbound event",
+				slog.String("op", op),
+				slog.String("topic", msg.Topic),
+				slog.Any("partition", msg.Partition),
+				slog.Any("offset", msg.Offset),
+				slog.String("error", err.Error()),
+			)
+
+			return true
+		}
+
+		h.Log.Logs.BrokerLog.Error(
+			"can't apply an inbound event, retrying",
+			slog.String("op", op),
+			slog.String("topic", msg.Topic),
+			slog.Any("partition", msg.Partition),
+			slog.Any("offset", msg.Offset),
+			slog.Duration("backoff", backoff),
+			slog.String("error", err.Error()),
+		)
+
+		select {
+		case <-ctx.Done():
+			return false
+
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, h.config.ConsumerConfig.MaxBackoff)
+	}
+}
+
+// permanent() reports whether an error would happen again however many times the event is retried.
+func permanent(err error) bool {
+	return errors.Is(err, ErrUnknownEvent) ||
+		errors.Is(err, ErrInvalidEvent) ||
+		errors.Is(err, bookservice.ErrNotFound) ||
+		errors.Is(err, bookservice.ErrAlreadyCancelled)
+}
+
+// envelope{} is an inbound event in CloudEvents structured JSON.
+type envelope struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// PaymentDeclined{} is the data of a payment.declined event.
+type PaymentDeclined struct {
+	Ticket string `json:"ticket"`
+}
+
+// ScreeningCancelled{} is the data of a screening.cancelled event.
+type ScreeningCancelled struct {
+	Cinema   string    `json:"cinema"`
+	Location string    `json:"location"`
+	Screen   int32     `json:"screen"`
+	Date     time.Time `json:"date"`
+}
+
+// apply() decodes the event of a message and applies it to bookings.
+//
+// Events are either in CloudEvents structured JSON, or in binary mode: a ce_type header, with the data alone as the value.
+func (h *Handler) apply(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	const op = "apply()"
+
+	var event envelope
+
+	for _, header := range msg.Headers {
+		if string(header.Key) == "ce_type" {
+			event.Type = string(header.Value)
+			event.Data = msg.Value
+		}
+	}
+
+	if event.Type == "" {
+		err := json.Unmarshal(msg.Value, &event)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidEvent, err)
+		}
+	}
+
+	switch event.Type {
+	case PaymentDeclinedEventType:
+		var data PaymentDeclined
+
+		err := json.Unmarshal(event.Data, &data)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidEvent, err)
+		}
+
+		if data.Ticket == "" {
+			return fmt.Errorf("%w: %s without a ticket", ErrInvalidEvent, event.Type)
+		}
+
+		err = h.Service.DeclinePayment(ctx, data.Ticket)
+		if err != nil {
+			return err
+		}
+
+		h.Log.Logs.BrokerLog.Info(
+			"declined a booking",
+			slog.String("op", op),
+			slog.String("ticket", data.Ticket),
+		)
+
+		return nil
+
+	case ScreeningCancelledEventType:
+		var data ScreeningCancelled
+
+		err := json.Unmarshal(event.Data, &data)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidEvent, err)
+		}
+
+		if data.Cinema == "" || data.Location == "" || data.Screen == 0 || data.Date.IsZero() {
+			return fmt.Errorf("%w: %s without a cinema, location, screen or date", ErrInvalidEvent, event.Type)
+		}
+
+		cancelled, err := h.Service.CancelScreening(ctx, &bookservice.Screening{
+			Cinema:   data.Cinema,
+			Location: data.Location,
+			Screen:   data.Screen,
+			Date:     data.Date,
+		})
+		if err != nil {
+			return err
+		}
+
+		h.Log.Logs.BrokerLog.Info(
+			"cancelled a screening",
+			slog.String("op", op),
+			slog.String("cinema", data.Cinema),
+			slog.String("location", data.Location),
+			slog.Any("screen", data.Screen),
+			slog.Time("date", data.Date),
+			slog.Int("cancelled", cancelled),
+		)
+
+		return nil
+
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownEvent, event.Type)
+	}
+}
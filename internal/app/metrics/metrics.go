@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bookamovie/book/internal/lib/logger"
+	"github.com/bookamovie/book/internal/lib/metrics"
+	"github.com/bookamovie/book/internal/utils"
+)
+
+// shutdownTimeout is how long scrapes in progress are given to finish on shutdown.
+const shutdownTimeout = 5 * time.Second
+
+// App{} represents the HTTP listener exposing Prometheus metrics on /metrics.
+//
+// It handles configuration, logging, and startup/shutdown lifecycle. Without a configured metrics address, Server is nil and the App does nothing.
+type App struct {
+	Server *http.Server
+	Log    *logger.Logger
+
+	config utils.Config
+}
+
+// New() initializes and returns a new instance of the metrics App.
+func New(log *logger.Logger, cfg utils.Config) *App {
+	a := &App{
+		Log: log,
+
+		config: cfg,
+	}
+
+	if cfg.BookConfig.MetricsAddress == "" {
+		return a
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	a.Server = &http.Server{
+		Addr:              cfg.BookConfig.MetricsAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: shutdownTimeout,
+	}
+
+	return a
+}
+
+// Run() serves the metrics on the configured address.
+//
+// It blocks until Shutdown() is called, and returns any critical error if the listener fails to start.
+func (a *App) Run() error {
+	if a.Server == nil {
+		return nil
+	}
+
+	err := a.Server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// Shutdown() stops the listener, waiting for scrapes in progress to finish.
+func (a *App) Shutdown() {
+	const op = "Shutdown()"
+
+	if a.Server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := a.Server.Shutdown(ctx)
+	if err != nil {
+		a.Log.Logs.AppLog.Error(
+			"can't shut the metrics listener down",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+	}
+}
@@ -0,0 +1,120 @@
+package relay
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookamovie/book/internal/lib/logger"
+	bookservice "github.com/bookamovie/book/internal/services/book"
+	"github.com/bookamovie/book/internal/utils"
+)
+
+// maxBackoff caps how many poll intervals the relay waits after consecutive failures.
+const maxBackoff = 32
+
+// Relayer{} abstracts the service publishing the outbox.
+type Relayer interface {
+	RelayOutbox(ctx context.Context) (int, error)
+}
+
+// App{} represents the background relay that publishes outbox messages to the broker.
+//
+// It handles configuration, logging, and startup/shutdown lifecycle.
+type App struct {
+	Service Relayer
+	Log     *logger.Logger
+
+	config  utils.Config
+	running atomic.Bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// New() initializes and returns a new instance of the relay App.
+func New(log *logger.Logger, cfg utils.Config, storage bookservice.Querier, broker bookservice.Brokerer) *App {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &App{
+		// The relay never books, so it needs neither a ticket generator nor keys.
+		Service: bookservice.New(cfg, log, storage, broker, nil, nil),
+		Log:     log,
+
+		config: cfg,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+// Run() publishes pending outbox messages every configured poll interval.
+//
+// Batches run with a context cancelled by Shutdown(). Full batches are followed by the next one right away, counting the messages delivered to every sink together. After a failure, the wait doubles with every consecutive failure, up to maxBackoff poll intervals. It blocks until Shutdown() is called.
+func (a *App) Run() error {
+	const op = "Run()"
+
+	a.running.Store(true)
+	defer close(a.done)
+
+	backoff := 1
+
+	timer := time.NewTimer(a.config.OutboxConfig.PollInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return nil
+
+		case <-timer.C:
+			published, err := a.Service.RelayOutbox(a.ctx)
+			if err != nil {
+				if a.ctx.Err() != nil {
+					return nil
+				}
+
+				a.Log.Logs.BrokerLog.Error(
+					"can't relay the outbox",
+					slog.String("op", op),
+					slog.Int("published", published),
+					slog.Int("backoff", backoff),
+					slog.String("error", err.Error()),
+				)
+
+				timer.Reset(time.Duration(backoff) * a.config.OutboxConfig.PollInterval)
+				backoff = min(backoff*2, maxBackoff)
+
+				continue
+			}
+
+			backoff = 1
+
+			if published > 0 {
+				a.Log.Logs.BrokerLog.Debug(
+					"relayed the outbox",
+					slog.String("op", op),
+					slog.Int("published", published),
+				)
+			}
+
+			if published >= a.config.OutboxConfig.BatchSize {
+				timer.Reset(0)
+			} else {
+				timer.Reset(a.config.OutboxConfig.PollInterval)
+			}
+		}
+	}
+}
+
+// Shutdown() stops the relay and waits for the batch in progress, if any, to give up.
+//
+// The batch is cancelled rather than waited for, so a broker retrying an unreachable endpoint doesn't hold the shutdown. The messages it didn't deliver are relayed again on the next start.
+func (a *App) Shutdown() {
+	a.cancel()
+
+	if a.running.Load() {
+		<-a.done
+	}
+}
@@ -0,0 +1,102 @@
+package sweeper
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookamovie/book/internal/lib/logger"
+	bookservice "github.com/bookamovie/book/internal/services/book"
+	"github.com/bookamovie/book/internal/storage"
+	"github.com/bookamovie/book/internal/utils"
+)
+
+// App{} represents the background sweeper that releases expired seat holds and purges expired idempotency keys.
+//
+// It handles configuration, logging, and startup/shutdown lifecycle.
+type App struct {
+	Storage bookservice.Querier
+	Log     *logger.Logger
+
+	config  utils.Config
+	running atomic.Bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// New() initializes and returns a new instance of the sweeper App.
+func New(log *logger.Logger, cfg utils.Config, storage bookservice.Querier) *App {
+	return &App{
+		Storage: storage,
+		Log:     log,
+
+		config: cfg,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Run() releases expired holds and purges expired idempotency keys every configured sweep interval.
+//
+// It blocks until Shutdown() is called.
+func (a *App) Run() error {
+	const op = "Run()"
+
+	a.running.Store(true)
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.config.HoldConfig.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stop:
+			return nil
+
+		case now := <-ticker.C:
+			released, err := a.Storage.ReleaseExpiredHolds(context.Background(), &storage.ReleaseExpiredHoldsQuery{
+				Now: now,
+			})
+			if err != nil {
+				a.Log.Logs.AppLog.Error(
+					"can't release expired holds",
+					slog.String("op", op),
+					slog.String("error", err.Error()),
+				)
+			} else if released > 0 {
+				a.Log.Logs.AppLog.Debug(
+					"released expired holds",
+					slog.String("op", op),
+					slog.Int64("released", released),
+				)
+			}
+
+			purged, err := a.Storage.PurgeExpiredIdempotencyKeys(context.Background(), &storage.PurgeExpiredIdempotencyKeysQuery{
+				Now: now,
+			})
+			if err != nil {
+				a.Log.Logs.AppLog.Error(
+					"can't purge expired idempotency keys",
+					slog.String("op", op),
+					slog.String("error", err.Error()),
+				)
+			} else if purged > 0 {
+				a.Log.Logs.AppLog.Debug(
+					"purged expired idempotency keys",
+					slog.String("op", op),
+					slog.Int64("purged", purged),
+				)
+			}
+		}
+	}
+}
+
+// Shutdown() stops the sweeper and waits for the sweep in progress, if any, to finish.
+func (a *App) Shutdown() {
+	close(a.stop)
+
+	if a.running.Load() {
+		<-a.done
+	}
+}
@@ -0,0 +1,105 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bookamovie/book/internal/broker/kafka"
+	bookservice "github.com/bookamovie/book/internal/services/book"
+)
+
+// Broker{} sends every event through several brokers at once, e.g. Kafka and webhooks, each one a named sink.
+//
+// An event is sent through every broker even if some fail, and failures are joined. The outbox doesn't go through these methods though: it relays events to each of the Sinks() on its own, so a failing sink neither holds back the others nor gets them the same event again.
+type Broker struct {
+	Brokers []bookservice.Sink
+}
+
+// New() returns a Broker fanning out to the given sinks.
+func New(sinks ...bookservice.Sink) *Broker {
+	return &Broker{
+		Brokers: sinks,
+	}
+}
+
+// Sinks() returns the sinks the outbox relays events to.
+func (b *Broker) Sinks() []bookservice.Sink {
+	return b.Brokers
+}
+
+// BookNotify() sends a BookNotifyEvent through every broker.
+func (b *Broker) BookNotify(ctx context.Context, event *kafka.BookNotifyEvent) error {
+	var errs []error
+
+	for _, sink := range b.Brokers {
+		errs = append(errs, sink.Broker.BookNotify(ctx, event))
+	}
+
+	return errors.Join(errs...)
+}
+
+// BookManyNotify() sends a BookManyNotifyEvent through every broker.
+func (b *Broker) BookManyNotify(ctx context.Context, event *kafka.BookManyNotifyEvent) error {
+	var errs []error
+
+	for _, sink := range b.Brokers {
+		errs = append(errs, sink.Broker.BookManyNotify(ctx, event))
+	}
+
+	return errors.Join(errs...)
+}
+
+// BookCancelNotify() sends a BookCancelEvent through every broker.
+func (b *Broker) BookCancelNotify(ctx context.Context, event *kafka.BookCancelEvent) error {
+	var errs []error
+
+	for _, sink := range b.Brokers {
+		errs = append(errs, sink.Broker.BookCancelNotify(ctx, event))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Flush() flushes every broker that sends events in the background.
+//
+// Returns the fewest events delivered by a broker that failed, along with the joined failures.
+func (b *Broker) Flush(ctx context.Context) (int, error) {
+	var errs []error
+	delivered := -1
+
+	for _, sink := range b.Brokers {
+		flusher, ok := sink.Broker.(bookservice.Flusher)
+		if !ok {
+			continue
+		}
+
+		n, err := flusher.Flush(ctx)
+		if err != nil {
+			errs = append(errs, err)
+
+			if delivered == -1 || n < delivered {
+				delivered = n
+			}
+		}
+	}
+
+	return max(delivered, 0), errors.Join(errs...)
+}
+
+// Ping() pings every broker, joining their failures.
+func (b *Broker) Ping(ctx context.Context) error {
+	var errs []error
+
+	for _, sink := range b.Brokers {
+		errs = append(errs, sink.Broker.Ping(ctx))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Shutdown() shuts every broker down, in order.
+func (b *Broker) Shutdown() {
+	for _, sink := range b.Brokers {
+		sink.Broker.Shutdown()
+	}
+}
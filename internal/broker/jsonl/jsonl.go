@@ -0,0 +1,291 @@
+package jsonl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bookamovie/book/internal/broker/kafka"
+	"github.com/bookamovie/book/internal/lib/logger"
+	"github.com/bookamovie/book/internal/utils"
+)
+
+// rotatedFormat is the timestamp rotated files are suffixed with.
+const rotatedFormat = "20060102T150405.000000000"
+
+var (
+	ErrNoPath = fmt.Errorf("file sink path must be specified")
+)
+
+// Broker{} appends booking events to a file as JSON lines, so the event stream can be inspected without Kafka. Every line is a flat Line, whatever the configured encoding.
+//
+// The file is rotated once it grows past the configured size, or on the first event of a new UTC day if daily rotation is on. Rotated files are renamed with the rotation time inserted before their extension, e.g. events-20250416T190000.000000000.jsonl.
+type Broker struct {
+	Log *logger.Logger
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	config utils.Config
+}
+
+// Line{} is a line of the file: a booking of an event, as a flat JSON object with snake_case keys, like the lines of requests.jsonl.
+//
+// Events with several bookings take a line per booking, all with the same EventID.
+type Line struct {
+	EventID  string    `json:"event_id"`
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	TraceID  string    `json:"trace_id,omitempty"`
+	Ticket   string    `json:"ticket"`
+	Cinema   string    `json:"cinema"`
+	Location string    `json:"location"`
+	Movie    string    `json:"movie"`
+	Screen   int32     `json:"screen"`
+	Seat     int32     `json:"seat"`
+	Session  time.Time `json:"session"`
+}
+
+// New() opens the configured file, creating it and its directory if needed, and returns a Broker appending to it.
+func New(cfg utils.Config, log *logger.Logger) (*Broker, error) {
+	if cfg.FileConfig.Path == "" {
+		return &Broker{}, ErrNoPath
+	}
+
+	b := &Broker{
+		Log: log,
+
+		config: cfg,
+	}
+
+	err := b.open()
+	if err != nil {
+		return &Broker{}, err
+	}
+
+	return b, nil
+}
+
+// open() opens the configured file for appending.
+//
+// An existing file counts as opened when it was last written, so a restart on a new day still rotates it.
+func (b *Broker) open() error {
+	err := os.MkdirAll(filepath.Dir(b.config.FileConfig.Path), 0777)
+	if err != nil {
+		return err
+	}
+
+	file, err := utils.OpenFile(b.config.FileConfig.Path)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+
+		return err
+	}
+
+	b.file = file
+	b.size = info.Size()
+	b.opened = time.Now()
+
+	if b.size > 0 {
+		b.opened = info.ModTime()
+	}
+
+	return nil
+}
+
+// Ping() reports whether the file is still open, as health checks do.
+func (b *Broker) Ping(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, err := b.file.Stat()
+
+	return err
+}
+
+// Shutdown() flushes the file to disk and closes it.
+func (b *Broker) Shutdown() {
+	const op = "Shutdown()"
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := b.file.Sync()
+	if err != nil {
+		b.Log.Logs.BrokerLog.Error(
+			"can't sync the file",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+	}
+
+	b.file.Close()
+}
+
+// BookNotify() appends a BookNotifyEvent to the file.
+func (b *Broker) BookNotify(ctx context.Context, event *kafka.BookNotifyEvent) error {
+	const op = "BookNotify()"
+
+	return b.append(ctx, op, kafka.BookNotifyEventType, event)
+}
+
+// BookManyNotify() appends a BookManyNotifyEvent to the file, as a line per booking.
+func (b *Broker) BookManyNotify(ctx context.Context, event *kafka.BookManyNotifyEvent) error {
+	const op = "BookManyNotify()"
+
+	return b.append(ctx, op, kafka.BookManyNotifyEventType, event)
+}
+
+// BookCancelNotify() appends a BookCancelEvent to the file.
+func (b *Broker) BookCancelNotify(ctx context.Context, event *kafka.BookCancelEvent) error {
+	const op = "BookCancelNotify()"
+
+	return b.append(ctx, op, kafka.BookCancelEventType, event)
+}
+
+// append() writes the lines of an event at once, rotating the file first if it's due.
+func (b *Broker) append(ctx context.Context, op string, eventType string, event any) error {
+	now := time.Now()
+
+	env, err := kafka.NewEnvelope(b.config.EventsConfig.Source, eventType, event)
+	if err != nil {
+		b.Log.Logs.BrokerLog.ErrorContext(
+			ctx,
+			"can't encode an event",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	line, err := lines(env)
+	if err != nil {
+		b.Log.Logs.BrokerLog.ErrorContext(
+			ctx,
+			"can't encode an event",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rotationDue(now, len(line)) {
+		err = b.rotate(now)
+		if err != nil {
+			b.Log.Logs.BrokerLog.ErrorContext(
+				ctx,
+				"can't rotate the file",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+
+			return err
+		}
+	}
+
+	n, err := b.file.Write(line)
+	b.size += int64(n)
+	if err != nil {
+		b.Log.Logs.BrokerLog.ErrorContext(
+			ctx,
+			"can't write an event",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	return nil
+}
+
+// lines() encodes the bookings of an envelope as JSON lines, each ending with a newline.
+func lines(env *kafka.Envelope) ([]byte, error) {
+	var out []byte
+
+	for _, booking := range env.Bookings {
+		line, err := json.Marshal(&Line{
+			EventID:  env.ID,
+			Type:     env.Type,
+			Time:     env.Time.UTC(),
+			TraceID:  env.TraceID,
+			Ticket:   booking.Ticket,
+			Cinema:   booking.Data.GetCinema().GetName(),
+			Location: booking.Data.GetCinema().GetLocation(),
+			Movie:    booking.Data.GetMovie().GetTitle(),
+			Screen:   booking.Data.GetSession().GetScreen(),
+			Seat:     booking.Data.GetSession().GetSeat(),
+			Session:  booking.Data.GetSession().GetDate().AsTime().UTC(),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, line...)
+		out = append(out, '\n')
+	}
+
+	return out, nil
+}
+
+// rotationDue() reports whether the file has to be rotated before writing n more bytes at now.
+//
+// An empty file is never rotated, so a line larger than the maximum size still gets written.
+func (b *Broker) rotationDue(now time.Time, n int) bool {
+	if b.size == 0 {
+		return false
+	}
+
+	if b.config.FileConfig.MaxSize > 0 && b.size+int64(n) > b.config.FileConfig.MaxSize {
+		return true
+	}
+
+	if b.config.FileConfig.Daily {
+		y1, m1, d1 := b.opened.UTC().Date()
+		y2, m2, d2 := now.UTC().Date()
+
+		return y1 != y2 || m1 != m2 || d1 != d2
+	}
+
+	return false
+}
+
+// rotate() syncs and closes the file, renames it with the rotation time, and opens a new one in its place.
+func (b *Broker) rotate(now time.Time) error {
+	err := b.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	b.file.Close()
+
+	path := b.config.FileConfig.Path
+	ext := filepath.Ext(path)
+
+	err = os.Rename(path, strings.TrimSuffix(path, ext)+"-"+now.UTC().Format(rotatedFormat)+ext)
+	if err != nil {
+		// The file is reopened as is, so the next events are still written somewhere.
+		return errors.Join(err, b.open())
+	}
+
+	return b.open()
+}
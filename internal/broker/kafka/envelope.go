@@ -0,0 +1,216 @@
+package kafka
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+	eventsv1 "github.com/bookamovie/book/pkg/events/v1"
+	"github.com/oklog/ulid/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	EncodingJSON  = "json"
+	EncodingProto = "proto"
+
+	// SpecVersion is the CloudEvents version envelopes follow.
+	SpecVersion = "1.0"
+
+	// SchemaVersion is the version of the envelope schema, bumped on breaking changes.
+	SchemaVersion = "1"
+)
+
+var (
+	ErrUnknownEncoding = fmt.Errorf("event encoding is unknown")
+	ErrUnknownEvent    = fmt.Errorf("event is unknown")
+)
+
+// Meta{} identifies an event. It is set once, when the event is written to the outbox, so every retry and every sink shares the same ID.
+//
+// RequestID is the ID of the request the event comes from, if any, so its publishing can be logged along with it. TraceParent is the W3C traceparent of the span of that request, if it was traced, so its publishing joins the same trace.
+type Meta struct {
+	ID          string
+	Time        time.Time
+	TraceID     string
+	RequestID   string
+	TraceParent string
+}
+
+// NewMeta() returns the Meta of an event happening now, with a new ID and trace ID.
+//
+// Trace IDs are random 16-byte hex strings, as in W3C Trace Context.
+func NewMeta() Meta {
+	trace := make([]byte, 16)
+	rand.Read(trace)
+
+	return Meta{
+		ID:      ulid.Make().String(),
+		Time:    time.Now().UTC(),
+		TraceID: hex.EncodeToString(trace),
+	}
+}
+
+// Envelope{} wraps an event with the CloudEvents attributes it is published with.
+type Envelope struct {
+	Meta
+
+	Type     string
+	Source   string
+	Bookings []*BookNotifyEvent
+}
+
+// NewEnvelope() wraps an event of the given type, published by source.
+//
+// Events written to the outbox before they had a Meta get a new one. Returns ErrUnknownEvent for anything but the events of this package.
+func NewEnvelope(source string, eventType string, event any) (*Envelope, error) {
+	env := &Envelope{
+		Type:   eventType,
+		Source: source,
+	}
+
+	switch e := event.(type) {
+	case *BookNotifyEvent:
+		env.Meta = e.Meta
+		env.Bookings = []*BookNotifyEvent{e}
+
+	case *BookManyNotifyEvent:
+		env.Meta = e.Meta
+		env.Bookings = e.Bookings
+
+	case *BookCancelEvent:
+		env.Meta = e.Meta
+		env.Bookings = []*BookNotifyEvent{{Ticket: e.Ticket, Data: e.Data}}
+
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnknownEvent, event)
+	}
+
+	if env.ID == "" {
+		env.Meta = NewMeta()
+	}
+
+	return env, nil
+}
+
+// ValidateEncoding() returns ErrUnknownEncoding unless encoding is EncodingJSON or EncodingProto, so misconfigured brokers fail at startup rather than on the first event.
+func ValidateEncoding(encoding string) error {
+	if encoding != EncodingJSON && encoding != EncodingProto {
+		return fmt.Errorf("%w: %s", ErrUnknownEncoding, encoding)
+	}
+
+	return nil
+}
+
+// ContentType() returns the content type of the envelope encoded with the given encoding.
+func ContentType(encoding string) string {
+	if encoding == EncodingProto {
+		return "application/protobuf; messageType=bookamovie.book.events.v1.Data"
+	}
+
+	return "application/cloudevents+json"
+}
+
+// Encode() serializes the envelope with the given encoding, either EncodingJSON or EncodingProto.
+//
+// JSON events are in CloudEvents structured mode: the value is the whole Envelope message of proto/bookamovie/book/events/v1/events.proto. Protobuf events are in binary mode: the value is its Data message alone, the attributes going in headers, see Attributes().
+//
+// Returns ErrUnknownEncoding for any other encoding, or the error of the serialization itself: events are never published half-encoded.
+func (e *Envelope) Encode(encoding string) ([]byte, error) {
+	switch encoding {
+	case EncodingJSON:
+		return protojson.Marshal(e.message())
+
+	case EncodingProto:
+		return proto.Marshal(e.data())
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEncoding, encoding)
+	}
+}
+
+// Attribute{} is a CloudEvents attribute of an envelope, named as in the Envelope message.
+type Attribute struct {
+	Name  string
+	Value string
+}
+
+// Attributes() returns the CloudEvents attributes events in binary mode carry as headers, but for datacontenttype, which is their content type.
+func (e *Envelope) Attributes() []Attribute {
+	attrs := []Attribute{
+		{Name: "specversion", Value: SpecVersion},
+		{Name: "id", Value: e.ID},
+		{Name: "source", Value: e.Source},
+		{Name: "type", Value: e.Type},
+		{Name: "time", Value: e.Time.UTC().Format(time.RFC3339Nano)},
+		{Name: "schemaversion", Value: SchemaVersion},
+	}
+
+	if e.TraceID != "" {
+		attrs = append(attrs, Attribute{Name: "traceid", Value: e.TraceID})
+	}
+
+	return attrs
+}
+
+// Headers() returns the Kafka headers of the envelope encoded with the given encoding, following the CloudEvents Kafka binding.
+//
+// Protobuf events are in binary mode, so their attributes are "ce_"-prefixed headers. JSON events are in structured mode, where the attributes are in the value only. The "event" header is kept for consumers that predate the envelope, and lets both route events without decoding them.
+func (e *Envelope) Headers(encoding string) []sarama.RecordHeader {
+	headers := []sarama.RecordHeader{
+		{Key: []byte("event"), Value: []byte(e.Type)},
+		{Key: []byte("content-type"), Value: []byte(ContentType(encoding))},
+	}
+
+	if encoding == EncodingProto {
+		for _, attr := range e.Attributes() {
+			headers = append(headers, sarama.RecordHeader{Key: []byte("ce_" + attr.Name), Value: []byte(attr.Value)})
+		}
+	}
+
+	if e.RequestID != "" {
+		headers = append(headers, sarama.RecordHeader{Key: []byte("x-request-id"), Value: []byte(e.RequestID)})
+	}
+
+	return headers
+}
+
+// message() converts the envelope into an Envelope message, with its data in JSON.
+func (e *Envelope) message() *eventsv1.Envelope {
+	return &eventsv1.Envelope{
+		Specversion:     SpecVersion,
+		Id:              e.ID,
+		Source:          e.Source,
+		Type:            e.Type,
+		Time:            timestamppb.New(e.Time),
+		Datacontenttype: "application/json",
+		Schemaversion:   SchemaVersion,
+		Traceid:         e.TraceID,
+		Data:            e.data(),
+	}
+}
+
+// data() converts the bookings of the envelope into a Data message.
+func (e *Envelope) data() *eventsv1.Data {
+	data := &eventsv1.Data{
+		Bookings: make([]*eventsv1.Booking, 0, len(e.Bookings)),
+	}
+
+	for _, b := range e.Bookings {
+		data.Bookings = append(data.Bookings, &eventsv1.Booking{
+			Ticket:   b.Ticket,
+			Cinema:   b.Data.GetCinema().GetName(),
+			Location: b.Data.GetCinema().GetLocation(),
+			Movie:    b.Data.GetMovie().GetTitle(),
+			Screen:   b.Data.GetSession().GetScreen(),
+			Seat:     b.Data.GetSession().GetSeat(),
+			Session:  timestamppb.New(b.Data.GetSession().GetDate().AsTime()),
+		})
+	}
+
+	return data
+}
@@ -1,69 +1,497 @@
 package kafka
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/bookamovie/book/internal/lib/logger"
+	"github.com/bookamovie/book/internal/lib/metrics"
+	"github.com/bookamovie/book/internal/lib/tracing"
 	"github.com/bookamovie/book/internal/utils"
 	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
 )
 
+const (
+	ModeSync  = "sync"
+	ModeAsync = "async"
+
+	KeyTicket  = "ticket"
+	KeySession = "session"
+
+	PartitionerHash       = "hash"
+	PartitionerRoundRobin = "roundrobin"
+	PartitionerManual     = "manual"
+)
+
+var (
+	ErrUnknownMode        = fmt.Errorf("kafka producer mode is unknown")
+	ErrUnknownKey         = fmt.Errorf("kafka message key is unknown")
+	ErrUnknownPartitioner = fmt.Errorf("kafka partitioner is unknown")
+	ErrInvalidConfig      = fmt.Errorf("kafka config is invalid")
+	ErrUnavailable        = fmt.Errorf("kafka is unavailable")
+)
+
 // Broker{} represents a Kafka message broker that handles producing booking events to a Kafka topic.
+//
+// Depending on the mode, either Producer or AsyncProducer is set. The async one doesn't wait for Kafka to acknowledge messages: they are batched in the background, their outcome is logged, and Flush() waits for it. Client is the connection to the cluster they produce through, if it is known.
 type Broker struct {
-	Producer sarama.SyncProducer
-	Log      *logger.Logger
+	Producer      sarama.SyncProducer
+	AsyncProducer sarama.AsyncProducer
+	Client        sarama.Client
+	Log           *logger.Logger
 
-	config utils.Config
+	mu      sync.Mutex
+	pending []chan error
+	drained chan struct{}
+	config  utils.Config
 }
 
 // New() initializes and returns a new Kafka Broker with the given configuration.
 //
-// It creates a new synchronous Kafka producer using Sarama.
+// It creates a synchronous or an asynchronous Kafka producer using Sarama, depending on the configured mode. Returns ErrUnknownEncoding or ErrUnknownKey if the configured event encoding or message key is unknown, or the error of NewConfig() if the Kafka settings are invalid.
 func New(cfg utils.Config, log *logger.Logger) (*Broker, error) {
+	const op = "New()"
+
+	err := ValidateEncoding(cfg.EventsConfig.Encoding)
+	if err != nil {
+		return &Broker{}, err
+	}
+
+	if cfg.KafkaConfig.Key != KeyTicket && cfg.KafkaConfig.Key != KeySession {
+		return &Broker{}, fmt.Errorf("%w: %s", ErrUnknownKey, cfg.KafkaConfig.Key)
+	}
+
+	saramaCfg, err := NewConfig(cfg)
+	if err != nil {
+		return &Broker{}, err
+	}
+
+	if saramaCfg.Net.SASL.Enable && saramaCfg.Net.SASL.Mechanism == sarama.SASLTypePlaintext && !saramaCfg.Net.TLS.Enable {
+		log.Logs.BrokerLog.Warn(
+			"SASL PLAIN credentials are sent in the clear, enable TLS",
+			slog.String("op", op),
+		)
+	}
+
+	client, err := sarama.NewClient(cfg.KafkaConfig.Addresses, saramaCfg)
+	if err != nil {
+		return &Broker{}, err
+	}
+
+	var b *Broker
+
+	switch cfg.KafkaConfig.Mode {
+	case ModeSync:
+		producer, err := sarama.NewSyncProducerFromClient(client)
+		if err != nil {
+			client.Close()
+
+			return &Broker{}, err
+		}
+
+		b = NewSync(cfg, log, producer)
+
+	default:
+		producer, err := sarama.NewAsyncProducerFromClient(client)
+		if err != nil {
+			client.Close()
+
+			return &Broker{}, err
+		}
+
+		b = NewAsync(cfg, log, producer)
+	}
+
+	b.Client = client
+
+	return b, nil
+}
+
+// NewConfig() maps the configuration into a Sarama configuration, and validates it.
+//
+// Returns ErrUnknownMode or ErrUnknownPartitioner if the configured mode or partitioner is unknown, the errors of the TLS and SASL settings, or ErrInvalidConfig along with the reason Sarama rejects the result.
+func NewConfig(cfg utils.Config) (*sarama.Config, error) {
 	saramaCfg := sarama.NewConfig()
 
 	saramaCfg.Producer.Return.Successes = true
 
-	producer, err := sarama.NewSyncProducer(cfg.KafkaConfig.Addresses, saramaCfg)
+	partitioner, err := Partitioner(cfg.KafkaConfig.Partitioner)
 	if err != nil {
-		return &Broker{}, err
+		return nil, err
 	}
+	saramaCfg.Producer.Partitioner = partitioner
 
+	err = saramaCfg.Producer.Compression.UnmarshalText([]byte(cfg.KafkaConfig.Compression))
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.KafkaConfig.Mode != ModeSync && cfg.KafkaConfig.Mode != ModeAsync {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMode, cfg.KafkaConfig.Mode)
+	}
+
+	if cfg.KafkaConfig.Mode == ModeAsync {
+		saramaCfg.Producer.Flush.Messages = cfg.KafkaConfig.BatchSize
+		saramaCfg.Producer.Flush.Bytes = cfg.KafkaConfig.BatchBytes
+		saramaCfg.Producer.Flush.Frequency = cfg.KafkaConfig.Linger
+
+		// Retried batches would otherwise overtake the ones sent after them, breaking the order of a key.
+		if cfg.KafkaConfig.Partitioner == PartitionerHash {
+			saramaCfg.Net.MaxOpenRequests = 1
+		}
+	}
+
+	err = secure(cfg.KafkaConfig, saramaCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = saramaCfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	return saramaCfg, nil
+}
+
+// NewConsumerConfig() maps the configuration into the Sarama configuration of a consumer group, sharing the client ID, Kafka version, TLS and SASL settings of the producer.
+//
+// Consumers mark messages once they are handled, and the marked offsets are committed in the background every second, and when the session ends. New groups start from the oldest offset, so no message is missed.
+func NewConsumerConfig(cfg utils.Config) (*sarama.Config, error) {
+	saramaCfg := sarama.NewConfig()
+
+	saramaCfg.Consumer.Offsets.AutoCommit.Enable = true
+	saramaCfg.Consumer.Offsets.AutoCommit.Interval = time.Second
+	saramaCfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	err := secure(cfg.KafkaConfig, saramaCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = saramaCfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	return saramaCfg, nil
+}
+
+// Partitioner() returns the Sarama partitioner of the given name: PartitionerHash, PartitionerRoundRobin or PartitionerManual.
+//
+// Only the hash partitioner keeps the events of a key in order, by sending them all to the same partition.
+func Partitioner(name string) (sarama.PartitionerConstructor, error) {
+	switch name {
+	case PartitionerHash:
+		return sarama.NewHashPartitioner, nil
+
+	case PartitionerRoundRobin:
+		return sarama.NewRoundRobinPartitioner, nil
+
+	case PartitionerManual:
+		return sarama.NewManualPartitioner, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPartitioner, name)
+	}
+}
+
+// NewSync() returns a Broker producing through the given synchronous producer.
+func NewSync(cfg utils.Config, log *logger.Logger, producer sarama.SyncProducer) *Broker {
 	return &Broker{
 		Producer: producer,
 		Log:      log,
 
 		config: cfg,
-	}, nil
+	}
+}
+
+// NewAsync() returns a Broker producing through the given asynchronous producer, and starts draining its outcomes.
+//
+// The producer must return both successes and errors.
+func NewAsync(cfg utils.Config, log *logger.Logger, producer sarama.AsyncProducer) *Broker {
+	b := &Broker{
+		AsyncProducer: producer,
+		Log:           log,
+
+		drained: make(chan struct{}),
+		config:  cfg,
+	}
+
+	go b.drain()
+
+	return b
+}
+
+// Ping() reports whether the producer can still reach the cluster, as health checks do.
+//
+// It fetches the metadata of the topic, so at least one broker must answer. Returns ErrUnavailable otherwise, or once the producer is shut down. Brokers built around a given producer have no Client, and always succeed.
+func (b *Broker) Ping(ctx context.Context) error {
+	if b.Client == nil {
+		return nil
+	}
+
+	if b.Client.Closed() {
+		return fmt.Errorf("%w: the client is closed", ErrUnavailable)
+	}
+
+	err := b.Client.RefreshMetadata(b.config.KafkaConfig.Topic)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	return nil
+}
+
+// Flush() waits for the outcome of the messages produced in async mode since the last flush, so the outbox only marks the ones Kafka acknowledged.
+//
+// Returns the number of messages acknowledged, in the order they were produced, before the first one that failed, along with its error. Returns the error of ctx if it's done first: the outcome of the remaining messages is then unknown. In sync mode, messages are acknowledged as they are produced, and nothing is left to wait for.
+func (b *Broker) Flush(ctx context.Context) (int, error) {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	var err error
+	delivered := 0
+
+	for _, result := range pending {
+		select {
+		case perr := <-result:
+			if perr != nil && err == nil {
+				err = perr
+			}
+
+		case <-ctx.Done():
+			return delivered, ctx.Err()
+		}
+
+		if err == nil {
+			delivered++
+		}
+	}
+
+	return delivered, err
 }
 
 // Shutdown() gracefully closes the Kafka producer connection.
+//
+// In async mode, it waits for in-flight messages to be flushed and their outcome to be logged first.
 func (b *Broker) Shutdown() {
-	b.Producer.Close()
+	if b.AsyncProducer != nil {
+		b.AsyncProducer.AsyncClose()
+		<-b.drained
+	} else {
+		b.Producer.Close()
+	}
+
+	// Producers made from a client leave it open.
+	if b.Client != nil {
+		b.Client.Close()
+	}
+}
+
+// delivery{} is attached to asynchronously produced messages, so their outcome can be logged the same way synchronous ones are, and reported to Flush() through result.
+type delivery struct {
+	ctx    context.Context
+	op     string
+	event  any
+	result chan error
+}
+
+// drain() logs and reports the outcome of asynchronously produced messages, until the producer is closed.
+//
+// The producer closes both channels once every in-flight message is flushed, so drain() returning means nothing is left to send.
+func (b *Broker) drain() {
+	defer close(b.drained)
+
+	successes, errs := b.AsyncProducer.Successes(), b.AsyncProducer.Errors()
+
+	for successes != nil || errs != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+
+			d, _ := msg.Metadata.(delivery)
+			d.report(nil)
+
+			b.Log.Logs.BrokerLog.DebugContext(
+				d.ctx,
+				"message produced",
+				slog.String("op", d.op),
+				slog.Any("partition", msg.Partition),
+				slog.Any("offset", msg.Offset),
+				slog.Any("event", d.event),
+			)
+
+		case perr, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+
+			d, _ := perr.Msg.Metadata.(delivery)
+			d.report(perr.Err)
+
+			metrics.KafkaProduceErrors.Inc()
+
+			b.Log.Logs.BrokerLog.ErrorContext(
+				d.ctx,
+				"can't produce a message",
+				slog.String("op", d.op),
+				slog.String("error", perr.Err.Error()),
+			)
+		}
+	}
+}
+
+// report() hands the outcome of a message over to Flush(). Its result channel is buffered, so drain() never waits on it.
+func (d delivery) report(err error) {
+	if d.result != nil {
+		d.result <- err
+	}
 }
 
+const (
+	BookNotifyEventType     = "book.created"
+	BookManyNotifyEventType = "book.created.many"
+	BookCancelEventType     = "book.cancelled"
+)
+
 // BookNotifyEvent{} represents the data structure of a booking event that will be published to the Kafka topic.
 type BookNotifyEvent struct {
+	Meta
+
 	Ticket string
 	Data   *bookrpc.BookRequest
 }
 
 // BookNotify() sends a BookNotifyEvent to the configured Kafka topic.
 //
-// It serializes the event to JSON and logs success or failure.
-func (b *Broker) BookNotify(event *BookNotifyEvent) error {
+// It wraps the event in an Envelope and logs success or failure.
+func (b *Broker) BookNotify(ctx context.Context, event *BookNotifyEvent) error {
 	const op = "BookNotify()"
 
-	partition, offset, err := b.Producer.SendMessage(&sarama.ProducerMessage{
+	return b.produce(ctx, op, BookNotifyEventType, event)
+}
+
+// BookManyNotifyEvent{} groups the bookings made together by a single BookMany request.
+type BookManyNotifyEvent struct {
+	Meta
+
+	Bookings []*BookNotifyEvent
+}
+
+// BookManyNotify() sends a BookManyNotifyEvent to the configured Kafka topic as a single message.
+//
+// It wraps the event in an Envelope and logs success or failure.
+func (b *Broker) BookManyNotify(ctx context.Context, event *BookManyNotifyEvent) error {
+	const op = "BookManyNotify()"
+
+	return b.produce(ctx, op, BookManyNotifyEventType, event)
+}
+
+// BookCancelEvent{} represents a cancelled booking, telling consumers that its seat is free again.
+type BookCancelEvent struct {
+	Meta
+
+	Ticket string
+	Data   *bookrpc.BookRequest
+}
+
+// BookCancelNotify() sends a BookCancelEvent to the configured Kafka topic.
+//
+// It wraps the event in an Envelope and logs success or failure.
+func (b *Broker) BookCancelNotify(ctx context.Context, event *BookCancelEvent) error {
+	const op = "BookCancelNotify()"
+
+	return b.produce(ctx, op, BookCancelEventType, event)
+}
+
+// produce() wraps an event in an Envelope, encodes it with the configured encoding and sends it to the configured Kafka topic.
+//
+// The message is keyed by the configured field, so the hash partitioner keeps the events of a ticket or a session in order. The envelope attributes are attached as headers, so consumers can tell events apart without decoding them. An event that can't be encoded is never sent: the error is returned, and the outbox keeps it. In async mode, it returns as soon as the message is queued: failures are logged by drain() and returned by Flush() instead.
+//
+// Every produce is traced with a producer span, named after the broker method, whose W3C trace context is injected into the message headers so consumers can continue the trace. In async mode, the span ends once the message is queued.
+func (b *Broker) produce(ctx context.Context, op string, eventType string, event any) (err error) {
+	ctx, span := tracing.Start(ctx, "Broker."+strings.TrimSuffix(op, "()"),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingDestinationName(b.config.KafkaConfig.Topic),
+			semconv.CloudeventsEventType(eventType),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	env, err := NewEnvelope(b.config.EventsConfig.Source, eventType, event)
+	if err != nil {
+		b.Log.Logs.BrokerLog.ErrorContext(
+			ctx,
+			"can't encode an event",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	value, err := env.Encode(b.config.EventsConfig.Encoding)
+	if err != nil {
+		b.Log.Logs.BrokerLog.ErrorContext(
+			ctx,
+			"can't encode an event",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	msg := &sarama.ProducerMessage{
 		Topic:     b.config.KafkaConfig.Topic,
-		Value:     sarama.ByteEncoder(utils.MarshalJSON(event)),
+		Key:       b.key(env),
+		Headers:   env.Headers(b.config.EventsConfig.Encoding),
+		Value:     sarama.ByteEncoder(value),
 		Offset:    b.config.KafkaConfig.Offset,
 		Partition: b.config.KafkaConfig.Partition,
-	})
+	}
+	span.SetAttributes(semconv.CloudeventsEventID(env.ID))
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{msg: msg})
+
+	if b.AsyncProducer != nil {
+		result := make(chan error, 1)
+
+		b.mu.Lock()
+		b.pending = append(b.pending, result)
+		b.mu.Unlock()
+
+		msg.Metadata = delivery{ctx: ctx, op: op, event: event, result: result}
+		b.AsyncProducer.Input() <- msg
+
+		return nil
+	}
+
+	partition, offset, err := b.Producer.SendMessage(msg)
 	if err != nil {
-		b.Log.Logs.BrokerLog.Error(
+		metrics.KafkaProduceErrors.Inc()
+
+		b.Log.Logs.BrokerLog.ErrorContext(
+			ctx,
 			"can't produce a message",
 			slog.String("op", op),
 			slog.String("error", err.Error()),
@@ -71,7 +499,8 @@ func (b *Broker) BookNotify(event *BookNotifyEvent) error {
 
 		return err
 	}
-	b.Log.Logs.BookLog.Debug(
+	b.Log.Logs.BrokerLog.DebugContext(
+		ctx,
 		"message produced",
 		slog.String("op", op),
 		slog.Any("partition", partition),
@@ -82,13 +511,91 @@ func (b *Broker) BookNotify(event *BookNotifyEvent) error {
 	return nil
 }
 
+// key() returns the key of the message carrying an envelope, following the configured key field.
+//
+// Events about several bookings are keyed by their first one: the bookings of a BookMany request share their session. Returns nil, which leaves the message unkeyed, if the envelope has no booking.
+func (b *Broker) key(env *Envelope) sarama.Encoder {
+	if len(env.Bookings) == 0 {
+		return nil
+	}
+
+	booking := env.Bookings[0]
+
+	if b.config.KafkaConfig.Key == KeyTicket {
+		return sarama.StringEncoder(booking.Ticket)
+	}
+
+	return sarama.StringEncoder(strings.Join([]string{
+		booking.Data.GetCinema().GetName(),
+		booking.Data.GetCinema().GetLocation(),
+		strconv.Itoa(int(booking.Data.GetSession().GetScreen())),
+		booking.Data.GetSession().GetDate().AsTime().UTC().Format(time.RFC3339Nano),
+	}, "/"))
+}
+
+// headerCarrier{} adapts the headers of a Kafka message to the OpenTelemetry propagators.
+type headerCarrier struct {
+	msg *sarama.ProducerMessage
+}
+
+// Get() returns the value of the header with the given key, or an empty string if there's none.
+func (c headerCarrier) Get(key string) string {
+	for _, h := range c.msg.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+
+	return ""
+}
+
+// Set() sets the header with the given key, replacing its value if it is already set.
+func (c headerCarrier) Set(key string, value string) {
+	for i, h := range c.msg.Headers {
+		if string(h.Key) == key {
+			c.msg.Headers[i].Value = []byte(value)
+
+			return
+		}
+	}
+
+	c.msg.Headers = append(c.msg.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+// Keys() returns the keys of all headers.
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		keys = append(keys, string(h.Key))
+	}
+
+	return keys
+}
+
 // UnimplementedBroker{} is a stub that implements the Broker interface
 //
 // but does nothing. Useful for testing or placeholder functionality.
 type UnimplementedBroker struct{}
 
 // BookNotify() is the no-op implementation for the BookNotify method.
-func (u *UnimplementedBroker) BookNotify(event *BookNotifyEvent) error { return nil }
+func (u *UnimplementedBroker) BookNotify(ctx context.Context, event *BookNotifyEvent) error {
+	return nil
+}
+
+// BookManyNotify() is the no-op implementation for the BookManyNotify method.
+func (u *UnimplementedBroker) BookManyNotify(ctx context.Context, event *BookManyNotifyEvent) error {
+	return nil
+}
+
+// BookCancelNotify() is the no-op implementation for the BookCancelNotify method.
+func (u *UnimplementedBroker) BookCancelNotify(ctx context.Context, event *BookCancelEvent) error {
+	return nil
+}
+
+// Ping() is the no-op implementation for the Ping method.
+func (u *UnimplementedBroker) Ping(ctx context.Context) error {
+	return nil
+}
 
 // Shutdown() is the no-op implementation for the Shutdown method.
 func (u *UnimplementedBroker) Shutdown() {}
@@ -0,0 +1,171 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+
+	"github.com/bookamovie/book/internal/utils"
+)
+
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+var (
+	ErrInvalidVersion   = fmt.Errorf("kafka version is invalid")
+	ErrTLSDisabled      = fmt.Errorf("kafka tls files are set but tls is disabled")
+	ErrInvalidCA        = fmt.Errorf("kafka tls ca file holds no certificate")
+	ErrIncompleteCert   = fmt.Errorf("kafka tls cert_file and key_file must be set together")
+	ErrUnknownMechanism = fmt.Errorf("kafka sasl mechanism is unknown")
+	ErrNoCredentials    = fmt.Errorf("kafka sasl username and password must be specified")
+)
+
+// secure() maps the client ID, Kafka version, TLS and SASL settings into the Sarama configuration.
+func secure(cfg utils.KafkaConfig, saramaCfg *sarama.Config) error {
+	if cfg.ClientID != "" {
+		saramaCfg.ClientID = cfg.ClientID
+	}
+
+	if cfg.Version != "" {
+		version, err := sarama.ParseKafkaVersion(cfg.Version)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidVersion, cfg.Version)
+		}
+
+		saramaCfg.Version = version
+	}
+
+	tlsCfg, err := tlsConfig(cfg.TLS)
+	if err != nil {
+		return err
+	}
+
+	if tlsCfg != nil {
+		saramaCfg.Net.TLS.Enable = true
+		saramaCfg.Net.TLS.Config = tlsCfg
+	}
+
+	return sasl(cfg.SASL, saramaCfg)
+}
+
+// tlsConfig() builds the TLS configuration of the connections to the brokers, or returns nil if TLS is disabled.
+//
+// Returns ErrTLSDisabled if files are configured while TLS is disabled, so they aren't silently ignored.
+func tlsConfig(cfg utils.KafkaTLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		if cfg.CAFile != "" || cfg.CertFile != "" || cfg.KeyFile != "" {
+			return nil, ErrTLSDisabled
+		}
+
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCA, cfg.CAFile)
+		}
+
+		tlsCfg.RootCAs = pool
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, ErrIncompleteCert
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
+
+// sasl() maps the SASL settings into the Sarama configuration, leaving SASL disabled if no mechanism is configured.
+func sasl(cfg utils.KafkaSASLConfig, saramaCfg *sarama.Config) error {
+	if cfg.Mechanism == "" {
+		return nil
+	}
+
+	if cfg.Username == "" || cfg.Password == "" {
+		return ErrNoCredentials
+	}
+
+	switch cfg.Mechanism {
+	case SASLPlain:
+		saramaCfg.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+
+	case SASLScramSHA256:
+		saramaCfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		saramaCfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.HashGeneratorFcn(sha256.New)}
+		}
+
+	case SASLScramSHA512:
+		saramaCfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		saramaCfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.HashGeneratorFcn(sha512.New)}
+		}
+
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownMechanism, cfg.Mechanism)
+	}
+
+	saramaCfg.Net.SASL.Enable = true
+	saramaCfg.Net.SASL.Handshake = true
+	saramaCfg.Net.SASL.User = cfg.Username
+	saramaCfg.Net.SASL.Password = cfg.Password
+
+	return nil
+}
+
+// scramClient{} implements sarama.SCRAMClient, which Sarama leaves to applications, on top of xdg-go/scram.
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+// Begin() starts a new SCRAM conversation with the given credentials.
+func (c *scramClient) Begin(username, password, authzID string) error {
+	client, err := c.hash.NewClient(username, password, authzID)
+	if err != nil {
+		return err
+	}
+
+	c.conversation = client.NewConversation()
+
+	return nil
+}
+
+// Step() answers a challenge of the broker.
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+// Done() reports whether the conversation is over.
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
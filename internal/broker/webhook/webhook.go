@@ -0,0 +1,283 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bookamovie/book/internal/broker/kafka"
+	"github.com/bookamovie/book/internal/lib/logger"
+	"github.com/bookamovie/book/internal/utils"
+)
+
+const (
+	EventHeader     = "X-Book-Event"
+	TimestampHeader = "X-Book-Timestamp"
+	SignatureHeader = "X-Book-Signature"
+)
+
+var (
+	ErrNoURLs           = fmt.Errorf("webhook urls must be specified")
+	ErrNoSecret         = fmt.Errorf("webhook secret must be specified")
+	ErrInvalidURL       = fmt.Errorf("webhook url is invalid")
+	ErrUnexpectedStatus = fmt.Errorf("webhook responded with an unexpected status")
+	ErrUnreachable      = fmt.Errorf("webhook is unreachable")
+)
+
+// Broker{} posts booking events, wrapped in an Envelope, to the configured webhook URLs, for partners that can't consume Kafka.
+//
+// Every request is signed: the SignatureHeader holds "sha256=" followed by the hex HMAC-SHA256 of the TimestampHeader value, a dot, and the body, keyed with the configured secret. Receivers recompute it, and reject stale timestamps to stop replays.
+type Broker struct {
+	Client *http.Client
+	Log    *logger.Logger
+
+	config utils.Config
+}
+
+// New() initializes and returns a new webhook Broker with the given configuration.
+//
+// Returns an error if no URL or secret is configured, if a URL isn't an absolute http(s) one, or if the event encoding is unknown.
+func New(cfg utils.Config, log *logger.Logger) (*Broker, error) {
+	err := kafka.ValidateEncoding(cfg.EventsConfig.Encoding)
+	if err != nil {
+		return &Broker{}, err
+	}
+
+	if len(cfg.WebhookConfig.URLs) == 0 {
+		return &Broker{}, ErrNoURLs
+	}
+
+	if cfg.WebhookConfig.Secret == "" {
+		return &Broker{}, ErrNoSecret
+	}
+
+	for _, raw := range cfg.WebhookConfig.URLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &Broker{}, fmt.Errorf("%w: %s", ErrInvalidURL, raw)
+		}
+	}
+
+	return &Broker{
+		Client: &http.Client{
+			Timeout: cfg.WebhookConfig.Timeout,
+		},
+		Log: log,
+
+		config: cfg,
+	}, nil
+}
+
+// Ping() reports whether every webhook can be reached, as health checks do.
+//
+// It sends a HEAD request to each of them. Any response counts, as receivers may only accept signed POST requests: only webhooks that can't be connected to, or don't respond in time, are reported, with ErrUnreachable.
+func (b *Broker) Ping(ctx context.Context) error {
+	var errs []error
+
+	for _, u := range b.config.WebhookConfig.URLs {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: %s: %w", ErrUnreachable, u, err))
+			continue
+		}
+
+		resp, err := b.Client.Do(req)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: %s: %w", ErrUnreachable, u, err))
+			continue
+		}
+		resp.Body.Close()
+	}
+
+	return errors.Join(errs...)
+}
+
+// Shutdown() closes the idle connections kept to the webhooks.
+func (b *Broker) Shutdown() {
+	b.Client.CloseIdleConnections()
+}
+
+// BookNotify() posts a BookNotifyEvent to every configured webhook.
+func (b *Broker) BookNotify(ctx context.Context, event *kafka.BookNotifyEvent) error {
+	const op = "BookNotify()"
+
+	return b.post(ctx, op, kafka.BookNotifyEventType, event)
+}
+
+// BookManyNotify() posts a BookManyNotifyEvent to every configured webhook, as a single request.
+func (b *Broker) BookManyNotify(ctx context.Context, event *kafka.BookManyNotifyEvent) error {
+	const op = "BookManyNotify()"
+
+	return b.post(ctx, op, kafka.BookManyNotifyEventType, event)
+}
+
+// BookCancelNotify() posts a BookCancelEvent to every configured webhook.
+func (b *Broker) BookCancelNotify(ctx context.Context, event *kafka.BookCancelEvent) error {
+	const op = "BookCancelNotify()"
+
+	return b.post(ctx, op, kafka.BookCancelEventType, event)
+}
+
+// post() wraps an event in an Envelope, encodes it with the configured encoding and delivers it to every configured webhook.
+//
+// An event that can't be encoded is delivered to no webhook. Returns the errors of the webhooks the event couldn't be delivered to. Retrying the event gets it to webhooks that got it already again, which is why the outbox relays to every URL through a Broker of its own. Delivery is still at-least-once: receivers must deduplicate on the event ID.
+func (b *Broker) post(ctx context.Context, op string, eventType string, event any) error {
+	env, err := kafka.NewEnvelope(b.config.EventsConfig.Source, eventType, event)
+	if err != nil {
+		b.Log.Logs.BrokerLog.ErrorContext(
+			ctx,
+			"can't encode an event",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	body, err := env.Encode(b.config.EventsConfig.Encoding)
+	if err != nil {
+		b.Log.Logs.BrokerLog.ErrorContext(
+			ctx,
+			"can't encode an event",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	headers := http.Header{}
+	headers.Set("Content-Type", kafka.ContentType(b.config.EventsConfig.Encoding))
+	headers.Set(EventHeader, eventType)
+
+	// Protobuf events are in CloudEvents binary mode, with their attributes in headers, as JSON ones hold them in the body.
+	if b.config.EventsConfig.Encoding == kafka.EncodingProto {
+		for _, attr := range env.Attributes() {
+			headers.Set("ce-"+attr.Name, attr.Value)
+		}
+	}
+
+	var errs []error
+
+	for _, u := range b.config.WebhookConfig.URLs {
+		err := b.deliver(ctx, op, u, headers, body)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// deliver() posts the body to a single webhook, retrying failed attempts.
+//
+// Network errors, 5xx and 429 responses are retried up to the configured number of times, waiting twice as long after every attempt, up to the configured maximum. Other responses are final. Attempts and waits are cut short once ctx is done, e.g. when the service shuts down.
+func (b *Broker) deliver(ctx context.Context, op string, u string, headers http.Header, body []byte) error {
+	backoff := b.config.WebhookConfig.Backoff
+
+	for attempt := 0; ; attempt++ {
+		retry, err := b.attempt(ctx, u, headers, body)
+		if err == nil {
+			b.Log.Logs.BrokerLog.DebugContext(
+				ctx,
+				"webhook delivered",
+				slog.String("op", op),
+				slog.String("url", u),
+				slog.Int("attempt", attempt+1),
+			)
+
+			return nil
+		}
+
+		if !retry || attempt == b.config.WebhookConfig.Retries || ctx.Err() != nil {
+			b.Log.Logs.BrokerLog.ErrorContext(
+				ctx,
+				"can't deliver a webhook",
+				slog.String("op", op),
+				slog.String("url", u),
+				slog.Int("attempt", attempt+1),
+				slog.String("error", err.Error()),
+			)
+
+			return err
+		}
+
+		b.Log.Logs.BrokerLog.WarnContext(
+			ctx,
+			"webhook failed, retrying",
+			slog.String("op", op),
+			slog.String("url", u),
+			slog.Int("attempt", attempt+1),
+			slog.Duration("backoff", backoff),
+			slog.String("error", err.Error()),
+		)
+
+		select {
+		case <-ctx.Done():
+			b.Log.Logs.BrokerLog.ErrorContext(
+				ctx,
+				"can't deliver a webhook",
+				slog.String("op", op),
+				slog.String("url", u),
+				slog.Int("attempt", attempt+1),
+				slog.String("error", ctx.Err().Error()),
+			)
+
+			return errors.Join(err, ctx.Err())
+
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, b.config.WebhookConfig.MaxBackoff)
+	}
+}
+
+// attempt() posts the body to a webhook once, and reports whether a failure is worth retrying.
+func (b *Broker) attempt(ctx context.Context, u string, headers http.Header, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header = headers.Clone()
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign([]byte(b.config.WebhookConfig.Secret), timestamp, body))
+
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("%w: %s", ErrUnexpectedStatus, resp.Status)
+
+	default:
+		return false, fmt.Errorf("%w: %s", ErrUnexpectedStatus, resp.Status)
+	}
+}
+
+// Sign() returns the SignatureHeader value of a request with the given timestamp and body.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,56 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+)
+
+// requestIDKey is the context key of the request ID.
+type requestIDKey struct{}
+
+// WithRequestID() returns a copy of ctx carrying the given request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID() returns the request ID carried by ctx, or an empty string if there's none.
+//
+// A nil ctx carries none, so records logged without a context are handled as well.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	id, _ := ctx.Value(requestIDKey{}).(string)
+
+	return id
+}
+
+// ContextHandler{} wraps a slog handler, adding the request ID carried by the context of every record logged with one, e.g. through ErrorContext().
+type ContextHandler struct {
+	slog.Handler
+}
+
+// NewContextHandler() wraps the given handler in a ContextHandler.
+func NewContextHandler(h slog.Handler) *ContextHandler {
+	return &ContextHandler{Handler: h}
+}
+
+// Handle() adds the request ID of ctx, if any, to the record before handling it.
+func (h *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs() returns a ContextHandler wrapping the handler with the given attributes.
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup() returns a ContextHandler wrapping the handler with the given group.
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -47,6 +47,8 @@ func (s silentHandler) WithGroup(_ string) slog.Handler               { return s
 //   - local: prints all logs to stdout.
 //   - dev: writes logs to JSON files in log/dev/.
 //   - prod: writes logs to JSON files in log/.
+//
+// Every logger adds the request ID carried by the context of records logged with one.
 func New() (*Logger, error) {
 	logMode := os.Getenv(lmEnvName)
 	if logMode == "" {
@@ -72,21 +74,21 @@ func New() (*Logger, error) {
 		brokerLog = slog.New(silentHandler{})
 
 	case "local":
-		appLog = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		appLog = slog.New(NewContextHandler(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 			Level: slog.LevelDebug,
-		}))
+		})))
 
-		bookLog = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		bookLog = slog.New(NewContextHandler(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 			Level: slog.LevelDebug,
-		}))
+		})))
 
-		storageLog = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		storageLog = slog.New(NewContextHandler(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 			Level: slog.LevelDebug,
-		}))
+		})))
 
-		brokerLog = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		brokerLog = slog.New(NewContextHandler(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 			Level: slog.LevelDebug,
-		}))
+		})))
 
 	case "dev":
 		err = os.MkdirAll("log/dev", 0777)
@@ -107,21 +109,21 @@ func New() (*Logger, error) {
 			return &Logger{}, err
 		}
 
-		appLog = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		appLog = slog.New(NewContextHandler(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 			Level: slog.LevelDebug,
-		}))
+		})))
 
-		bookLog = slog.New(slog.NewJSONHandler(b, &slog.HandlerOptions{
+		bookLog = slog.New(NewContextHandler(slog.NewJSONHandler(b, &slog.HandlerOptions{
 			Level: slog.LevelDebug,
-		}))
+		})))
 
-		storageLog = slog.New(slog.NewJSONHandler(s, &slog.HandlerOptions{
+		storageLog = slog.New(NewContextHandler(slog.NewJSONHandler(s, &slog.HandlerOptions{
 			Level: slog.LevelDebug,
-		}))
+		})))
 
-		brokerLog = slog.New(slog.NewJSONHandler(br, &slog.HandlerOptions{
+		brokerLog = slog.New(NewContextHandler(slog.NewJSONHandler(br, &slog.HandlerOptions{
 			Level: slog.LevelDebug,
-		}))
+		})))
 
 	case "prod":
 		err = os.MkdirAll("log", 0777)
@@ -142,21 +144,21 @@ func New() (*Logger, error) {
 			return &Logger{}, err
 		}
 
-		appLog = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		appLog = slog.New(NewContextHandler(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 			Level: slog.LevelInfo,
-		}))
+		})))
 
-		bookLog = slog.New(slog.NewJSONHandler(b, &slog.HandlerOptions{
+		bookLog = slog.New(NewContextHandler(slog.NewJSONHandler(b, &slog.HandlerOptions{
 			Level: slog.LevelInfo,
-		}))
+		})))
 
-		storageLog = slog.New(slog.NewJSONHandler(s, &slog.HandlerOptions{
+		storageLog = slog.New(NewContextHandler(slog.NewJSONHandler(s, &slog.HandlerOptions{
 			Level: slog.LevelInfo,
-		}))
+		})))
 
-		brokerLog = slog.New(slog.NewJSONHandler(br, &slog.HandlerOptions{
+		brokerLog = slog.New(NewContextHandler(slog.NewJSONHandler(br, &slog.HandlerOptions{
 			Level: slog.LevelInfo,
-		}))
+		})))
 
 	default:
 		return &Logger{}, ErrWrongLogger
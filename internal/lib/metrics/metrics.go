@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/codes"
+)
+
+const namespace = "book"
+
+// Outcomes of a booking, as counted by Bookings.
+const (
+	OutcomeOK        = "ok"
+	OutcomeDuplicate = "duplicate"
+	OutcomeInvalid   = "invalid"
+	OutcomeInternal  = "internal"
+)
+
+// Operations whose latency is observed by Duration.
+const (
+	OperationAPIBook              = "api_book"
+	OperationAPIBookMany          = "api_book_many"
+	OperationStorageBook          = "storage_book"
+	OperationStorageBookMany      = "storage_book_many"
+	OperationBrokerBookNotify     = "broker_book_notify"
+	OperationBrokerBookManyNotify = "broker_book_many_notify"
+)
+
+var (
+	// Bookings counts the seats of Book and BookMany calls by outcome: one per Book call, and one per requested seat of a BookMany call, which books all of them or none.
+	Bookings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bookings_total",
+		Help:      "Seats of Book and BookMany calls by outcome: ok, duplicate, invalid or internal.",
+	}, []string{"outcome"})
+
+	// Duration observes the latency of booking operations, from the gRPC call down to the storage and the broker.
+	Duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of Api.Book, Api.BookMany, Storage.Book, Storage.BookMany, Broker.BookNotify and Broker.BookManyNotify.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	// KafkaProduceErrors counts messages Kafka failed to take, whether produced synchronously or not.
+	KafkaProduceErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_produce_errors_total",
+		Help:      "Messages Kafka failed to take.",
+	})
+
+	// Registry holds every metric of the service, along with the Go runtime and process ones.
+	Registry = newRegistry()
+)
+
+// newRegistry() returns a registry holding the metrics of the service.
+//
+// Every outcome and operation is initialized, so they are exposed at zero before they first happen.
+func newRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+
+	registry.MustRegister(
+		Bookings,
+		Duration,
+		KafkaProduceErrors,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	for _, outcome := range []string{OutcomeOK, OutcomeDuplicate, OutcomeInvalid, OutcomeInternal} {
+		Bookings.WithLabelValues(outcome)
+	}
+
+	for _, operation := range []string{OperationAPIBook, OperationAPIBookMany, OperationStorageBook, OperationStorageBookMany, OperationBrokerBookNotify, OperationBrokerBookManyNotify} {
+		Duration.WithLabelValues(operation)
+	}
+
+	return registry
+}
+
+// RegisterDB() exposes the connection pool stats of a database, labelled with its name.
+//
+// Returns an error if a database was already registered under that name.
+func RegisterDB(name string, db *sql.DB) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Since() observes the latency of an operation that started at start.
+func Since(operation string, start time.Time) {
+	Duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Outcome() returns the outcome of a Book or BookMany call ending with the given code.
+//
+// Every rejected request that isn't a duplicate is invalid, e.g. a seat missing from the layout or a full screen.
+func Outcome(code codes.Code) string {
+	switch code {
+	case codes.OK:
+		return OutcomeOK
+
+	case codes.AlreadyExists:
+		return OutcomeDuplicate
+
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		return OutcomeInternal
+
+	default:
+		return OutcomeInvalid
+	}
+}
+
+// Handler() returns the HTTP handler exposing the metrics of the Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
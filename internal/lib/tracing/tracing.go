@@ -0,0 +1,152 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/bookamovie/book/internal/lib/logger"
+	"github.com/bookamovie/book/internal/utils"
+)
+
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterNone   = "none"
+)
+
+// Name is the name of the tracer every span of the service is started with.
+const Name = "github.com/bookamovie/book"
+
+// traceParentHeader is the W3C Trace Context header carrying the trace and span IDs.
+const traceParentHeader = "traceparent"
+
+// shutdownTimeout is how long the spans still buffered are given to be exported on shutdown.
+const shutdownTimeout = 5 * time.Second
+
+var (
+	ErrUnknownExporter = fmt.Errorf("tracing exporter is unknown")
+)
+
+// Provider{} exports the spans of the service with the configured exporter.
+//
+// Without an exporter, the provider is nil and spans are never recorded, while trace contexts are still propagated.
+type Provider struct {
+	provider *sdktrace.TracerProvider
+	Log      *logger.Logger
+}
+
+// New() initializes the configured exporter, and installs its provider and the W3C Trace Context propagator globally.
+//
+// Returns ErrUnknownExporter for anything but ExporterOTLP, ExporterStdout or ExporterNone.
+func New(cfg utils.Config, log *logger.Logger) (*Provider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	p := &Provider{Log: log}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch cfg.TracingConfig.Exporter {
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{}
+		if cfg.TracingConfig.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.TracingConfig.Endpoint))
+		}
+		if cfg.TracingConfig.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+
+		exporter, err = otlptracegrpc.New(context.Background(), opts...)
+
+	case ExporterStdout:
+		exporter, err = stdouttrace.New()
+
+	case ExporterNone:
+		return p, nil
+
+	default:
+		err = fmt.Errorf("%w: %s", ErrUnknownExporter, cfg.TracingConfig.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingConfig.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.TracingConfig.ServiceName))),
+	)
+
+	otel.SetTracerProvider(p.provider)
+
+	return p, nil
+}
+
+// Shutdown() exports the spans still buffered and stops the exporter.
+func (p *Provider) Shutdown() {
+	const op = "Shutdown()"
+
+	if p.provider == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := p.provider.Shutdown(ctx)
+	if err != nil {
+		p.Log.Logs.AppLog.Error(
+			"can't shut the tracer provider down",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// Start() starts a span with the tracer of the service, as a child of the span carried by ctx if any.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(Name).Start(ctx, name, opts...)
+}
+
+// End() ends a span, recording err and marking the span as failed if it isn't nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// TraceParent() returns the W3C traceparent of the span carried by ctx, or an empty string if there's none.
+//
+// It is stored along with the events written to the outbox, so they are published within the trace of the request they come from.
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+
+	return carrier[traceParentHeader]
+}
+
+// WithTraceParent() returns a copy of ctx carrying the remote span of the given W3C traceparent.
+//
+// An empty or malformed traceparent leaves ctx as it is.
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{traceParentHeader: traceParent})
+}
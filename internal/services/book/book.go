@@ -2,86 +2,1005 @@ package book
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	broker "github.com/bookamovie/book/internal/broker/kafka"
 	"github.com/bookamovie/book/internal/lib/logger"
-	storage "github.com/bookamovie/book/internal/storage/sqlite"
+	"github.com/bookamovie/book/internal/lib/metrics"
+	"github.com/bookamovie/book/internal/lib/tracing"
+	"github.com/bookamovie/book/internal/storage"
 	"github.com/bookamovie/book/internal/utils"
+	"github.com/bookamovie/book/pkg/ticket"
 	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
-	"github.com/mattn/go-sqlite3"
 	"github.com/thanhpk/randstr"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var (
-	ErrDuplicate = fmt.Errorf("this order already exists")
+	ErrDuplicate        = fmt.Errorf("this order already exists")
+	ErrNotFound         = fmt.Errorf("this order does not exist")
+	ErrAlreadyCancelled = fmt.Errorf("this order is already cancelled")
+	ErrInvalidPageToken = fmt.Errorf("page token is invalid")
+	ErrHoldNotFound     = fmt.Errorf("this hold does not exist or has expired")
+	ErrSeatNotInLayout  = fmt.Errorf("this seat does not exist on the screen")
+	ErrScreenFull       = fmt.Errorf("this screen is full")
+	ErrUnknownEvent     = fmt.Errorf("event type is unknown")
+	ErrKeyReused        = fmt.Errorf("this idempotency key was used for a different request")
+	ErrKeyInProgress    = fmt.Errorf("a request with this idempotency key is in progress")
+	ErrInvalidToken     = fmt.Errorf("this ticket token is invalid")
+	ErrSigningDisabled  = fmt.Errorf("ticket signing is not configured")
+	ErrUnknownFormat    = fmt.Errorf("image format is unknown")
+	ErrUnknownSymbology = fmt.Errorf("barcode symbology is unknown")
+	ErrRenderTooSmall   = fmt.Errorf("image size is too small for the barcode")
 )
 
-// Querier{} abstracts the interface for the storage layer's booking method.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+
+	// maxTicketAttempts caps how many tickets are generated for a booking whose tickets keep colliding with existing ones.
+	maxTicketAttempts = 5
+)
+
+// Querier{} abstracts the interface for the storage layer's booking methods.
 type Querier interface {
-	Book(query *storage.BookQuery) error
+	Book(ctx context.Context, query *storage.BookQuery) error
+	BookMany(ctx context.Context, query *storage.BookManyQuery) error
+	GetBooking(ctx context.Context, query *storage.GetBookingQuery) (*storage.Booking, error)
+	ListBookings(ctx context.Context, query *storage.ListBookingsQuery) ([]*storage.Booking, error)
+	TakenSeats(ctx context.Context, query *storage.TakenSeatsQuery) ([]int32, error)
+	GetScreen(ctx context.Context, query *storage.GetScreenQuery) (*storage.Screen, error)
+	CancelBooking(ctx context.Context, query *storage.CancelBookingQuery) (*storage.Booking, error)
+	Hold(ctx context.Context, query *storage.HoldQuery) error
+	GetHold(ctx context.Context, query *storage.GetHoldQuery) (*storage.Hold, error)
+	ReleaseExpiredHolds(ctx context.Context, query *storage.ReleaseExpiredHoldsQuery) (int64, error)
+	PendingOutbox(ctx context.Context, query *storage.PendingOutboxQuery) ([]*storage.OutboxMessage, error)
+	MarkOutbox(ctx context.Context, query *storage.MarkOutboxQuery) error
+	ReserveIdempotencyKey(ctx context.Context, query *storage.ReserveIdempotencyKeyQuery) (*storage.IdempotencyRecord, error)
+	SaveIdempotencyKey(ctx context.Context, query *storage.SaveIdempotencyKeyQuery) error
+	ReleaseIdempotencyKey(ctx context.Context, query *storage.ReleaseIdempotencyKeyQuery) error
+	PurgeExpiredIdempotencyKeys(ctx context.Context, query *storage.PurgeExpiredIdempotencyKeysQuery) (int64, error)
+	Ping(ctx context.Context) error
 	Shutdown()
 }
 
 // Brokerer{} abstracts the broker (e.g., Kafka) interface for sending booking events.
 type Brokerer interface {
-	BookNotify(event *broker.BookNotifyEvent) error
+	BookNotify(ctx context.Context, event *broker.BookNotifyEvent) error
+	BookManyNotify(ctx context.Context, event *broker.BookManyNotifyEvent) error
+	BookCancelNotify(ctx context.Context, event *broker.BookCancelEvent) error
+	Ping(ctx context.Context) error
 	Shutdown()
 }
 
+// Flusher{} is implemented by brokers sending events in the background, e.g. Kafka in async mode, whose send methods return before the events are delivered.
+//
+// Flush() waits for the events sent since the last flush, and returns how many of them were delivered, in order, before the first that failed, along with its error.
+type Flusher interface {
+	Flush(ctx context.Context) (int, error)
+}
+
+// DefaultSink is the name outbox deliveries are recorded under for brokers that aren't Fanouters.
+const DefaultSink = "default"
+
+// Sink{} is a broker the outbox relays events to on its own, under a name its deliveries are recorded with.
+//
+// The name must stay the same across restarts, or events pending for the sink are delivered to it again.
+type Sink struct {
+	Name   string
+	Broker Brokerer
+}
+
+// Fanouter{} is implemented by brokers sending every event through several sinks, e.g. Kafka and webhooks.
+//
+// The outbox relays events to each of its sinks on its own, so a sink that fails holds back neither the others nor the events they already got.
+type Fanouter interface {
+	Sinks() []Sink
+}
+
 // Service{} handles business logic for booking operations.
 type Service struct {
 	Storage Querier
 	Broker  Brokerer
+	Tickets TicketGenerator
+	Keys    *ticket.KeyRing
 	Log     *logger.Logger
 
 	config utils.Config
 }
 
 // New() creates and returns a new Service instance with dependencies injected.
-func New(cfg utils.Config, log *logger.Logger, s Querier, br Brokerer) *Service {
+//
+// kr may be nil, in which case tickets aren't signed.
+func New(cfg utils.Config, log *logger.Logger, s Querier, br Brokerer, tg TicketGenerator, kr *ticket.KeyRing) *Service {
 	return &Service{
 		Storage: s,
 		Broker:  br,
+		Tickets: tg,
+		Keys:    kr,
 		Log:     log,
 
 		config: cfg,
 	}
 }
 
-// Book() processes a booking request: generates a ticket, stores the data, and notifies the broker.
+// Book() processes a booking request: checks the seat against the screen layout, generates a ticket, and stores the data along with an event for the broker.
+//
+// A ticket that collides with an existing one is generated again, up to maxTicketAttempts times.
 //
-// Returns a BookResponse with the generated ticket or an error if the operation fails.
+// Returns a BookResponse with the generated ticket, along with its signed token if signing is configured, or an error if the operation fails.
 func (s *Service) Book(ctx context.Context, data *bookrpc.BookRequest) (*bookrpc.BookResponse, error) {
-	ticket := randstr.Dec(12)
+	return s.book(ctx, data, "")
+}
 
-	err := s.Storage.Book(&storage.BookQuery{
-		Ticket: ticket,
-		Data:   data,
-	})
+// book() is the booking path shared by Book() and ConfirmHold().
+//
+// hold is the ID of the hold being confirmed, or empty for a plain booking.
+func (s *Service) book(ctx context.Context, data *bookrpc.BookRequest, hold string) (*bookrpc.BookResponse, error) {
+	err := s.checkSeats(ctx, data.GetCinema(), data.GetSession().GetScreen(), data.GetSession().GetDate(), hold != "", data.GetSession().GetSeat())
 	if err != nil {
-		if errors.Is(err, sqlite3.ErrConstraintUnique) {
+		return &bookrpc.BookResponse{}, err
+	}
+
+	var tkt, token string
+
+	for range maxTicketAttempts {
+		tkt = s.Tickets.Generate(data.GetCinema())
+
+		// The token is signed first, so that a booking is never stored without one.
+		token, err = s.sign(tkt, data)
+		if err != nil {
+			return &bookrpc.BookResponse{}, err
+		}
+
+		var payload []byte
+
+		payload, err = utils.MarshalJSON(&broker.BookNotifyEvent{
+			Meta:   newMeta(ctx),
+			Ticket: tkt,
+			Data:   data,
+		})
+		if err != nil {
+			return &bookrpc.BookResponse{}, err
+		}
+
+		start := time.Now()
+
+		err = s.Storage.Book(ctx, &storage.BookQuery{
+			Ticket: tkt,
+			Hold:   hold,
+			Data:   data,
+			Outbox: &storage.OutboxMessage{
+				Type:    broker.BookNotifyEventType,
+				Payload: payload,
+			},
+		})
+		metrics.Since(metrics.OperationStorageBook, start)
+		if !errors.Is(err, storage.ErrConstraintPrimaryKey) {
+			break
+		}
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrConstraintUnique) {
 			return &bookrpc.BookResponse{}, ErrDuplicate
 		}
 		return &bookrpc.BookResponse{}, err
 	}
 
-	err = s.Broker.BookNotify(&broker.BookNotifyEvent{
-		Ticket: ticket,
-		Data:   data,
+	return &bookrpc.BookResponse{
+		Order: &bookrpc.Order{
+			Ticket: tkt,
+		},
+		Token: token,
+	}, nil
+}
+
+// sign() returns the signed token of a ticket, or an empty one if signing isn't configured.
+func (s *Service) sign(tkt string, data *bookrpc.BookRequest) (string, error) {
+	if s.Keys == nil {
+		return "", nil
+	}
+
+	return s.Keys.Sign(claims(tkt, data))
+}
+
+// VerifyTicket() checks a ticket token, and that the booking it vouches for still exists.
+//
+// Returns a VerifyTicketResponse with the booking, whose status tells whether it was cancelled since. Returns ErrInvalidToken for tokens that can't be trusted or don't match their booking, ErrNotFound for unknown tickets, or ErrSigningDisabled if no key is configured.
+func (s *Service) VerifyTicket(ctx context.Context, data *bookrpc.VerifyTicketRequest) (*bookrpc.VerifyTicketResponse, error) {
+	if s.Keys == nil {
+		return &bookrpc.VerifyTicketResponse{}, ErrSigningDisabled
+	}
+
+	claims, err := s.Keys.Verify(data.GetToken())
+	if err != nil {
+		return &bookrpc.VerifyTicketResponse{}, ErrInvalidToken
+	}
+
+	booking, err := s.Storage.GetBooking(ctx, &storage.GetBookingQuery{
+		Ticket: claims.Ticket,
 	})
 	if err != nil {
-		return &bookrpc.BookResponse{}, err
+		if errors.Is(err, sql.ErrNoRows) {
+			return &bookrpc.VerifyTicketResponse{}, ErrNotFound
+		}
+		return &bookrpc.VerifyTicketResponse{}, err
 	}
 
-	return &bookrpc.BookResponse{
+	// PostgreSQL keeps dates to the microsecond, while claims keep them to the nanosecond.
+	if booking.Cinema != claims.Cinema ||
+		booking.Location != claims.Location ||
+		booking.Screen != claims.Screen ||
+		booking.Seat != claims.Seat ||
+		booking.Date.Sub(claims.Session).Abs() >= time.Microsecond {
+		return &bookrpc.VerifyTicketResponse{}, ErrInvalidToken
+	}
+
+	req := booking.BookRequest()
+
+	return &bookrpc.VerifyTicketResponse{
+		Order: &bookrpc.Order{
+			Ticket: booking.Ticket,
+		},
+		Cinema:  req.Cinema,
+		Movie:   req.Movie,
+		Session: req.Session,
+		Status:  booking.Status,
+	}, nil
+}
+
+// BookMany() books several seats of one session at once: generates a ticket per seat, and stores them in one transaction along with a single grouped event for the broker.
+//
+// Returns a BookManyResponse with one order per seat, in the order the seats were requested, or ErrDuplicate if any seat is taken, in which case nothing is booked.
+func (s *Service) BookMany(ctx context.Context, data *bookrpc.BookManyRequest) (*bookrpc.BookManyResponse, error) {
+	err := s.checkSeats(ctx, data.GetCinema(), data.GetScreen(), data.GetDate(), false, data.GetSeats()...)
+	if err != nil {
+		return &bookrpc.BookManyResponse{}, err
+	}
+
+	var resp *bookrpc.BookManyResponse
+
+	for range maxTicketAttempts {
+		var query *storage.BookManyQuery
+
+		query, resp, err = s.bookManyQuery(ctx, data)
+		if err != nil {
+			return &bookrpc.BookManyResponse{}, err
+		}
+
+		start := time.Now()
+
+		err = s.Storage.BookMany(ctx, query)
+		metrics.Since(metrics.OperationStorageBookMany, start)
+		if !errors.Is(err, storage.ErrConstraintPrimaryKey) {
+			break
+		}
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrConstraintUnique) {
+			return &bookrpc.BookManyResponse{}, ErrDuplicate
+		}
+		return &bookrpc.BookManyResponse{}, err
+	}
+
+	return resp, nil
+}
+
+// bookManyQuery() generates a ticket per seat of a BookMany request.
+//
+// Returns the storage query, with a single grouped event for the broker, along with the response listing the tickets, or an error if the event can't be serialized.
+func (s *Service) bookManyQuery(ctx context.Context, data *bookrpc.BookManyRequest) (*storage.BookManyQuery, *bookrpc.BookManyResponse, error) {
+	query := &storage.BookManyQuery{}
+	event := &broker.BookManyNotifyEvent{
+		Meta: newMeta(ctx),
+	}
+	resp := &bookrpc.BookManyResponse{}
+
+	for _, seat := range data.GetSeats() {
+		tkt := s.Tickets.Generate(data.GetCinema())
+		req := &bookrpc.BookRequest{
+			Cinema: data.GetCinema(),
+			Movie:  data.GetMovie(),
+			Session: &bookrpc.Session{
+				Screen: data.GetScreen(),
+				Seat:   seat,
+				Date:   data.GetDate(),
+			},
+		}
+
+		query.Queries = append(query.Queries, &storage.BookQuery{
+			Ticket: tkt,
+			Data:   req,
+		})
+		event.Bookings = append(event.Bookings, &broker.BookNotifyEvent{
+			Ticket: tkt,
+			Data:   req,
+		})
+		resp.Orders = append(resp.Orders, &bookrpc.Order{
+			Ticket: tkt,
+		})
+	}
+
+	payload, err := utils.MarshalJSON(event)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query.Outbox = &storage.OutboxMessage{
+		Type:    broker.BookManyNotifyEventType,
+		Payload: payload,
+	}
+
+	return query, resp, nil
+}
+
+// GetBooking() looks up a booking by its ticket.
+//
+// Returns a GetBookingResponse with the booked cinema, movie, session and status, or ErrNotFound for unknown tickets.
+func (s *Service) GetBooking(ctx context.Context, data *bookrpc.GetBookingRequest) (*bookrpc.GetBookingResponse, error) {
+	booking, err := s.Storage.GetBooking(ctx, &storage.GetBookingQuery{
+		Ticket: data.GetTicket(),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &bookrpc.GetBookingResponse{}, ErrNotFound
+		}
+		return &bookrpc.GetBookingResponse{}, err
+	}
+
+	req := booking.BookRequest()
+
+	return &bookrpc.GetBookingResponse{
+		Order: &bookrpc.Order{
+			Ticket: booking.Ticket,
+		},
+		Cinema:  req.Cinema,
+		Movie:   req.Movie,
+		Session: req.Session,
+		Status:  booking.Status,
+	}, nil
+}
+
+// ListBookings() lists bookings matching the request filters, ordered by session date.
+//
+// At most one page is returned. If there are more bookings, the response carries a token for the next page. Returns ErrInvalidPageToken if the given page token can't be decoded.
+func (s *Service) ListBookings(ctx context.Context, data *bookrpc.ListBookingsRequest) (*bookrpc.ListBookingsResponse, error) {
+	size := int(data.GetPageSize())
+	switch {
+	case size == 0:
+		size = defaultPageSize
+
+	case size > maxPageSize:
+		size = maxPageSize
+	}
+
+	query := &storage.ListBookingsQuery{
+		Cinema:   data.GetCinema().GetName(),
+		Location: data.GetCinema().GetLocation(),
+		Movie:    data.GetMovie(),
+		Screen:   data.GetScreen(),
+		Limit:    size + 1,
+	}
+	if data.GetFrom() != nil {
+		query.From = data.GetFrom().AsTime()
+	}
+	if data.GetTo() != nil {
+		query.To = data.GetTo().AsTime()
+	}
+	if data.GetPageToken() != "" {
+		token, err := decodePageToken(data.GetPageToken())
+		if err != nil {
+			return &bookrpc.ListBookingsResponse{}, ErrInvalidPageToken
+		}
+		query.AfterDate = token.Date
+		query.AfterTicket = token.Ticket
+	}
+
+	bookings, err := s.Storage.ListBookings(ctx, query)
+	if err != nil {
+		return &bookrpc.ListBookingsResponse{}, err
+	}
+
+	resp := &bookrpc.ListBookingsResponse{}
+
+	if len(bookings) > size {
+		bookings = bookings[:size]
+		resp.NextPageToken, err = encodePageToken(bookings[size-1])
+		if err != nil {
+			return &bookrpc.ListBookingsResponse{}, err
+		}
+	}
+
+	for _, booking := range bookings {
+		req := booking.BookRequest()
+
+		resp.Bookings = append(resp.Bookings, &bookrpc.Booking{
+			Order: &bookrpc.Order{
+				Ticket: booking.Ticket,
+			},
+			Cinema:  req.Cinema,
+			Movie:   req.Movie,
+			Session: req.Session,
+			Status:  booking.Status,
+		})
+	}
+
+	return resp, nil
+}
+
+// pageToken{} is the cursor hidden behind the opaque page tokens of ListBookings().
+type pageToken struct {
+	Date   time.Time `json:"d"`
+	Ticket string    `json:"t"`
+}
+
+// encodePageToken() builds a page token pointing right after the given booking.
+func encodePageToken(booking *storage.Booking) (string, error) {
+	raw, err := utils.MarshalJSON(pageToken{
+		Date:   booking.Date,
+		Ticket: booking.Ticket,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// decodePageToken() reverses encodePageToken().
+func decodePageToken(token string) (pageToken, error) {
+	var pt pageToken
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return pageToken{}, err
+	}
+
+	err = json.Unmarshal(raw, &pt)
+	if err != nil {
+		return pageToken{}, err
+	}
+
+	if pt.Ticket == "" {
+		return pageToken{}, ErrInvalidPageToken
+	}
+
+	return pt, nil
+}
+
+// GetSeatAvailability() splits the seats of a screen session into taken and free ones.
+//
+// Free seats follow the screen layout. Screens without a layout have the configured number of seats, numbered from 1.
+func (s *Service) GetSeatAvailability(ctx context.Context, data *bookrpc.GetSeatAvailabilityRequest) (*bookrpc.GetSeatAvailabilityResponse, error) {
+	_, taken, free, err := s.seatMap(ctx, data.GetCinema(), data.GetScreen(), data.GetDate())
+	if err != nil {
+		return &bookrpc.GetSeatAvailabilityResponse{}, err
+	}
+
+	return &bookrpc.GetSeatAvailabilityResponse{
+		Taken: taken,
+		Free:  free,
+	}, nil
+}
+
+// seatMap() returns the layout of a screen, along with the taken and free seats of one of its sessions.
+func (s *Service) seatMap(ctx context.Context, cinema *bookrpc.Cinema, screen int32, date *timestamppb.Timestamp) (*storage.Screen, []int32, []int32, error) {
+	layout, err := s.Storage.GetScreen(ctx, &storage.GetScreenQuery{
+		Cinema:   cinema.GetName(),
+		Location: cinema.GetLocation(),
+		Screen:   screen,
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, nil, err
+		}
+
+		layout = &storage.Screen{
+			Cinema:      cinema.GetName(),
+			Location:    cinema.GetLocation(),
+			Screen:      screen,
+			Rows:        1,
+			SeatsPerRow: s.config.BookConfig.Seats,
+		}
+	}
+
+	taken, err := s.Storage.TakenSeats(ctx, &storage.TakenSeatsQuery{
+		Cinema:   cinema.GetName(),
+		Location: cinema.GetLocation(),
+		Screen:   screen,
+		Date:     date.AsTime(),
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var free []int32
+
+	for _, seat := range layout.Seats() {
+		if !slices.Contains(taken, seat) {
+			free = append(free, seat)
+		}
+	}
+
+	return layout, taken, free, nil
+}
+
+// checkSeats() makes sure the seats exist in the screen layout and that the screen session has room for them.
+//
+// held tells that the seats are held by the caller, e.g. when a hold is confirmed: they're already counted as taken, and the session had room for them when they were held, so there's no room to check. Returns ErrSeatNotInLayout or ErrScreenFull. Whether the seats themselves are free is left to the storage.
+func (s *Service) checkSeats(ctx context.Context, cinema *bookrpc.Cinema, screen int32, date *timestamppb.Timestamp, held bool, seats ...int32) error {
+	layout, _, free, err := s.seatMap(ctx, cinema, screen, date)
+	if err != nil {
+		return err
+	}
+
+	for _, seat := range seats {
+		if !layout.Has(seat) {
+			return ErrSeatNotInLayout
+		}
+	}
+
+	if !held && len(free) < len(seats) {
+		return ErrScreenFull
+	}
+
+	return nil
+}
+
+// CancelBooking() processes a cancellation request: marks the booking as cancelled and queues an event telling the broker that the seat is free again.
+//
+// Returns a CancelBookingResponse with the cancelled ticket, ErrNotFound for unknown tickets or ErrAlreadyCancelled for tickets cancelled before.
+func (s *Service) CancelBooking(ctx context.Context, data *bookrpc.CancelBookingRequest) (*bookrpc.CancelBookingResponse, error) {
+	booking, err := s.cancel(ctx, data.GetTicket(), storage.StatusCancelled)
+	if err != nil {
+		return &bookrpc.CancelBookingResponse{}, err
+	}
+
+	return &bookrpc.CancelBookingResponse{
 		Order: &bookrpc.Order{
-			Ticket: ticket,
+			Ticket: booking.Ticket,
 		},
 	}, nil
 }
 
+// cancel() moves a booked ticket to the given status, releasing its seat, along with a BookCancelEvent for the broker.
+//
+// Returns the cancelled booking, ErrNotFound for unknown tickets or ErrAlreadyCancelled for tickets that aren't booked anymore.
+func (s *Service) cancel(ctx context.Context, ticket string, status string) (*storage.Booking, error) {
+	// Bookings never change besides their status, so the event can be built before the cancellation.
+	booking, err := s.Storage.GetBooking(ctx, &storage.GetBookingQuery{
+		Ticket: ticket,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	payload, err := utils.MarshalJSON(&broker.BookCancelEvent{
+		Meta:   newMeta(ctx),
+		Ticket: booking.Ticket,
+		Data:   booking.BookRequest(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	booking, err = s.Storage.CancelBooking(ctx, &storage.CancelBookingQuery{
+		Ticket: booking.Ticket,
+		Status: status,
+		Outbox: &storage.OutboxMessage{
+			Type:    broker.BookCancelEventType,
+			Payload: payload,
+		},
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+
+		case errors.Is(err, storage.ErrAlreadyCancelled):
+			return nil, ErrAlreadyCancelled
+		}
+		return nil, err
+	}
+
+	return booking, nil
+}
+
+// RenderTicket() draws a ticket as a barcode image, so every client shows the same one.
+//
+// QR codes carry the signed token of the ticket, or the ticket and its session as JSON if signing isn't configured. Code128 barcodes, which hold much less, carry the ticket alone. Returns ErrNotFound for unknown tickets, ErrAlreadyCancelled for cancelled or declined ones, and ErrUnknownFormat, ErrUnknownSymbology or ErrRenderTooSmall for options that can't be rendered.
+func (s *Service) RenderTicket(ctx context.Context, data *bookrpc.RenderTicketRequest) (*bookrpc.RenderTicketResponse, error) {
+	booking, err := s.Storage.GetBooking(ctx, &storage.GetBookingQuery{
+		Ticket: data.GetTicket(),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &bookrpc.RenderTicketResponse{}, ErrNotFound
+		}
+		return &bookrpc.RenderTicketResponse{}, err
+	}
+
+	if booking.Status != storage.StatusBooked {
+		return &bookrpc.RenderTicketResponse{}, ErrAlreadyCancelled
+	}
+
+	symbology := data.GetSymbology()
+	if symbology == "" {
+		symbology = SymbologyQR
+	}
+
+	content := booking.Ticket
+	if symbology == SymbologyQR {
+		content, err = s.sign(booking.Ticket, booking.BookRequest())
+		if err != nil {
+			return &bookrpc.RenderTicketResponse{}, err
+		}
+
+		if content == "" {
+			raw, err := utils.MarshalJSON(claims(booking.Ticket, booking.BookRequest()))
+			if err != nil {
+				return &bookrpc.RenderTicketResponse{}, err
+			}
+
+			content = string(raw)
+		}
+	}
+
+	sym, err := encodeSymbol(symbology, content)
+	if err != nil {
+		return &bookrpc.RenderTicketResponse{}, err
+	}
+
+	size := int(data.GetSize())
+	switch {
+	case size == 0:
+		size = defaultRenderSize
+
+	case size > maxRenderSize:
+		size = maxRenderSize
+	}
+
+	switch data.GetFormat() {
+	case "", FormatPNG:
+		img, err := sym.png(size)
+		if err != nil {
+			return &bookrpc.RenderTicketResponse{}, err
+		}
+
+		return &bookrpc.RenderTicketResponse{
+			Image:       img,
+			ContentType: "image/png",
+		}, nil
+
+	case FormatSVG:
+		return &bookrpc.RenderTicketResponse{
+			Image:       sym.svg(size),
+			ContentType: "image/svg+xml",
+		}, nil
+
+	default:
+		return &bookrpc.RenderTicketResponse{}, fmt.Errorf("%w: %s", ErrUnknownFormat, data.GetFormat())
+	}
+}
+
+// HoldSeats() blocks several seats of one session for the configured TTL, so they can be paid for before being booked.
+//
+// Returns a HoldSeatsResponse with one hold per seat, in the order the seats were requested, or ErrDuplicate if any seat is booked or held, in which case nothing is held.
+func (s *Service) HoldSeats(ctx context.Context, data *bookrpc.HoldSeatsRequest) (*bookrpc.HoldSeatsResponse, error) {
+	err := s.checkSeats(ctx, data.GetCinema(), data.GetScreen(), data.GetDate(), false, data.GetSeats()...)
+	if err != nil {
+		return &bookrpc.HoldSeatsResponse{}, err
+	}
+
+	expiresAt := time.Now().Add(s.config.HoldConfig.TTL)
+
+	query := &storage.HoldQuery{}
+	resp := &bookrpc.HoldSeatsResponse{}
+
+	for _, seat := range data.GetSeats() {
+		id := randstr.Hex(16)
+
+		query.Holds = append(query.Holds, &storage.Hold{
+			ID: id,
+			Data: &bookrpc.BookRequest{
+				Cinema: data.GetCinema(),
+				Movie:  data.GetMovie(),
+				Session: &bookrpc.Session{
+					Screen: data.GetScreen(),
+					Seat:   seat,
+					Date:   data.GetDate(),
+				},
+			},
+			ExpiresAt: expiresAt,
+		})
+		resp.Holds = append(resp.Holds, &bookrpc.Hold{
+			Id:        id,
+			Seat:      seat,
+			ExpiresAt: timestamppb.New(expiresAt),
+		})
+	}
+
+	err = s.Storage.Hold(ctx, query)
+	if err != nil {
+		if errors.Is(err, storage.ErrConstraintUnique) {
+			return &bookrpc.HoldSeatsResponse{}, ErrDuplicate
+		}
+		return &bookrpc.HoldSeatsResponse{}, err
+	}
+
+	return resp, nil
+}
+
+// ConfirmHold() turns a hold into a real booking, going through the same path as Book().
+//
+// Returns a BookResponse with the generated ticket, or ErrHoldNotFound if the hold is unknown or has expired.
+func (s *Service) ConfirmHold(ctx context.Context, data *bookrpc.ConfirmHoldRequest) (*bookrpc.BookResponse, error) {
+	hold, err := s.Storage.GetHold(ctx, &storage.GetHoldQuery{
+		ID: data.GetHold(),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &bookrpc.BookResponse{}, ErrHoldNotFound
+		}
+		return &bookrpc.BookResponse{}, err
+	}
+
+	return s.book(ctx, hold.Data, hold.ID)
+}
+
+// IdempotentResult{} is the outcome of a booking request made with an idempotency key.
+//
+// Either Ticket is set, or Code and Message hold the gRPC status the request failed with. Token isn't stored, but signed again whenever the result is replayed.
+type IdempotentResult struct {
+	Ticket  string
+	Token   string
+	Code    uint32
+	Message string
+}
+
+// ReserveIdempotencyKey() claims an idempotency key for a booking request, for the configured lease.
+//
+// The lease is short so that the key of a request whose process died doesn't stay locked for the whole window: the first request with the key once it ends takes it over. It comes from the config rather than from the deadline of the caller, which can be as short as it likes.
+//
+// Returns the token of the reservation if the request should be processed, which SaveIdempotencyKey() and ReleaseIdempotencyKey() take, so a request whose key was taken over can't touch the reservation of the one that took it. Returns the result of the first request made with the key otherwise, ErrKeyReused if that request had a different payload, or ErrKeyInProgress if it hasn't finished yet.
+func (s *Service) ReserveIdempotencyKey(ctx context.Context, key string, data *bookrpc.BookRequest) (*IdempotentResult, string, error) {
+	raw, err := utils.MarshalJSON(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sum := sha256.Sum256(raw)
+	hash := hex.EncodeToString(sum[:])
+	token := randstr.Hex(16)
+
+	record, err := s.Storage.ReserveIdempotencyKey(ctx, &storage.ReserveIdempotencyKeyQuery{
+		Key:         key,
+		Token:       token,
+		RequestHash: hash,
+		ExpiresAt:   time.Now().Add(s.config.IdempotencyConfig.Lease),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch {
+	case record == nil:
+		return nil, token, nil
+
+	case record.RequestHash != hash:
+		return nil, "", ErrKeyReused
+
+	case !record.Done:
+		return nil, "", ErrKeyInProgress
+	}
+
+	result := &IdempotentResult{
+		Ticket:  record.Ticket,
+		Code:    record.Code,
+		Message: record.Message,
+	}
+
+	if result.Ticket != "" {
+		result.Token, err = s.sign(result.Ticket, data)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return result, "", nil
+}
+
+// SaveIdempotencyKey() stores the result of the request that reserved the key with the given token, so later requests with it get the same one for the configured window.
+//
+// Nothing is saved if the reservation was taken over since.
+func (s *Service) SaveIdempotencyKey(ctx context.Context, key string, token string, result *IdempotentResult) error {
+	return s.Storage.SaveIdempotencyKey(ctx, &storage.SaveIdempotencyKeyQuery{
+		Key:       key,
+		Token:     token,
+		Ticket:    result.Ticket,
+		Code:      result.Code,
+		Message:   result.Message,
+		ExpiresAt: time.Now().Add(s.config.IdempotencyConfig.Window),
+	})
+}
+
+// ReleaseIdempotencyKey() frees a key reserved with the given token without a result, so the next request with it is processed again.
+//
+// Nothing is released if the reservation was taken over since.
+func (s *Service) ReleaseIdempotencyKey(ctx context.Context, key string, token string) error {
+	return s.Storage.ReleaseIdempotencyKey(ctx, &storage.ReleaseIdempotencyKeyQuery{
+		Key:   key,
+		Token: token,
+	})
+}
+
+// newMeta() returns the Meta of an event happening now, carrying the request ID and the trace context of ctx, if any.
+//
+// Events of a traced request share its trace ID, so consumers can look the trace up.
+func newMeta(ctx context.Context) broker.Meta {
+	meta := broker.NewMeta()
+	meta.RequestID = logger.RequestID(ctx)
+	meta.TraceParent = tracing.TraceParent(ctx)
+
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		meta.TraceID = span.TraceID().String()
+	}
+
+	return meta
+}
+
+// RelayOutbox() publishes pending outbox messages through the broker, oldest first, and marks them as sent.
+//
+// The sinks of Fanouter brokers are relayed to one by one, each with its own pending messages, and a message is marked as sent once every sink got it. Other brokers are relayed to as a single DefaultSink. Returns the number of messages delivered to all sinks together, along with the errors of the sinks that failed.
+func (s *Service) RelayOutbox(ctx context.Context) (int, error) {
+	sinks := []Sink{{Name: DefaultSink, Broker: s.Broker}}
+
+	fanouter, ok := s.Broker.(Fanouter)
+	if ok {
+		sinks = fanouter.Sinks()
+	}
+
+	names := make([]string, 0, len(sinks))
+	for _, sink := range sinks {
+		names = append(names, sink.Name)
+	}
+
+	var errs []error
+	published := 0
+
+	for _, sink := range sinks {
+		n, err := s.relay(ctx, sink, names)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sink.Name, err))
+		}
+
+		published += n
+	}
+
+	return published, errors.Join(errs...)
+}
+
+// relay() publishes the messages pending for a sink through its broker, oldest first, and marks them as delivered to it.
+//
+// It stops at the first message that fails to publish, so events keep their order, and returns the number of published messages along with the error. The failed message is retried by the next call. A message published right before its mark fails is published again, so delivery is at-least-once.
+//
+// Brokers that are Flushers are flushed before anything is marked, so only the messages they delivered are marked. The ones after the first that failed are published again, even if they were delivered.
+func (s *Service) relay(ctx context.Context, sink Sink, sinks []string) (int, error) {
+	msgs, err := s.Storage.PendingOutbox(ctx, &storage.PendingOutboxQuery{
+		Sink:  sink.Name,
+		Limit: s.config.OutboxConfig.BatchSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	published := len(msgs)
+
+	for i, msg := range msgs {
+		err = s.publish(ctx, sink.Broker, msg)
+		if err != nil {
+			published = i
+
+			break
+		}
+	}
+
+	flusher, ok := sink.Broker.(Flusher)
+	if ok {
+		delivered, flushErr := flusher.Flush(ctx)
+		if flushErr != nil && delivered <= published {
+			published, err = delivered, flushErr
+		}
+	}
+
+	for i, msg := range msgs[:published] {
+		markErr := s.Storage.MarkOutbox(ctx, &storage.MarkOutboxQuery{
+			ID:    msg.ID,
+			Sink:  sink.Name,
+			Sinks: sinks,
+		})
+		if markErr != nil {
+			return i, markErr
+		}
+	}
+
+	if err != nil {
+		markErr := s.Storage.MarkOutbox(ctx, &storage.MarkOutboxQuery{
+			ID:    msgs[published].ID,
+			Sink:  sink.Name,
+			Sinks: sinks,
+			Error: fmt.Sprintf("%s: %s", sink.Name, err.Error()),
+		})
+
+		return published, errors.Join(err, markErr)
+	}
+
+	return published, nil
+}
+
+// publish() decodes an outbox message back into its event and sends it through the matching method of the broker.
+//
+// The broker gets the request ID and the trace context the event comes from through the context, so its logs can be matched with the request and its spans join the request's trace.
+func (s *Service) publish(ctx context.Context, br Brokerer, msg *storage.OutboxMessage) error {
+	switch msg.Type {
+	case broker.BookNotifyEventType:
+		var event broker.BookNotifyEvent
+
+		err := json.Unmarshal(msg.Payload, &event)
+		if err != nil {
+			return err
+		}
+
+		start := time.Now()
+		defer metrics.Since(metrics.OperationBrokerBookNotify, start)
+
+		return br.BookNotify(eventContext(ctx, event.Meta), &event)
+
+	case broker.BookManyNotifyEventType:
+		var event broker.BookManyNotifyEvent
+
+		err := json.Unmarshal(msg.Payload, &event)
+		if err != nil {
+			return err
+		}
+
+		start := time.Now()
+		defer metrics.Since(metrics.OperationBrokerBookManyNotify, start)
+
+		return br.BookManyNotify(eventContext(ctx, event.Meta), &event)
+
+	case broker.BookCancelEventType:
+		var event broker.BookCancelEvent
+
+		err := json.Unmarshal(msg.Payload, &event)
+		if err != nil {
+			return err
+		}
+
+		return br.BookCancelNotify(eventContext(ctx, event.Meta), &event)
+
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownEvent, msg.Type)
+	}
+}
+
+// eventContext() returns a copy of ctx carrying the request ID and the trace context of an event.
+func eventContext(ctx context.Context, meta broker.Meta) context.Context {
+	return tracing.WithTraceParent(logger.WithRequestID(ctx, meta.RequestID), meta.TraceParent)
+}
+
 // UnimplementedService{} is a placeholder implementation of the service.
 //
 // Useful for testing or when mocking is required.
@@ -93,3 +1012,63 @@ type UnimplementedService struct{}
 func (u *UnimplementedService) Book(ctx context.Context, data *bookrpc.BookRequest) (*bookrpc.BookResponse, error) {
 	return &bookrpc.BookResponse{}, nil
 }
+
+// BookMany() returns an empty BookManyResponse and no error.
+func (u *UnimplementedService) BookMany(ctx context.Context, data *bookrpc.BookManyRequest) (*bookrpc.BookManyResponse, error) {
+	return &bookrpc.BookManyResponse{}, nil
+}
+
+// GetBooking() returns an empty GetBookingResponse and no error.
+func (u *UnimplementedService) GetBooking(ctx context.Context, data *bookrpc.GetBookingRequest) (*bookrpc.GetBookingResponse, error) {
+	return &bookrpc.GetBookingResponse{}, nil
+}
+
+// ListBookings() returns an empty ListBookingsResponse and no error.
+func (u *UnimplementedService) ListBookings(ctx context.Context, data *bookrpc.ListBookingsRequest) (*bookrpc.ListBookingsResponse, error) {
+	return &bookrpc.ListBookingsResponse{}, nil
+}
+
+// GetSeatAvailability() returns an empty GetSeatAvailabilityResponse and no error.
+func (u *UnimplementedService) GetSeatAvailability(ctx context.Context, data *bookrpc.GetSeatAvailabilityRequest) (*bookrpc.GetSeatAvailabilityResponse, error) {
+	return &bookrpc.GetSeatAvailabilityResponse{}, nil
+}
+
+// CancelBooking() returns an empty CancelBookingResponse and no error.
+func (u *UnimplementedService) CancelBooking(ctx context.Context, data *bookrpc.CancelBookingRequest) (*bookrpc.CancelBookingResponse, error) {
+	return &bookrpc.CancelBookingResponse{}, nil
+}
+
+// HoldSeats() returns an empty HoldSeatsResponse and no error.
+func (u *UnimplementedService) HoldSeats(ctx context.Context, data *bookrpc.HoldSeatsRequest) (*bookrpc.HoldSeatsResponse, error) {
+	return &bookrpc.HoldSeatsResponse{}, nil
+}
+
+// ConfirmHold() returns an empty BookResponse and no error.
+func (u *UnimplementedService) ConfirmHold(ctx context.Context, data *bookrpc.ConfirmHoldRequest) (*bookrpc.BookResponse, error) {
+	return &bookrpc.BookResponse{}, nil
+}
+
+// ReserveIdempotencyKey() reports the key as free and returns no error.
+func (u *UnimplementedService) ReserveIdempotencyKey(ctx context.Context, key string, data *bookrpc.BookRequest) (*IdempotentResult, string, error) {
+	return nil, "", nil
+}
+
+// SaveIdempotencyKey() returns no error.
+func (u *UnimplementedService) SaveIdempotencyKey(ctx context.Context, key string, token string, result *IdempotentResult) error {
+	return nil
+}
+
+// ReleaseIdempotencyKey() returns no error.
+func (u *UnimplementedService) ReleaseIdempotencyKey(ctx context.Context, key string, token string) error {
+	return nil
+}
+
+// VerifyTicket() returns an empty VerifyTicketResponse and no error.
+func (u *UnimplementedService) VerifyTicket(ctx context.Context, data *bookrpc.VerifyTicketRequest) (*bookrpc.VerifyTicketResponse, error) {
+	return &bookrpc.VerifyTicketResponse{}, nil
+}
+
+// RenderTicket() returns an empty RenderTicketResponse and no error.
+func (u *UnimplementedService) RenderTicket(ctx context.Context, data *bookrpc.RenderTicketRequest) (*bookrpc.RenderTicketResponse, error) {
+	return &bookrpc.RenderTicketResponse{}, nil
+}
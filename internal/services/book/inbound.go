@@ -0,0 +1,77 @@
+package book
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bookamovie/book/internal/storage"
+)
+
+// screeningPageSize is how many bookings of a cancelled screening are read at once.
+const screeningPageSize = 100
+
+// DeclinePayment() marks a booking whose payment was declined as declined, which releases its seat, and queues an event telling the broker that the seat is free again.
+//
+// Returns ErrNotFound for unknown tickets or ErrAlreadyCancelled for tickets that aren't booked anymore.
+func (s *Service) DeclinePayment(ctx context.Context, ticket string) error {
+	_, err := s.cancel(ctx, ticket, storage.StatusDeclined)
+
+	return err
+}
+
+// Screening{} identifies a screen session.
+type Screening struct {
+	Cinema   string
+	Location string
+	Screen   int32
+	Date     time.Time
+}
+
+// CancelScreening() cancels every booking of a screening cancelled by its cinema, queuing an event per booking telling the broker that its seat is free again.
+//
+// Bookings that aren't booked anymore are skipped, so a cancellation handled twice cancels nothing the second time. Every booking is cancelled in its own transaction: on failure, the bookings cancelled so far stay cancelled. Returns the number of bookings cancelled.
+func (s *Service) CancelScreening(ctx context.Context, screening *Screening) (int, error) {
+	query := &storage.ListBookingsQuery{
+		Cinema:   screening.Cinema,
+		Location: screening.Location,
+		Screen:   screening.Screen,
+		From:     screening.Date,
+		// PostgreSQL keeps dates to the microsecond, so nothing else falls in between.
+		To:    screening.Date.Add(time.Microsecond),
+		Limit: screeningPageSize,
+	}
+
+	cancelled := 0
+
+	for {
+		bookings, err := s.Storage.ListBookings(ctx, query)
+		if err != nil {
+			return cancelled, err
+		}
+
+		for _, booking := range bookings {
+			if booking.Status != storage.StatusBooked {
+				continue
+			}
+
+			_, err = s.cancel(ctx, booking.Ticket, storage.StatusCancelled)
+			if errors.Is(err, ErrAlreadyCancelled) {
+				continue
+			}
+			if err != nil {
+				return cancelled, err
+			}
+
+			cancelled++
+		}
+
+		if len(bookings) < query.Limit {
+			return cancelled, nil
+		}
+
+		last := bookings[len(bookings)-1]
+		query.AfterDate = last.Date
+		query.AfterTicket = last.Ticket
+	}
+}
@@ -0,0 +1,196 @@
+package book
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/qr"
+)
+
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+
+	SymbologyQR      = "qr"
+	SymbologyCode128 = "code128"
+)
+
+const (
+	defaultRenderSize = 256
+	maxRenderSize     = 2048
+
+	// qrQuietZone and code128QuietZone are the blank margins, in modules, scanners need around each symbology.
+	qrQuietZone      = 4
+	code128QuietZone = 10
+)
+
+// symbol{} is a barcode as a grid of modules, ready to be drawn.
+//
+// Rows of 1D barcodes are all the same, so they only have one, stretched to half the width when drawn.
+type symbol struct {
+	dark  [][]bool
+	quiet int
+}
+
+// encodeSymbol() encodes content with the given symbology.
+func encodeSymbol(symbology, content string) (*symbol, error) {
+	var (
+		code  barcode.Barcode
+		quiet int
+		err   error
+	)
+
+	switch symbology {
+	case SymbologyQR:
+		code, err = qr.Encode(content, qr.M, qr.Auto)
+		quiet = qrQuietZone
+
+	case SymbologyCode128:
+		code, err = code128.Encode(content)
+		quiet = code128QuietZone
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSymbology, symbology)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := code.Bounds()
+	rows := bounds.Dy()
+	if code.Metadata().Dimensions == 1 {
+		rows = 1
+	}
+
+	sym := &symbol{
+		dark:  make([][]bool, rows),
+		quiet: quiet,
+	}
+
+	for y := range rows {
+		sym.dark[y] = make([]bool, bounds.Dx())
+
+		for x := range bounds.Dx() {
+			r, _, _, _ := code.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			sym.dark[y][x] = r == 0
+		}
+	}
+
+	return sym, nil
+}
+
+// width() returns the width of the symbol in modules, quiet zone included.
+func (s *symbol) width() int {
+	return len(s.dark[0]) + 2*s.quiet
+}
+
+// height() returns the height of the symbol in modules, quiet zone included.
+func (s *symbol) height() int {
+	if len(s.dark) == 1 {
+		return s.width() / 2
+	}
+
+	return len(s.dark) + 2*s.quiet
+}
+
+// rowAt() returns the modules drawn on the y-th row of the symbol, counted from its quiet zone, or nil for blank rows.
+func (s *symbol) rowAt(y int) []bool {
+	if len(s.dark) == 1 {
+		return s.dark[0]
+	}
+
+	y -= s.quiet
+	if y < 0 || y >= len(s.dark) {
+		return nil
+	}
+
+	return s.dark[y]
+}
+
+// png() draws the symbol as a PNG image at most size pixels wide.
+//
+// Every module takes the same whole number of pixels, so the image is usually slightly narrower than size. Returns ErrRenderTooSmall if a module doesn't even fit one pixel.
+func (s *symbol) png(size int) ([]byte, error) {
+	scale := size / s.width()
+	if scale == 0 {
+		return nil, ErrRenderTooSmall
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, s.width()*scale, s.height()*scale), color.Palette{color.White, color.Black})
+
+	for y := range s.height() {
+		row := s.rowAt(y)
+
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+
+			for py := y * scale; py < (y+1)*scale; py++ {
+				for px := (s.quiet + x) * scale; px < (s.quiet+x+1)*scale; px++ {
+					img.SetColorIndex(px, py, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// svg() draws the symbol as an SVG image size pixels wide, with one rectangle per run of dark modules.
+//
+// The image is drawn in modules and scaled through its viewBox, so it stays sharp at any size.
+func (s *symbol) svg(size int) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(
+		&buf,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size,
+		size*s.height()/s.width(),
+		s.width(),
+		s.height(),
+	)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/>`, s.width(), s.height())
+
+	// Bars of 1D barcodes span the whole height at once.
+	rows, barHeight := s.height(), 1
+	if len(s.dark) == 1 {
+		rows, barHeight = 1, s.height()
+	}
+
+	for y := range rows {
+		row := s.rowAt(y)
+
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+
+			run := 1
+			for x+run < len(row) && row[x+run] {
+				run++
+			}
+
+			fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="%d" height="%d"/>`, s.quiet+x, y, run, barHeight)
+
+			x += run - 1
+		}
+	}
+
+	buf.WriteString(`</svg>`)
+
+	return buf.Bytes()
+}
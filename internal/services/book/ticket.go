@@ -0,0 +1,198 @@
+package book
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bookamovie/book/internal/utils"
+	"github.com/bookamovie/book/pkg/ticket"
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+	"github.com/oklog/ulid/v2"
+	"github.com/thanhpk/randstr"
+)
+
+const (
+	GeneratorLuhn   = "luhn"
+	GeneratorPrefix = "prefix"
+	GeneratorULID   = "ulid"
+)
+
+// defaultPrefix is used for cinemas without a configured prefix whose name has no letters or digits.
+const defaultPrefix = "TKT"
+
+var (
+	ErrUnknownGenerator = fmt.Errorf("ticket generator is unknown")
+	ErrInvalidLength    = fmt.Errorf("ticket length must be at least 2")
+	ErrSecretFile       = fmt.Errorf("signing key secret file can't be read")
+)
+
+// TicketGenerator{} abstracts how tickets of new bookings are generated.
+//
+// Tickets don't have to be unique: the service generates another one when the storage reports a collision.
+type TicketGenerator interface {
+	Generate(cinema *bookrpc.Cinema) string
+}
+
+// NewTicketGenerator() returns the ticket generator selected in the config.
+//
+// Returns ErrInvalidLength if luhn or prefix tickets would have no digit besides the check digit, as every one of them would then collide.
+func NewTicketGenerator(cfg utils.Config) (TicketGenerator, error) {
+	if cfg.TicketConfig.Length < 2 && (cfg.TicketConfig.Generator == GeneratorLuhn || cfg.TicketConfig.Generator == GeneratorPrefix) {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidLength, cfg.TicketConfig.Length)
+	}
+
+	luhn := &LuhnGenerator{
+		Length: cfg.TicketConfig.Length,
+	}
+
+	switch cfg.TicketConfig.Generator {
+	case GeneratorLuhn:
+		return luhn, nil
+
+	case GeneratorPrefix:
+		return &PrefixGenerator{
+			Prefixes: cfg.TicketConfig.Prefixes,
+			Digits:   luhn,
+		}, nil
+
+	case GeneratorULID:
+		return &ULIDGenerator{}, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownGenerator, cfg.TicketConfig.Generator)
+	}
+}
+
+// LuhnGenerator{} generates random numeric tickets ending with a Luhn check digit, so mistyped tickets can be told apart from unknown ones.
+//
+// Length counts the check digit.
+type LuhnGenerator struct {
+	Length int
+}
+
+// Generate() returns Length-1 random digits followed by their Luhn check digit.
+func (g *LuhnGenerator) Generate(cinema *bookrpc.Cinema) string {
+	digits := randstr.Dec(g.Length - 1)
+
+	return digits + string(LuhnCheckDigit(digits))
+}
+
+// LuhnCheckDigit() computes the digit that makes the given digits pass the Luhn check.
+func LuhnCheckDigit(digits string) byte {
+	sum := 0
+	double := true
+
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+		double = !double
+	}
+
+	return byte('0' + (10-sum%10)%10)
+}
+
+// PrefixGenerator{} generates tickets prefixed with a code of the cinema, e.g. IMX-12345678903.
+//
+// Prefixes maps cinema names to their codes. Other cinemas get the first three letters or digits of their name, upper-cased.
+type PrefixGenerator struct {
+	Prefixes map[string]string
+	Digits   TicketGenerator
+}
+
+// Generate() returns the cinema code and a ticket of Digits, separated by a dash.
+func (g *PrefixGenerator) Generate(cinema *bookrpc.Cinema) string {
+	prefix, ok := g.Prefixes[cinema.GetName()]
+	if !ok {
+		prefix = derivePrefix(cinema.GetName())
+	}
+
+	return prefix + "-" + g.Digits.Generate(cinema)
+}
+
+// derivePrefix() builds a cinema code out of the first three letters or digits of its name.
+func derivePrefix(name string) string {
+	var b strings.Builder
+
+	for _, r := range name {
+		if b.Len() == len(defaultPrefix) {
+			break
+		}
+
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+
+	if b.Len() == 0 {
+		return defaultPrefix
+	}
+
+	return b.String()
+}
+
+// ULIDGenerator{} generates ULID tickets, which sort by the time they were generated at.
+type ULIDGenerator struct{}
+
+// Generate() returns a new ULID.
+func (g *ULIDGenerator) Generate(cinema *bookrpc.Cinema) string {
+	return ulid.Make().String()
+}
+
+// NewKeyRing() returns the key ring tickets are signed and verified with, built out of the config.
+//
+// Returns nil if no key is configured, in which case tickets aren't signed. Secrets are read from their SecretFile when they aren't in the config, and ErrSecretFile is returned if it can't be read.
+func NewKeyRing(cfg utils.Config) (*ticket.KeyRing, error) {
+	if len(cfg.SigningConfig.Keys) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]ticket.Key, 0, len(cfg.SigningConfig.Keys))
+
+	for _, key := range cfg.SigningConfig.Keys {
+		if key.Secret == "" && key.SecretFile != "" {
+			b, err := os.ReadFile(key.SecretFile)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", ErrSecretFile, key.ID, err)
+			}
+
+			key.Secret = strings.TrimSpace(string(b))
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(key.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ticket.ErrInvalidKey, key.ID, err)
+		}
+
+		keys = append(keys, ticket.Key{
+			ID:        key.ID,
+			Algorithm: key.Algorithm,
+			Secret:    secret,
+		})
+	}
+
+	return ticket.NewKeyRing(cfg.SigningConfig.KeyID, keys...)
+}
+
+// claims() returns what the token of a ticket vouches for.
+func claims(tkt string, data *bookrpc.BookRequest) *ticket.Claims {
+	return &ticket.Claims{
+		Ticket:   tkt,
+		Cinema:   data.GetCinema().GetName(),
+		Location: data.GetCinema().GetLocation(),
+		Screen:   data.GetSession().GetScreen(),
+		Seat:     data.GetSession().GetSeat(),
+		Session:  data.GetSession().GetDate().AsTime(),
+		IssuedAt: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"time"
+
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+)
+
+// Hold{} represents a seat that is temporarily blocked until ExpiresAt, while the customer pays.
+type Hold struct {
+	ID        string
+	Data      *bookrpc.BookRequest
+	ExpiresAt time.Time
+}
+
+// HoldQuery{} contains the holds that have to be placed together.
+type HoldQuery struct {
+	Holds []*Hold
+}
+
+// GetHoldQuery{} contains all necessary information for looking up a hold.
+type GetHoldQuery struct {
+	ID string
+}
+
+// ReleaseExpiredHoldsQuery{} contains the moment holds are checked against.
+type ReleaseExpiredHoldsQuery struct {
+	Now time.Time
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"time"
+)
+
+// IdempotencyRecord{} represents a request made with an idempotency key, along with its result once it is done.
+type IdempotencyRecord struct {
+	Key         string
+	RequestHash string
+	Done        bool
+	Ticket      string
+	Code        uint32
+	Message     string
+}
+
+// ReserveIdempotencyKeyQuery{} contains all necessary information for reserving an idempotency key.
+//
+// Token tells the reservation apart from later ones of the same key, so the request only ever saves or releases its own. ExpiresAt ends the lease of the request: once it is over, the key is free again, unless the request saved its result.
+type ReserveIdempotencyKeyQuery struct {
+	Key         string
+	Token       string
+	RequestHash string
+	ExpiresAt   time.Time
+}
+
+// SaveIdempotencyKeyQuery{} contains the result of a request made with an idempotency key.
+//
+// Token is the one of the reservation: a result is only saved by the request still holding the key. ExpiresAt replaces the lease of the reservation with the end of the window the result is kept for.
+type SaveIdempotencyKeyQuery struct {
+	Key       string
+	Token     string
+	Ticket    string
+	Code      uint32
+	Message   string
+	ExpiresAt time.Time
+}
+
+// ReleaseIdempotencyKeyQuery{} identifies an idempotency key, along with the token of its reservation.
+type ReleaseIdempotencyKeyQuery struct {
+	Key   string
+	Token string
+}
+
+// PurgeExpiredIdempotencyKeysQuery{} contains the moment idempotency keys are checked against.
+type PurgeExpiredIdempotencyKeysQuery struct {
+	Now time.Time
+}
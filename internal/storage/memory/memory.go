@@ -0,0 +1,666 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bookamovie/book/internal/lib/logger"
+	"github.com/bookamovie/book/internal/storage"
+	"github.com/bookamovie/book/internal/utils"
+)
+
+// Storage{} keeps bookings, holds, outbox messages and idempotency keys in memory.
+//
+// It takes the same queries and follows the same rules as the SQLite storage, down to the errors it returns, so the service can't tell them apart. Nothing survives a restart. Screen layouts come from the StorageConfig, as there's no screens table to read them from.
+type Storage struct {
+	Log *logger.Logger
+
+	mu           sync.Mutex
+	bookings     map[string]*storage.Booking
+	holds        map[string]*storage.Hold
+	outbox       []*storage.OutboxMessage
+	nextOutboxID int64
+	deliveries   map[int64][]string
+	keys         map[string]*keyEntry
+	screens      map[storage.GetScreenQuery]*storage.Screen
+
+	config utils.Config
+}
+
+// keyEntry{} is an idempotency key along with the token of its reservation and the end of its window.
+type keyEntry struct {
+	record    storage.IdempotencyRecord
+	token     string
+	expiresAt time.Time
+}
+
+// New() initializes and returns a new Storage instance, empty but for the screen layouts of the config.
+func New(cfg utils.Config, log *logger.Logger) *Storage {
+	screens := make(map[storage.GetScreenQuery]*storage.Screen, len(cfg.StorageConfig.Screens))
+	for _, screen := range cfg.StorageConfig.Screens {
+		key := storage.GetScreenQuery{Cinema: screen.Cinema, Location: screen.Location, Screen: screen.Screen}
+
+		screens[key] = &storage.Screen{
+			Cinema:      screen.Cinema,
+			Location:    screen.Location,
+			Screen:      screen.Screen,
+			Rows:        screen.Rows,
+			SeatsPerRow: screen.SeatsPerRow,
+			Disabled:    slices.Clone(screen.Disabled),
+		}
+	}
+
+	return &Storage{
+		Log: log,
+
+		bookings:   make(map[string]*storage.Booking),
+		holds:      make(map[string]*storage.Hold),
+		deliveries: make(map[int64][]string),
+		keys:       make(map[string]*keyEntry),
+		screens:    screens,
+
+		config: cfg,
+	}
+}
+
+// Ping() always succeeds: there's nothing to reach.
+func (s *Storage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Shutdown() drops everything that was stored. Screen layouts are kept, as they come from the config.
+func (s *Storage) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clear(s.bookings)
+	clear(s.holds)
+	clear(s.keys)
+	clear(s.deliveries)
+	s.outbox = nil
+}
+
+// seat{} identifies a seat of a screen session.
+type seat struct {
+	cinema   string
+	location string
+	screen   int32
+	seat     int32
+	date     time.Time
+}
+
+// is() reports whether both seats are the same.
+func (s seat) is(other seat) bool {
+	return s.cinema == other.cinema &&
+		s.location == other.location &&
+		s.screen == other.screen &&
+		s.seat == other.seat &&
+		s.date.Equal(other.date)
+}
+
+// bookingSeat() returns the seat of a booking.
+func bookingSeat(b *storage.Booking) seat {
+	return seat{
+		cinema:   b.Cinema,
+		location: b.Location,
+		screen:   b.Screen,
+		seat:     b.Seat,
+		date:     b.Date,
+	}
+}
+
+// holdSeat() returns the seat of a hold.
+func holdSeat(h *storage.Hold) seat {
+	return seat{
+		cinema:   h.Data.GetCinema().GetName(),
+		location: h.Data.GetCinema().GetLocation(),
+		screen:   h.Data.GetSession().GetScreen(),
+		seat:     h.Data.GetSession().GetSeat(),
+		date:     h.Data.GetSession().GetDate().AsTime(),
+	}
+}
+
+// newBooking() builds the booking a query would insert.
+func newBooking(query *storage.BookQuery) *storage.Booking {
+	return &storage.Booking{
+		Ticket:   query.Ticket,
+		Cinema:   query.Data.GetCinema().GetName(),
+		Location: query.Data.GetCinema().GetLocation(),
+		Movie:    query.Data.GetMovie().GetTitle(),
+		Screen:   query.Data.GetSession().GetScreen(),
+		Seat:     query.Data.GetSession().GetSeat(),
+		Date:     query.Data.GetSession().GetDate().AsTime(),
+		Status:   storage.StatusBooked,
+	}
+}
+
+// checkBooking() applies the rules of the SQLite INSERT to a booking, taking into account the ones inserted before it within the same call.
+//
+// Seats held by someone else and seats already booked are reported as storage.ErrConstraintUnique, ticket collisions as storage.ErrConstraintPrimaryKey.
+func (s *Storage) checkBooking(ctx context.Context, op string, booking *storage.Booking, hold string, pending []*storage.Booking) error {
+	now := time.Now()
+
+	for id, h := range s.holds {
+		if id != hold && h.ExpiresAt.After(now) && holdSeat(h).is(bookingSeat(booking)) {
+			s.Log.Logs.StorageLog.WarnContext(
+				ctx,
+				storage.ErrConstraintUnique.Error(),
+				slog.String("op", op),
+			)
+
+			return storage.ErrConstraintUnique
+		}
+	}
+
+	_, taken := s.bookings[booking.Ticket]
+	if taken || slices.ContainsFunc(pending, func(b *storage.Booking) bool { return b.Ticket == booking.Ticket }) {
+		s.Log.Logs.StorageLog.WarnContext(
+			ctx,
+			storage.ErrConstraintPrimaryKey.Error(),
+			slog.String("op", op),
+		)
+
+		return storage.ErrConstraintPrimaryKey
+	}
+
+	booked := func(b *storage.Booking) bool {
+		return b.Status == storage.StatusBooked && bookingSeat(b).is(bookingSeat(booking))
+	}
+
+	if slices.ContainsFunc(pending, booked) || s.seatBooked(bookingSeat(booking)) {
+		s.Log.Logs.StorageLog.WarnContext(
+			ctx,
+			storage.ErrConstraintUnique.Error(),
+			slog.String("op", op),
+		)
+
+		return storage.ErrConstraintUnique
+	}
+
+	return nil
+}
+
+// seatBooked() reports whether the seat is booked. The caller must hold the lock.
+func (s *Storage) seatBooked(wanted seat) bool {
+	for _, b := range s.bookings {
+		if b.Status == storage.StatusBooked && bookingSeat(b).is(wanted) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// seatHeld() reports whether the seat is held, expired holds included. The caller must hold the lock.
+func (s *Storage) seatHeld(wanted seat) bool {
+	for _, h := range s.holds {
+		if holdSeat(h).is(wanted) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Book() stores a new booking, releasing the hold it confirms, if any.
+//
+// Returns storage.ErrConstraintUnique if the seat is taken, or storage.ErrConstraintPrimaryKey if the ticket is.
+func (s *Storage) Book(ctx context.Context, query *storage.BookQuery) error {
+	return s.BookMany(ctx, &storage.BookManyQuery{
+		Queries: []*storage.BookQuery{query},
+		Outbox:  query.Outbox,
+	})
+}
+
+// BookMany() stores several bookings at once.
+//
+// Either every booking is stored or none is. Returns storage.ErrConstraintUnique if any of the seats is already taken.
+func (s *Storage) BookMany(ctx context.Context, query *storage.BookManyQuery) error {
+	const op = "BookMany()"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := make([]*storage.Booking, 0, len(query.Queries))
+
+	for _, q := range query.Queries {
+		booking := newBooking(q)
+
+		err := s.checkBooking(ctx, op, booking, q.Hold, pending)
+		if err != nil {
+			return err
+		}
+
+		pending = append(pending, booking)
+	}
+
+	for i, booking := range pending {
+		s.bookings[booking.Ticket] = booking
+
+		if query.Queries[i].Hold != "" {
+			delete(s.holds, query.Queries[i].Hold)
+		}
+	}
+
+	if query.Outbox != nil {
+		s.insertOutbox(query.Outbox)
+	}
+
+	return nil
+}
+
+// GetBooking() looks up a booking by its ticket.
+//
+// Returns sql.ErrNoRows if the ticket is unknown.
+func (s *Storage) GetBooking(ctx context.Context, query *storage.GetBookingQuery) (*storage.Booking, error) {
+	const op = "GetBooking()"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	booking, ok := s.bookings[query.Ticket]
+	if !ok {
+		s.Log.Logs.StorageLog.WarnContext(
+			ctx,
+			"booking not found",
+			slog.String("op", op),
+			slog.String("ticket", query.Ticket),
+		)
+
+		return nil, sql.ErrNoRows
+	}
+
+	b := *booking
+
+	return &b, nil
+}
+
+// ListBookings() lists bookings matching the query, ordered by session date and ticket.
+//
+// Filters and the cursor work as in the SQLite storage.
+func (s *Storage) ListBookings(ctx context.Context, query *storage.ListBookingsQuery) ([]*storage.Booking, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var bookings []*storage.Booking
+
+	for _, b := range s.bookings {
+		switch {
+		case query.Cinema != "" && b.Cinema != query.Cinema,
+			query.Location != "" && b.Location != query.Location,
+			query.Movie != "" && b.Movie != query.Movie,
+			query.Screen != 0 && b.Screen != query.Screen,
+			!query.From.IsZero() && b.Date.Before(query.From),
+			!query.To.IsZero() && !b.Date.Before(query.To),
+			query.AfterTicket != "" && !(b.Date.After(query.AfterDate) || (b.Date.Equal(query.AfterDate) && b.Ticket > query.AfterTicket)):
+			continue
+		}
+
+		booking := *b
+		bookings = append(bookings, &booking)
+	}
+
+	slices.SortFunc(bookings, func(a, b *storage.Booking) int {
+		if c := a.Date.Compare(b.Date); c != 0 {
+			return c
+		}
+
+		return strings.Compare(a.Ticket, b.Ticket)
+	})
+
+	if len(bookings) > query.Limit {
+		bookings = bookings[:query.Limit]
+	}
+
+	return bookings, nil
+}
+
+// TakenSeats() returns the seats that are booked or held for a screen session, in ascending order.
+func (s *Storage) TakenSeats(ctx context.Context, query *storage.TakenSeatsQuery) ([]int32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session := seat{
+		cinema:   query.Cinema,
+		location: query.Location,
+		screen:   query.Screen,
+		date:     query.Date,
+	}
+	now := time.Now()
+
+	var seats []int32
+
+	for _, b := range s.bookings {
+		taken := bookingSeat(b)
+		session.seat = taken.seat
+
+		if b.Status == storage.StatusBooked && taken.is(session) && !slices.Contains(seats, taken.seat) {
+			seats = append(seats, taken.seat)
+		}
+	}
+
+	for _, h := range s.holds {
+		held := holdSeat(h)
+		session.seat = held.seat
+
+		if h.ExpiresAt.After(now) && held.is(session) && !slices.Contains(seats, held.seat) {
+			seats = append(seats, held.seat)
+		}
+	}
+
+	slices.Sort(seats)
+
+	return seats, nil
+}
+
+// GetScreen() returns the layout of a screen, as given in the config.
+//
+// Returns sql.ErrNoRows if the screen has no layout, so the service falls back to the configured number of seats.
+func (s *Storage) GetScreen(ctx context.Context, query *storage.GetScreenQuery) (*storage.Screen, error) {
+	screen, ok := s.screens[*query]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	layout := *screen
+	layout.Disabled = slices.Clone(screen.Disabled)
+
+	return &layout, nil
+}
+
+// CancelBooking() marks an existing booking as cancelled or declined, which releases its seat.
+//
+// Returns the cancelled booking, sql.ErrNoRows if the ticket is unknown, or storage.ErrAlreadyCancelled if the booking was cancelled or declined before.
+func (s *Storage) CancelBooking(ctx context.Context, query *storage.CancelBookingQuery) (*storage.Booking, error) {
+	const op = "CancelBooking()"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	booking, ok := s.bookings[query.Ticket]
+	if !ok {
+		s.Log.Logs.StorageLog.WarnContext(
+			ctx,
+			"booking not found",
+			slog.String("op", op),
+			slog.String("ticket", query.Ticket),
+		)
+
+		return nil, sql.ErrNoRows
+	}
+
+	if booking.Status != storage.StatusBooked {
+		s.Log.Logs.StorageLog.WarnContext(
+			ctx,
+			storage.ErrAlreadyCancelled.Error(),
+			slog.String("op", op),
+			slog.String("ticket", query.Ticket),
+		)
+
+		return nil, storage.ErrAlreadyCancelled
+	}
+
+	booking.Status = query.NewStatus()
+
+	if query.Outbox != nil {
+		s.insertOutbox(query.Outbox)
+	}
+
+	b := *booking
+
+	return &b, nil
+}
+
+// Hold() places several seat holds at once.
+//
+// Either every seat is held or none is. Returns storage.ErrConstraintUnique if any of the seats is already booked or held.
+func (s *Storage) Hold(ctx context.Context, query *storage.HoldQuery) error {
+	const op = "Hold()"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	// Expired holds are dropped first, so they can't block their own seats until the sweeper gets to them.
+	s.releaseExpiredHolds(now)
+
+	for i, hold := range query.Holds {
+		wanted := holdSeat(hold)
+
+		held := func(h *storage.Hold) bool {
+			return holdSeat(h).is(wanted)
+		}
+
+		if s.seatBooked(wanted) || slices.ContainsFunc(query.Holds[:i], held) || s.seatHeld(wanted) {
+			s.Log.Logs.StorageLog.WarnContext(
+				ctx,
+				storage.ErrConstraintUnique.Error(),
+				slog.String("op", op),
+			)
+
+			return storage.ErrConstraintUnique
+		}
+
+		_, taken := s.holds[hold.ID]
+		if taken || slices.ContainsFunc(query.Holds[:i], func(h *storage.Hold) bool { return h.ID == hold.ID }) {
+			s.Log.Logs.StorageLog.WarnContext(
+				ctx,
+				storage.ErrConstraintPrimaryKey.Error(),
+				slog.String("op", op),
+			)
+
+			return storage.ErrConstraintPrimaryKey
+		}
+	}
+
+	for _, hold := range query.Holds {
+		h := *hold
+		s.holds[h.ID] = &h
+	}
+
+	return nil
+}
+
+// GetHold() looks up a hold that has not expired yet.
+//
+// Returns sql.ErrNoRows if the hold is unknown or expired.
+func (s *Storage) GetHold(ctx context.Context, query *storage.GetHoldQuery) (*storage.Hold, error) {
+	const op = "GetHold()"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hold, ok := s.holds[query.ID]
+	if !ok || !hold.ExpiresAt.After(time.Now()) {
+		s.Log.Logs.StorageLog.WarnContext(
+			ctx,
+			"hold not found",
+			slog.String("op", op),
+			slog.String("hold", query.ID),
+		)
+
+		return nil, sql.ErrNoRows
+	}
+
+	h := *hold
+
+	return &h, nil
+}
+
+// ReleaseExpiredHolds() deletes every hold that expired by query.Now, freeing its seat.
+//
+// Returns the number of released holds.
+func (s *Storage) ReleaseExpiredHolds(ctx context.Context, query *storage.ReleaseExpiredHoldsQuery) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.releaseExpiredHolds(query.Now), nil
+}
+
+// releaseExpiredHolds() deletes every hold that expired by now. The caller must hold the lock.
+func (s *Storage) releaseExpiredHolds(now time.Time) int64 {
+	var released int64
+
+	for id, h := range s.holds {
+		if !h.ExpiresAt.After(now) {
+			delete(s.holds, id)
+			released++
+		}
+	}
+
+	return released
+}
+
+// insertOutbox() adds a message to the outbox. The caller must hold the lock.
+func (s *Storage) insertOutbox(msg *storage.OutboxMessage) {
+	s.nextOutboxID++
+
+	s.outbox = append(s.outbox, &storage.OutboxMessage{
+		ID:      s.nextOutboxID,
+		Type:    msg.Type,
+		Payload: slices.Clone(msg.Payload),
+	})
+}
+
+// PendingOutbox() returns messages that have not been delivered to the sink yet, oldest first.
+func (s *Storage) PendingOutbox(ctx context.Context, query *storage.PendingOutboxQuery) ([]*storage.OutboxMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var msgs []*storage.OutboxMessage
+
+	for _, m := range s.outbox {
+		if len(msgs) == query.Limit {
+			break
+		}
+
+		if slices.Contains(s.deliveries[m.ID], query.Sink) {
+			continue
+		}
+
+		msg := *m
+		msgs = append(msgs, &msg)
+	}
+
+	return msgs, nil
+}
+
+// MarkOutbox() records an attempt to deliver an outbox message to a sink.
+//
+// Delivered messages are never returned for that sink again, and are dropped once every sink got them, as nothing would ever read them again. Failed ones stay pending, with their attempts counted.
+func (s *Storage) MarkOutbox(ctx context.Context, query *storage.MarkOutboxQuery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, msg := range s.outbox {
+		if msg.ID != query.ID {
+			continue
+		}
+
+		msg.Attempts++
+
+		if query.Error != "" {
+			break
+		}
+
+		if !slices.Contains(s.deliveries[msg.ID], query.Sink) {
+			s.deliveries[msg.ID] = append(s.deliveries[msg.ID], query.Sink)
+		}
+
+		if storage.DeliveredToAll(s.deliveries[msg.ID], query.Sinks) {
+			s.outbox = slices.Delete(s.outbox, i, i+1)
+			delete(s.deliveries, msg.ID)
+		}
+
+		break
+	}
+
+	return nil
+}
+
+// ReserveIdempotencyKey() claims an idempotency key for a request that is about to be processed.
+//
+// Returns nil if the key was free, or the record of the request that claimed it first. Expired keys don't count.
+func (s *Storage) ReserveIdempotencyKey(ctx context.Context, query *storage.ReserveIdempotencyKeyQuery) (*storage.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.keys[query.Key]
+	if ok && entry.expiresAt.After(time.Now()) {
+		record := entry.record
+
+		return &record, nil
+	}
+
+	s.keys[query.Key] = &keyEntry{
+		record: storage.IdempotencyRecord{
+			Key:         query.Key,
+			RequestHash: query.RequestHash,
+		},
+		token:     query.Token,
+		expiresAt: query.ExpiresAt,
+	}
+
+	return nil, nil
+}
+
+// SaveIdempotencyKey() stores the result of the request that reserved the key, so it can be replayed until the key expires again.
+//
+// Nothing is saved if the reservation was taken over by another request since, its lease having ended.
+func (s *Storage) SaveIdempotencyKey(ctx context.Context, query *storage.SaveIdempotencyKeyQuery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.keys[query.Key]
+	if !ok || entry.token != query.Token {
+		return nil
+	}
+
+	entry.record.Done = true
+	entry.record.Ticket = query.Ticket
+	entry.record.Code = query.Code
+	entry.record.Message = query.Message
+	entry.expiresAt = query.ExpiresAt
+
+	return nil
+}
+
+// ReleaseIdempotencyKey() deletes an idempotency key, so the next request with it is processed again.
+//
+// Nothing is deleted if the reservation was taken over by another request since, its lease having ended.
+func (s *Storage) ReleaseIdempotencyKey(ctx context.Context, query *storage.ReleaseIdempotencyKeyQuery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.keys[query.Key]
+	if ok && entry.token == query.Token {
+		delete(s.keys, query.Key)
+	}
+
+	return nil
+}
+
+// PurgeExpiredIdempotencyKeys() deletes every idempotency key whose window ended by query.Now.
+//
+// Returns the number of purged keys.
+func (s *Storage) PurgeExpiredIdempotencyKeys(ctx context.Context, query *storage.PurgeExpiredIdempotencyKeysQuery) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+
+	for key, entry := range s.keys {
+		if !entry.expiresAt.After(query.Now) {
+			delete(s.keys, key)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
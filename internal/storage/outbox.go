@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"slices"
+)
+
+// OutboxMessage{} represents an event waiting in the outbox to be published to the broker.
+//
+// It is written within the same transaction as the change it describes, so the two can never diverge.
+type OutboxMessage struct {
+	ID       int64
+	Type     string
+	Payload  []byte
+	Attempts int
+}
+
+// PendingOutboxQuery{} selects the messages not delivered to Sink yet, at most Limit of them.
+type PendingOutboxQuery struct {
+	Sink  string
+	Limit int
+}
+
+// MarkOutboxQuery{} contains the outcome of an attempt to deliver an outbox message to a sink.
+//
+// An empty Error means the message was delivered to Sink. It is marked as sent once it was delivered to every sink of Sinks, the ones currently relayed to.
+type MarkOutboxQuery struct {
+	ID    int64
+	Sink  string
+	Sinks []string
+	Error string
+}
+
+// DeliveredToAll() reports whether the sinks a message was delivered to include every one of sinks.
+func DeliveredToAll(delivered []string, sinks []string) bool {
+	for _, sink := range sinks {
+		if !slices.Contains(delivered, sink) {
+			return false
+		}
+	}
+
+	return true
+}
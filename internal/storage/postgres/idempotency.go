@@ -0,0 +1,162 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/bookamovie/book/internal/storage"
+)
+
+// ReserveIdempotencyKey() claims an idempotency key for a request that is about to be processed.
+//
+// Returns nil if the key was free, or the record of the request that claimed it first. Expired records don't count.
+func (s *Storage) ReserveIdempotencyKey(ctx context.Context, query *storage.ReserveIdempotencyKeyQuery) (*storage.IdempotencyRecord, error) {
+	const op = "ReserveIdempotencyKey()"
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't start a transaction",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE key = $1 AND expires_at <= $2;", query.Key, time.Now().UTC())
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't delete an expired idempotency key",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	// A failed statement aborts the whole transaction in PostgreSQL, so conflicts are skipped rather than caught.
+	res, err := tx.ExecContext(
+		ctx,
+		"INSERT INTO idempotency_keys(key, token, request_hash, expires_at) VALUES($1, $2, $3, $4) ON CONFLICT (key) DO NOTHING;",
+		query.Key,
+		query.Token,
+		query.RequestHash,
+		query.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't execute a statement",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	inserted, _ := res.RowsAffected()
+	if inserted > 0 {
+		return nil, tx.Commit()
+	}
+
+	record := storage.IdempotencyRecord{
+		Key: query.Key,
+	}
+
+	err = tx.QueryRowContext(
+		ctx,
+		"SELECT request_hash, done, ticket, code, message FROM idempotency_keys WHERE key = $1;",
+		query.Key,
+	).Scan(&record.RequestHash, &record.Done, &record.Ticket, &record.Code, &record.Message)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select an idempotency key",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// SaveIdempotencyKey() stores the result of the request that reserved the key, so it can be replayed until the key expires again.
+//
+// Nothing is saved if the reservation was taken over by another request since, its lease having ended.
+func (s *Storage) SaveIdempotencyKey(ctx context.Context, query *storage.SaveIdempotencyKeyQuery) error {
+	const op = "SaveIdempotencyKey()"
+
+	_, err := s.DB.ExecContext(
+		ctx,
+		"UPDATE idempotency_keys SET done = TRUE, ticket = $1, code = $2, message = $3, expires_at = $4 WHERE key = $5 AND token = $6;",
+		query.Ticket,
+		query.Code,
+		query.Message,
+		query.ExpiresAt.UTC(),
+		query.Key,
+		query.Token,
+	)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't save an idempotency key",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey() deletes an idempotency key, so the next request with it is processed again.
+//
+// Nothing is deleted if the reservation was taken over by another request since, its lease having ended.
+func (s *Storage) ReleaseIdempotencyKey(ctx context.Context, query *storage.ReleaseIdempotencyKeyQuery) error {
+	const op = "ReleaseIdempotencyKey()"
+
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE key = $1 AND token = $2;", query.Key, query.Token)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't release an idempotency key",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	return nil
+}
+
+// PurgeExpiredIdempotencyKeys() deletes every idempotency key whose window ended by query.Now.
+//
+// Returns the number of purged keys.
+func (s *Storage) PurgeExpiredIdempotencyKeys(ctx context.Context, query *storage.PurgeExpiredIdempotencyKeysQuery) (int64, error) {
+	const op = "PurgeExpiredIdempotencyKeys()"
+
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= $1;", query.Now.UTC())
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't purge expired idempotency keys",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return 0, err
+	}
+
+	purged, _ := res.RowsAffected()
+
+	return purged, nil
+}
@@ -0,0 +1,258 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/bookamovie/book/internal/storage"
+)
+
+// insertOutbox() adds a message to the outbox within the given transaction.
+func (s *Storage) insertOutbox(ctx context.Context, op string, tx *sql.Tx, msg *storage.OutboxMessage) error {
+	_, err := tx.ExecContext(
+		ctx,
+		"INSERT INTO outbox(type, payload, created_at) VALUES($1, $2, $3);",
+		msg.Type,
+		msg.Payload,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't insert an outbox message",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	return nil
+}
+
+// PendingOutbox() selects messages that have not been delivered to the sink yet, oldest first.
+//
+// Only one replica relays to a sink at a time, the one holding its relay lock, so events are neither published twice nor reordered by concurrent relays. Other replicas get no messages until the lock is free again, which happens when the replica holding it shuts down or loses its connection.
+func (s *Storage) PendingOutbox(ctx context.Context, query *storage.PendingOutboxQuery) ([]*storage.OutboxMessage, error) {
+	const op = "PendingOutbox()"
+
+	leader, err := s.leadRelay(ctx, query.Sink)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't take the relay lock",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	if !leader {
+		return nil, nil
+	}
+
+	rows, err := s.DB.QueryContext(
+		ctx,
+		"SELECT id, type, payload, attempts FROM outbox WHERE sent_at IS NULL AND NOT EXISTS (SELECT 1 FROM outbox_deliveries WHERE outbox_id = outbox.id AND sink = $1) ORDER BY id LIMIT $2;",
+		query.Sink,
+		query.Limit,
+	)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select outbox messages",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []*storage.OutboxMessage
+
+	for rows.Next() {
+		var msg storage.OutboxMessage
+
+		err = rows.Scan(&msg.ID, &msg.Type, &msg.Payload, &msg.Attempts)
+		if err != nil {
+			s.Log.Logs.StorageLog.ErrorContext(
+				ctx,
+				"can't scan an outbox message",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+
+			return nil, err
+		}
+
+		msgs = append(msgs, &msg)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't iterate over outbox messages",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// leadRelay() reports whether this replica holds the relay lock of a sink, trying to take it if it doesn't.
+//
+// The lock is a session-level advisory lock, held by a connection kept out of the pool until Shutdown(). A connection that can't be reached anymore is dropped, as PostgreSQL released its lock, and the lock is taken again.
+func (s *Storage) leadRelay(ctx context.Context, sink string) (bool, error) {
+	s.relayMu.Lock()
+	defer s.relayMu.Unlock()
+
+	conn, ok := s.relays[sink]
+	if ok {
+		if conn.PingContext(ctx) == nil {
+			return true, nil
+		}
+
+		conn.Close()
+		delete(s.relays, sink)
+	}
+
+	conn, err := s.DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var locked bool
+
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtextextended($1, 0));", "outbox\x00"+sink).Scan(&locked)
+	if err != nil || !locked {
+		conn.Close()
+
+		return false, err
+	}
+
+	s.relays[sink] = conn
+
+	return true, nil
+}
+
+// MarkOutbox() records an attempt to deliver an outbox message to a sink.
+//
+// Delivered messages are never selected for that sink again, and are marked as sent once every sink got them. Failed ones stay pending, with their attempts counted and the error kept for inspection. The message row is locked first, so relays of several replicas marking deliveries to different sinks can't both miss the last one.
+func (s *Storage) MarkOutbox(ctx context.Context, query *storage.MarkOutboxQuery) error {
+	const op = "MarkOutbox()"
+
+	if query.Error != "" {
+		_, err := s.DB.ExecContext(ctx, "UPDATE outbox SET attempts = attempts + 1, last_error = $1 WHERE id = $2;", query.Error, query.ID)
+		if err != nil {
+			s.Log.Logs.StorageLog.ErrorContext(
+				ctx,
+				"can't mark an outbox message",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+
+			return err
+		}
+
+		return nil
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't start a transaction",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "SELECT id FROM outbox WHERE id = $1 FOR UPDATE;", query.ID)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't lock an outbox message",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO outbox_deliveries(outbox_id, sink, delivered_at) VALUES($1, $2, $3) ON CONFLICT (outbox_id, sink) DO NOTHING;", query.ID, query.Sink, time.Now().UTC())
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't insert an outbox delivery",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	delivered, err := s.deliveredSinks(ctx, tx, query.ID)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select outbox deliveries",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	if storage.DeliveredToAll(delivered, query.Sinks) {
+		_, err = tx.ExecContext(ctx, "UPDATE outbox SET attempts = attempts + 1, last_error = NULL, sent_at = $1 WHERE id = $2;", time.Now().UTC(), query.ID)
+	} else {
+		_, err = tx.ExecContext(ctx, "UPDATE outbox SET attempts = attempts + 1 WHERE id = $1;", query.ID)
+	}
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't mark an outbox message",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deliveredSinks() selects the sinks an outbox message was delivered to, within the given transaction.
+func (s *Storage) deliveredSinks(ctx context.Context, tx *sql.Tx, id int64) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT sink FROM outbox_deliveries WHERE outbox_id = $1;", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sinks []string
+
+	for rows.Next() {
+		var sink string
+
+		err = rows.Scan(&sink)
+		if err != nil {
+			return nil, err
+		}
+
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, rows.Err()
+}
@@ -0,0 +1,592 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bookamovie/book/internal/lib/logger"
+	"github.com/bookamovie/book/internal/lib/tracing"
+	"github.com/bookamovie/book/internal/storage"
+	"github.com/bookamovie/book/internal/utils"
+	"github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// uniqueViolation is the SQLSTATE of unique constraint violations.
+const uniqueViolation = "23505"
+
+// Storage{} handles interaction with the PostgreSQL database.
+//
+// It takes the same queries and returns the same errors as the SQLite storage, so the service can't tell them apart. Unlike SQLite, it can be shared by several replicas of the service.
+//
+// relays holds the connections keeping the relay lock of every sink this replica relays to, see PendingOutbox().
+type Storage struct {
+	DB  *sql.DB
+	Log *logger.Logger
+
+	relayMu sync.Mutex
+	relays  map[string]*sql.Conn
+
+	config utils.Config
+}
+
+// New() initializes and returns a new Storage instance using the given config and logger.
+func New(cfg utils.Config, log *logger.Logger) (*Storage, error) {
+	db, err := sql.Open("postgres", cfg.PostgresConfig.Address)
+	if err != nil {
+		return &Storage{}, err
+	}
+
+	db.SetMaxOpenConns(cfg.PostgresConfig.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.PostgresConfig.MaxIdleConns)
+
+	return &Storage{
+		DB:  db,
+		Log: log,
+
+		relays: make(map[string]*sql.Conn),
+
+		config: cfg,
+	}, nil
+}
+
+// Ping() reports whether the database can be reached, as health checks do.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
+// Shutdown() gracefully closes the database connections, which releases the relay locks, if any.
+func (s *Storage) Shutdown() {
+	s.relayMu.Lock()
+	for sink, conn := range s.relays {
+		conn.Close()
+		delete(s.relays, sink)
+	}
+	s.relayMu.Unlock()
+
+	s.DB.Close()
+}
+
+// violatedConstraint() returns the name of the unique constraint err violates, if any.
+func violatedConstraint(err error) (string, bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return pqErr.Constraint, true
+	}
+
+	return "", false
+}
+
+// lockSeat() takes a lock on a seat of a screen session, held until the transaction ends.
+//
+// Bookings and holds of a seat live in different tables, so no index keeps them from racing each other. Every transaction checking one against the other locks the seat first.
+//
+// PostgreSQL keeps timestamps to the microsecond, so the date is keyed to the microsecond too. Otherwise two dates it stores as one would take different locks.
+func lockSeat(ctx context.Context, tx *sql.Tx, cinema, location string, screen, seat int32, date time.Time) error {
+	_, err := tx.ExecContext(
+		ctx,
+		"SELECT pg_advisory_xact_lock(hashtextextended($1, 0));",
+		fmt.Sprintf("%s\x00%s\x00%d\x00%d\x00%d", cinema, location, screen, date.UnixMicro(), seat),
+	)
+
+	return err
+}
+
+// Book() inserts a new booking into the database.
+//
+// The screen layout and capacity are checked by the service layer beforehand. Returns an error if the insertion fails or constraints are violated. The transaction is traced with a Storage.Book span.
+func (s *Storage) Book(ctx context.Context, query *storage.BookQuery) (err error) {
+	const op = "Book()"
+
+	ctx, span := tracing.Start(ctx, "Storage.Book", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(semconv.DBSystemPostgreSQL))
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't start a transaction",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+	defer tx.Rollback()
+
+	err = s.insertBooking(ctx, op, tx, query)
+	if err != nil {
+		return err
+	}
+
+	if query.Outbox != nil {
+		err = s.insertOutbox(ctx, op, tx, query.Outbox)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// BookMany() inserts several bookings within a single transaction.
+//
+// Either every booking is inserted or none is. Returns storage.ErrConstraintUnique if any of the seats is already taken. The transaction is traced with a Storage.BookMany span.
+func (s *Storage) BookMany(ctx context.Context, query *storage.BookManyQuery) (err error) {
+	const op = "BookMany()"
+
+	ctx, span := tracing.Start(ctx, "Storage.BookMany", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(semconv.DBSystemPostgreSQL))
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't start a transaction",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, q := range query.Queries {
+		err = s.insertBooking(ctx, op, tx, q)
+		if err != nil {
+			return err
+		}
+	}
+
+	if query.Outbox != nil {
+		err = s.insertOutbox(ctx, op, tx, query.Outbox)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// insertBooking() inserts a single booking within the given transaction.
+//
+// Seats held by someone else and unique constraint violations are reported as storage.ErrConstraintUnique, ticket collisions as storage.ErrConstraintPrimaryKey, just like the SQLite storage does.
+func (s *Storage) insertBooking(ctx context.Context, op string, tx *sql.Tx, query *storage.BookQuery) error {
+	err := lockSeat(
+		ctx,
+		tx,
+		query.Data.Cinema.Name,
+		query.Data.Cinema.Location,
+		query.Data.Session.Screen,
+		query.Data.Session.Seat,
+		query.Data.Session.Date.AsTime(),
+	)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't lock a seat",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	var held int
+
+	err = tx.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*) FROM holds WHERE cinema = $1 AND location = $2 AND screen = $3 AND date = $4 AND seat = $5 AND expires_at > $6 AND id != $7;",
+		query.Data.Cinema.Name,
+		query.Data.Cinema.Location,
+		query.Data.Session.Screen,
+		query.Data.Session.Date.AsTime(),
+		query.Data.Session.Seat,
+		time.Now().UTC(),
+		query.Hold,
+	).Scan(&held)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select a hold",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	if held > 0 {
+		s.Log.Logs.StorageLog.WarnContext(
+			ctx,
+			storage.ErrConstraintUnique.Error(),
+			slog.String("op", op),
+		)
+
+		return storage.ErrConstraintUnique
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO bookings(id, movie, screen, seat, date, cinema, location) VALUES($1, $2, $3, $4, $5, $6, $7);",
+		query.Ticket,
+		query.Data.Movie.Title,
+		query.Data.Session.Screen,
+		query.Data.Session.Seat,
+		query.Data.Session.Date.AsTime(),
+		query.Data.Cinema.Name,
+		query.Data.Cinema.Location,
+	)
+	if err != nil {
+		if constraint, ok := violatedConstraint(err); ok {
+			if constraint == "bookings_pkey" {
+				s.Log.Logs.StorageLog.WarnContext(
+					ctx,
+					storage.ErrConstraintPrimaryKey.Error(),
+					slog.String("op", op),
+				)
+
+				return storage.ErrConstraintPrimaryKey
+			}
+
+			s.Log.Logs.StorageLog.WarnContext(
+				ctx,
+				storage.ErrConstraintUnique.Error(),
+				slog.String("op", op),
+			)
+
+			return storage.ErrConstraintUnique
+		}
+
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't execute a statement",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	if query.Hold != "" {
+		_, err = tx.ExecContext(ctx, "DELETE FROM holds WHERE id = $1;", query.Hold)
+		if err != nil {
+			s.Log.Logs.StorageLog.ErrorContext(
+				ctx,
+				"can't release a hold",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bookingColumns lists the columns scanBooking() expects, in order.
+const bookingColumns = "id, movie, screen, seat, date, cinema, location, status"
+
+// scanner{} is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanBooking() reads a single booking from a row selected with bookingColumns.
+func scanBooking(row scanner) (*storage.Booking, error) {
+	var booking storage.Booking
+
+	err := row.Scan(
+		&booking.Ticket,
+		&booking.Movie,
+		&booking.Screen,
+		&booking.Seat,
+		&booking.Date,
+		&booking.Cinema,
+		&booking.Location,
+		&booking.Status,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	booking.Date = booking.Date.UTC()
+
+	return &booking, nil
+}
+
+// GetBooking() selects a booking by its ticket.
+//
+// Returns sql.ErrNoRows if the ticket is unknown.
+func (s *Storage) GetBooking(ctx context.Context, query *storage.GetBookingQuery) (*storage.Booking, error) {
+	const op = "GetBooking()"
+
+	booking, err := scanBooking(s.DB.QueryRowContext(ctx, "SELECT "+bookingColumns+" FROM bookings WHERE id = $1;", query.Ticket))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Log.Logs.StorageLog.WarnContext(
+				ctx,
+				"booking not found",
+				slog.String("op", op),
+				slog.String("ticket", query.Ticket),
+			)
+
+			return nil, err
+		}
+
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select a booking",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	return booking, nil
+}
+
+// ListBookings() selects bookings matching the query, ordered by session date and ticket.
+//
+// Ordering by ticket after the date keeps the order stable, so the last returned booking can be used as the cursor for the next page.
+func (s *Storage) ListBookings(ctx context.Context, query *storage.ListBookingsQuery) ([]*storage.Booking, error) {
+	const op = "ListBookings()"
+
+	var conditions []string
+	var args []any
+
+	// arg() binds a value and returns its placeholder.
+	arg := func(v any) string {
+		args = append(args, v)
+
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if query.Cinema != "" {
+		conditions = append(conditions, "cinema = "+arg(query.Cinema))
+	}
+	if query.Location != "" {
+		conditions = append(conditions, "location = "+arg(query.Location))
+	}
+	if query.Movie != "" {
+		conditions = append(conditions, "movie = "+arg(query.Movie))
+	}
+	if query.Screen != 0 {
+		conditions = append(conditions, "screen = "+arg(query.Screen))
+	}
+	if !query.From.IsZero() {
+		conditions = append(conditions, "date >= "+arg(query.From.UTC()))
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, "date < "+arg(query.To.UTC()))
+	}
+	if query.AfterTicket != "" {
+		conditions = append(conditions, "(date, id) > ("+arg(query.AfterDate.UTC())+", "+arg(query.AfterTicket)+")")
+	}
+
+	stmt := "SELECT " + bookingColumns + " FROM bookings"
+	if len(conditions) > 0 {
+		stmt += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	stmt += " ORDER BY date, id LIMIT " + arg(query.Limit) + ";"
+
+	rows, err := s.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select bookings",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bookings []*storage.Booking
+
+	for rows.Next() {
+		booking, err := scanBooking(rows)
+		if err != nil {
+			s.Log.Logs.StorageLog.ErrorContext(
+				ctx,
+				"can't scan a booking",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+
+			return nil, err
+		}
+
+		bookings = append(bookings, booking)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't iterate over bookings",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	return bookings, nil
+}
+
+// TakenSeats() selects the seats that are booked or held for a screen session, in ascending order.
+//
+// It applies the same rule Book() fails on duplicates with: booked rows, guarded by bookings_seat_idx, and holds that have not expired. The status is inlined, so PostgreSQL can use the partial index.
+func (s *Storage) TakenSeats(ctx context.Context, query *storage.TakenSeatsQuery) ([]int32, error) {
+	const op = "TakenSeats()"
+
+	rows, err := s.DB.QueryContext(
+		ctx,
+		"SELECT seat FROM bookings WHERE cinema = $1 AND location = $2 AND screen = $3 AND date = $4 AND status = '"+storage.StatusBooked+"' "+
+			"UNION SELECT seat FROM holds WHERE cinema = $1 AND location = $2 AND screen = $3 AND date = $4 AND expires_at > $5 ORDER BY seat;",
+		query.Cinema,
+		query.Location,
+		query.Screen,
+		query.Date.UTC(),
+		time.Now().UTC(),
+	)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select taken seats",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var seats []int32
+
+	for rows.Next() {
+		var seat int32
+
+		err = rows.Scan(&seat)
+		if err != nil {
+			s.Log.Logs.StorageLog.ErrorContext(
+				ctx,
+				"can't scan a seat",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+
+			return nil, err
+		}
+
+		seats = append(seats, seat)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't iterate over seats",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	return seats, nil
+}
+
+// CancelBooking() marks an existing booking as cancelled or declined, which releases its seat.
+//
+// Returns the cancelled booking, sql.ErrNoRows if the ticket is unknown, or storage.ErrAlreadyCancelled if the booking was cancelled or declined before.
+func (s *Storage) CancelBooking(ctx context.Context, query *storage.CancelBookingQuery) (*storage.Booking, error) {
+	const op = "CancelBooking()"
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't start a transaction",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The row is locked, so a concurrent cancellation waits and then sees the booking as cancelled.
+	booking, err := scanBooking(tx.QueryRowContext(ctx, "SELECT "+bookingColumns+" FROM bookings WHERE id = $1 FOR UPDATE;", query.Ticket))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Log.Logs.StorageLog.WarnContext(
+				ctx,
+				"booking not found",
+				slog.String("op", op),
+				slog.String("ticket", query.Ticket),
+			)
+
+			return nil, err
+		}
+
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select a booking",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	if booking.Status != storage.StatusBooked {
+		s.Log.Logs.StorageLog.WarnContext(
+			ctx,
+			storage.ErrAlreadyCancelled.Error(),
+			slog.String("op", op),
+			slog.String("ticket", query.Ticket),
+		)
+
+		return nil, storage.ErrAlreadyCancelled
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE bookings SET status = $1 WHERE id = $2;", query.NewStatus(), query.Ticket)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't execute a statement",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	if query.Outbox != nil {
+		err = s.insertOutbox(ctx, op, tx, query.Outbox)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	booking.Status = query.NewStatus()
+
+	return booking, nil
+}
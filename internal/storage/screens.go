@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"slices"
+)
+
+// Screen{} represents the seat layout of a single screen.
+//
+// Seats are numbered row by row, from 1 up to Rows * SeatsPerRow. Disabled seats exist in the layout but can't be booked.
+type Screen struct {
+	Cinema      string
+	Location    string
+	Screen      int32
+	Rows        int32
+	SeatsPerRow int32
+	Disabled    []int32
+}
+
+// Has() reports whether the seat exists in the layout and can be booked.
+func (s *Screen) Has(seat int32) bool {
+	return seat >= 1 && seat <= s.Rows*s.SeatsPerRow && !slices.Contains(s.Disabled, seat)
+}
+
+// Seats() returns every seat of the layout that can be booked, in ascending order.
+func (s *Screen) Seats() []int32 {
+	var seats []int32
+
+	for seat := int32(1); seat <= s.Rows*s.SeatsPerRow; seat++ {
+		if !slices.Contains(s.Disabled, seat) {
+			seats = append(seats, seat)
+		}
+	}
+
+	return seats
+}
+
+// GetScreenQuery{} identifies a single screen.
+type GetScreenQuery struct {
+	Cinema   string
+	Location string
+	Screen   int32
+}
@@ -0,0 +1,212 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/bookamovie/book/internal/lib/tracing"
+	"github.com/bookamovie/book/internal/storage"
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+	"github.com/mattn/go-sqlite3"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Hold() places several seat holds within a single transaction.
+//
+// Either every seat is held or none is. Returns storage.ErrConstraintUnique if any of the seats is already booked or held. The transaction is traced with a Storage.Hold span.
+func (s *Storage) Hold(ctx context.Context, query *storage.HoldQuery) (err error) {
+	const op = "Hold()"
+
+	ctx, span := tracing.Start(ctx, "Storage.Hold", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(semconv.DBSystemSqlite))
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't start a transaction",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+	defer tx.Rollback()
+
+	// Expired holds are dropped first, so they can't block their own seats until the sweeper gets to them.
+	_, err = tx.ExecContext(ctx, "DELETE FROM holds WHERE expires_at <= ?;", time.Now().UTC())
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't release expired holds",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	for _, hold := range query.Holds {
+		var booked int
+
+		err = tx.QueryRowContext(
+			ctx,
+			"SELECT COUNT(*) FROM bookings WHERE cinema = ? AND location = ? AND screen = ? AND date = ? AND seat = ? AND status = '"+storage.StatusBooked+"';",
+			hold.Data.Cinema.Name,
+			hold.Data.Cinema.Location,
+			hold.Data.Session.Screen,
+			hold.Data.Session.Date.AsTime(),
+			hold.Data.Session.Seat,
+		).Scan(&booked)
+		if err != nil {
+			s.Log.Logs.StorageLog.ErrorContext(
+				ctx,
+				"can't select a booking",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+
+			return err
+		}
+
+		if booked > 0 {
+			s.Log.Logs.StorageLog.WarnContext(
+				ctx,
+				storage.ErrConstraintUnique.Error(),
+				slog.String("op", op),
+			)
+
+			return storage.ErrConstraintUnique
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO holds(id, movie, screen, seat, date, cinema, location, expires_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?);",
+			hold.ID,
+			hold.Data.Movie.Title,
+			hold.Data.Session.Screen,
+			hold.Data.Session.Seat,
+			hold.Data.Session.Date.AsTime(),
+			hold.Data.Cinema.Name,
+			hold.Data.Cinema.Location,
+			hold.ExpiresAt.UTC(),
+		)
+		if err != nil {
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+				s.Log.Logs.StorageLog.WarnContext(
+					ctx,
+					storage.ErrConstraintUnique.Error(),
+					slog.String("op", op),
+				)
+
+				return storage.ErrConstraintUnique
+			}
+
+			s.Log.Logs.StorageLog.ErrorContext(
+				ctx,
+				"can't execute a statement",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetHold() selects a hold that has not expired yet.
+//
+// Returns sql.ErrNoRows if the hold is unknown or expired.
+func (s *Storage) GetHold(ctx context.Context, query *storage.GetHoldQuery) (*storage.Hold, error) {
+	const op = "GetHold()"
+
+	var hold storage.Hold
+	var movie, cinema, location, date, expiresAt string
+	var screen, seat int32
+
+	err := s.DB.QueryRowContext(
+		ctx,
+		"SELECT id, movie, screen, seat, date, cinema, location, expires_at FROM holds WHERE id = ? AND expires_at > ?;",
+		query.ID,
+		time.Now().UTC(),
+	).Scan(&hold.ID, &movie, &screen, &seat, &date, &cinema, &location, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Log.Logs.StorageLog.WarnContext(
+				ctx,
+				"hold not found",
+				slog.String("op", op),
+				slog.String("hold", query.ID),
+			)
+
+			return nil, err
+		}
+
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select a hold",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	sessionDate, err := time.Parse(sqlite3.SQLiteTimestampFormats[0], date)
+	if err != nil {
+		return nil, err
+	}
+
+	hold.ExpiresAt, err = time.Parse(sqlite3.SQLiteTimestampFormats[0], expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	hold.Data = &bookrpc.BookRequest{
+		Cinema: &bookrpc.Cinema{
+			Name:     cinema,
+			Location: location,
+		},
+		Movie: &bookrpc.Movie{
+			Title: movie,
+		},
+		Session: &bookrpc.Session{
+			Screen: screen,
+			Seat:   seat,
+			Date:   timestamppb.New(sessionDate),
+		},
+	}
+
+	return &hold, nil
+}
+
+// ReleaseExpiredHolds() deletes every hold that expired by query.Now, freeing its seat.
+//
+// Returns the number of released holds.
+func (s *Storage) ReleaseExpiredHolds(ctx context.Context, query *storage.ReleaseExpiredHoldsQuery) (int64, error) {
+	const op = "ReleaseExpiredHolds()"
+
+	res, err := s.DB.ExecContext(ctx, "DELETE FROM holds WHERE expires_at <= ?;", query.Now.UTC())
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't release expired holds",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return 0, err
+	}
+
+	released, _ := res.RowsAffected()
+
+	return released, nil
+}
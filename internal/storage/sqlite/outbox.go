@@ -0,0 +1,192 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/bookamovie/book/internal/storage"
+)
+
+// insertOutbox() adds a message to the outbox within the given transaction.
+func (s *Storage) insertOutbox(ctx context.Context, op string, tx *sql.Tx, msg *storage.OutboxMessage) error {
+	_, err := tx.ExecContext(
+		ctx,
+		"INSERT INTO outbox(type, payload, created_at) VALUES(?, ?, ?);",
+		msg.Type,
+		msg.Payload,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't insert an outbox message",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	return nil
+}
+
+// PendingOutbox() selects messages that have not been delivered to the sink yet, oldest first.
+func (s *Storage) PendingOutbox(ctx context.Context, query *storage.PendingOutboxQuery) ([]*storage.OutboxMessage, error) {
+	const op = "PendingOutbox()"
+
+	rows, err := s.DB.QueryContext(
+		ctx,
+		"SELECT id, type, payload, attempts FROM outbox WHERE sent_at IS NULL AND NOT EXISTS (SELECT 1 FROM outbox_deliveries WHERE outbox_id = outbox.id AND sink = ?) ORDER BY id LIMIT ?;",
+		query.Sink,
+		query.Limit,
+	)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select outbox messages",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []*storage.OutboxMessage
+
+	for rows.Next() {
+		var msg storage.OutboxMessage
+
+		err = rows.Scan(&msg.ID, &msg.Type, &msg.Payload, &msg.Attempts)
+		if err != nil {
+			s.Log.Logs.StorageLog.ErrorContext(
+				ctx,
+				"can't scan an outbox message",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+
+			return nil, err
+		}
+
+		msgs = append(msgs, &msg)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't iterate over outbox messages",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	return msgs, nil
+}
+
+// MarkOutbox() records an attempt to deliver an outbox message to a sink.
+//
+// Delivered messages are never selected for that sink again, and are marked as sent once every sink got them. Failed ones stay pending, with their attempts counted and the error kept for inspection.
+func (s *Storage) MarkOutbox(ctx context.Context, query *storage.MarkOutboxQuery) error {
+	const op = "MarkOutbox()"
+
+	if query.Error != "" {
+		_, err := s.DB.ExecContext(ctx, "UPDATE outbox SET attempts = attempts + 1, last_error = ? WHERE id = ?;", query.Error, query.ID)
+		if err != nil {
+			s.Log.Logs.StorageLog.ErrorContext(
+				ctx,
+				"can't mark an outbox message",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+
+			return err
+		}
+
+		return nil
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't start a transaction",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO outbox_deliveries(outbox_id, sink, delivered_at) VALUES(?, ?, ?);", query.ID, query.Sink, time.Now().UTC())
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't insert an outbox delivery",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	delivered, err := s.deliveredSinks(ctx, tx, query.ID)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select outbox deliveries",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	if storage.DeliveredToAll(delivered, query.Sinks) {
+		_, err = tx.ExecContext(ctx, "UPDATE outbox SET attempts = attempts + 1, last_error = NULL, sent_at = ? WHERE id = ?;", time.Now().UTC(), query.ID)
+	} else {
+		_, err = tx.ExecContext(ctx, "UPDATE outbox SET attempts = attempts + 1 WHERE id = ?;", query.ID)
+	}
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't mark an outbox message",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deliveredSinks() selects the sinks an outbox message was delivered to, within the given transaction.
+func (s *Storage) deliveredSinks(ctx context.Context, tx *sql.Tx, id int64) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT sink FROM outbox_deliveries WHERE outbox_id = ?;", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sinks []string
+
+	for rows.Next() {
+		var sink string
+
+		err = rows.Scan(&sink)
+		if err != nil {
+			return nil, err
+		}
+
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, rows.Err()
+}
@@ -0,0 +1,59 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/bookamovie/book/internal/storage"
+)
+
+// GetScreen() selects the layout of a screen.
+//
+// Returns sql.ErrNoRows if the screen has no layout.
+func (s *Storage) GetScreen(ctx context.Context, query *storage.GetScreenQuery) (*storage.Screen, error) {
+	const op = "GetScreen()"
+
+	screen := storage.Screen{
+		Cinema:   query.Cinema,
+		Location: query.Location,
+		Screen:   query.Screen,
+	}
+	var disabled string
+
+	err := s.DB.QueryRowContext(
+		ctx,
+		"SELECT rows, seats_per_row, disabled FROM screens WHERE cinema = ? AND location = ? AND screen = ?;",
+		query.Cinema,
+		query.Location,
+		query.Screen,
+	).Scan(&screen.Rows, &screen.SeatsPerRow, &disabled)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			s.Log.Logs.StorageLog.ErrorContext(
+				ctx,
+				"can't select a screen",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+		}
+
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(disabled), &screen.Disabled)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't decode disabled seats",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	return &screen, nil
+}
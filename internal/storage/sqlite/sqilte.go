@@ -1,13 +1,20 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/bookamovie/book/internal/lib/logger"
+	"github.com/bookamovie/book/internal/lib/tracing"
+	"github.com/bookamovie/book/internal/storage"
 	"github.com/bookamovie/book/internal/utils"
-	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
 	"github.com/mattn/go-sqlite3"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Storage{} handles interaction with the SQLite database.
@@ -33,26 +40,29 @@ func New(cfg utils.Config, log *logger.Logger) (*Storage, error) {
 	}, nil
 }
 
+// Ping() reports whether the database can be reached, as health checks do.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
 // Shutdown() gracefully closes the database connection.
 func (s *Storage) Shutdown() {
 	s.DB.Close()
 }
 
-// BookQuery{} contains all necessary information for creating a booking.
-type BookQuery struct {
-	Ticket string
-	Data   *bookrpc.BookRequest
-}
-
 // Book() inserts a new booking into the database.
 //
-// It ensures the screen has capacity available before inserting. Returns an error if the insertion fails or constraints are violated.
-func (s *Storage) Book(query *BookQuery) error {
+// The screen layout and capacity are checked by the service layer beforehand. Returns an error if the insertion fails or constraints are violated. The transaction is traced with a Storage.Book span.
+func (s *Storage) Book(ctx context.Context, query *storage.BookQuery) (err error) {
 	const op = "Book()"
 
-	tx, err := s.DB.Begin()
+	ctx, span := tracing.Start(ctx, "Storage.Book", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(semconv.DBSystemSqlite))
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
-		s.Log.Logs.StorageLog.Error(
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
 			"can't start a transaction",
 			slog.String("op", op),
 			slog.String("error", err.Error()),
@@ -62,10 +72,103 @@ func (s *Storage) Book(query *BookQuery) error {
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare("INSERT INTO bookings(id, movie, screen, seat, date, cinema, location) VALUES(?, ?, ?, ?, ?, ?, ? );")
+	err = s.insertBooking(ctx, op, tx, query)
+	if err != nil {
+		return err
+	}
+
+	if query.Outbox != nil {
+		err = s.insertOutbox(ctx, op, tx, query.Outbox)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// BookMany() inserts several bookings within a single transaction.
+//
+// Either every booking is inserted or none is. Returns storage.ErrConstraintUnique if any of the seats is already taken. The transaction is traced with a Storage.BookMany span.
+func (s *Storage) BookMany(ctx context.Context, query *storage.BookManyQuery) (err error) {
+	const op = "BookMany()"
+
+	ctx, span := tracing.Start(ctx, "Storage.BookMany", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(semconv.DBSystemSqlite))
+	defer func() { tracing.End(span, err) }()
 
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
-		s.Log.Logs.StorageLog.Error(
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't start a transaction",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, q := range query.Queries {
+		err = s.insertBooking(ctx, op, tx, q)
+		if err != nil {
+			return err
+		}
+	}
+
+	if query.Outbox != nil {
+		err = s.insertOutbox(ctx, op, tx, query.Outbox)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// insertBooking() inserts a single booking within the given transaction.
+//
+// Seats held by someone else and unique constraint violations are reported as storage.ErrConstraintUnique, ticket collisions as storage.ErrConstraintPrimaryKey.
+func (s *Storage) insertBooking(ctx context.Context, op string, tx *sql.Tx, query *storage.BookQuery) error {
+	var held int
+
+	err := tx.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*) FROM holds WHERE cinema = ? AND location = ? AND screen = ? AND date = ? AND seat = ? AND expires_at > ? AND id != ?;",
+		query.Data.Cinema.Name,
+		query.Data.Cinema.Location,
+		query.Data.Session.Screen,
+		query.Data.Session.Date.AsTime(),
+		query.Data.Session.Seat,
+		time.Now().UTC(),
+		query.Hold,
+	).Scan(&held)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select a hold",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return err
+	}
+
+	if held > 0 {
+		s.Log.Logs.StorageLog.WarnContext(
+			ctx,
+			storage.ErrConstraintUnique.Error(),
+			slog.String("op", op),
+		)
+
+		return storage.ErrConstraintUnique
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO bookings(id, movie, screen, seat, date, cinema, location) VALUES(?, ?, ?, ?, ?, ?, ? );")
+
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
 			"can't prepare a statement",
 			slog.String("op", op),
 			slog.String("error", err.Error()),
@@ -73,9 +176,10 @@ func (s *Storage) Book(query *BookQuery) error {
 
 		return err
 	}
-	defer tx.Stmt(stmt).Close()
+	defer stmt.Close()
 
-	res, err := tx.Stmt(stmt).Exec(
+	res, err := stmt.ExecContext(
+		ctx,
 		query.Ticket,
 		query.Data.Movie.Title,
 		query.Data.Session.Screen,
@@ -85,7 +189,29 @@ func (s *Storage) Book(query *BookQuery) error {
 		query.Data.Cinema.Location,
 	)
 	if err != nil {
-		s.Log.Logs.StorageLog.Error(
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			s.Log.Logs.StorageLog.WarnContext(
+				ctx,
+				storage.ErrConstraintUnique.Error(),
+				slog.String("op", op),
+			)
+
+			return storage.ErrConstraintUnique
+		}
+
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			s.Log.Logs.StorageLog.WarnContext(
+				ctx,
+				storage.ErrConstraintPrimaryKey.Error(),
+				slog.String("op", op),
+			)
+
+			return storage.ErrConstraintPrimaryKey
+		}
+
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
 			"can't execute a statement",
 			slog.String("op", op),
 			slog.String("error", err.Error()),
@@ -96,24 +222,337 @@ func (s *Storage) Book(query *BookQuery) error {
 
 	affected, _ := res.RowsAffected()
 	if affected == 0 {
-		s.Log.Logs.StorageLog.Warn(
-			sqlite3.ErrConstraintUnique.Error(),
+		s.Log.Logs.StorageLog.WarnContext(
+			ctx,
+			storage.ErrConstraintUnique.Error(),
 			slog.String("op", op),
 		)
 
-		return sqlite3.ErrConstraintUnique
+		return storage.ErrConstraintUnique
 	}
 
-	return tx.Commit()
+	if query.Hold != "" {
+		_, err = tx.ExecContext(ctx, "DELETE FROM holds WHERE id = ?;", query.Hold)
+		if err != nil {
+			s.Log.Logs.StorageLog.ErrorContext(
+				ctx,
+				"can't release a hold",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bookingColumns lists the columns scanBooking() expects, in order.
+const bookingColumns = "id, movie, screen, seat, date, cinema, location, status"
+
+// scanner{} is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanBooking() reads a single booking from a row selected with bookingColumns.
+//
+// Dates are stored as TEXT, so they are parsed back using the format the driver wrote them with.
+func scanBooking(row scanner) (*storage.Booking, error) {
+	var booking storage.Booking
+	var date string
+
+	err := row.Scan(
+		&booking.Ticket,
+		&booking.Movie,
+		&booking.Screen,
+		&booking.Seat,
+		&date,
+		&booking.Cinema,
+		&booking.Location,
+		&booking.Status,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	booking.Date, err = time.Parse(sqlite3.SQLiteTimestampFormats[0], date)
+	if err != nil {
+		return nil, err
+	}
+
+	return &booking, nil
 }
 
-// UnimplementedStorage{} is a stub that satisfies the storage interface.
+// GetBooking() selects a booking by its ticket.
 //
-// Useful for testing or mock implementations.
-type UnimplementedStorage struct{}
+// Returns sql.ErrNoRows if the ticket is unknown.
+func (s *Storage) GetBooking(ctx context.Context, query *storage.GetBookingQuery) (*storage.Booking, error) {
+	const op = "GetBooking()"
 
-// Book() is a dummy implementation of the Book method, returning nil.
-func (u *UnimplementedStorage) Book(query *BookQuery) error { return nil }
+	booking, err := scanBooking(s.DB.QueryRowContext(ctx, "SELECT "+bookingColumns+" FROM bookings WHERE id = ?;", query.Ticket))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Log.Logs.StorageLog.WarnContext(
+				ctx,
+				"booking not found",
+				slog.String("op", op),
+				slog.String("ticket", query.Ticket),
+			)
+
+			return nil, err
+		}
+
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select a booking",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
 
-// Shutdown() is a dummy implementation of the Shutdown method, returning nil.
-func (u *UnimplementedStorage) Shutdown() {}
+		return nil, err
+	}
+
+	return booking, nil
+}
+
+// ListBookings() selects bookings matching the query, ordered by session date and ticket.
+//
+// Ordering by ticket after the date keeps the order stable, so the last returned booking can be used as the cursor for the next page.
+func (s *Storage) ListBookings(ctx context.Context, query *storage.ListBookingsQuery) ([]*storage.Booking, error) {
+	const op = "ListBookings()"
+
+	var conditions []string
+	var args []any
+
+	if query.Cinema != "" {
+		conditions = append(conditions, "cinema = ?")
+		args = append(args, query.Cinema)
+	}
+	if query.Location != "" {
+		conditions = append(conditions, "location = ?")
+		args = append(args, query.Location)
+	}
+	if query.Movie != "" {
+		conditions = append(conditions, "movie = ?")
+		args = append(args, query.Movie)
+	}
+	if query.Screen != 0 {
+		conditions = append(conditions, "screen = ?")
+		args = append(args, query.Screen)
+	}
+	if !query.From.IsZero() {
+		conditions = append(conditions, "date >= ?")
+		args = append(args, query.From.UTC())
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, "date < ?")
+		args = append(args, query.To.UTC())
+	}
+	if query.AfterTicket != "" {
+		conditions = append(conditions, "(date > ? OR (date = ? AND id > ?))")
+		args = append(args, query.AfterDate.UTC(), query.AfterDate.UTC(), query.AfterTicket)
+	}
+
+	stmt := "SELECT " + bookingColumns + " FROM bookings"
+	if len(conditions) > 0 {
+		stmt += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	stmt += " ORDER BY date, id LIMIT ?;"
+	args = append(args, query.Limit)
+
+	rows, err := s.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select bookings",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bookings []*storage.Booking
+
+	for rows.Next() {
+		booking, err := scanBooking(rows)
+		if err != nil {
+			s.Log.Logs.StorageLog.ErrorContext(
+				ctx,
+				"can't scan a booking",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+
+			return nil, err
+		}
+
+		bookings = append(bookings, booking)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't iterate over bookings",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	return bookings, nil
+}
+
+// TakenSeats() selects the seats that are booked or held for a screen session, in ascending order.
+//
+// It applies the same rule Book() fails on duplicates with: booked rows, guarded by bookings_seat_idx, and holds that have not expired. The status is inlined, so SQLite can use the partial index.
+func (s *Storage) TakenSeats(ctx context.Context, query *storage.TakenSeatsQuery) ([]int32, error) {
+	const op = "TakenSeats()"
+
+	rows, err := s.DB.QueryContext(
+		ctx,
+		"SELECT seat FROM bookings WHERE cinema = ? AND location = ? AND screen = ? AND date = ? AND status = '"+storage.StatusBooked+"' "+
+			"UNION SELECT seat FROM holds WHERE cinema = ? AND location = ? AND screen = ? AND date = ? AND expires_at > ? ORDER BY seat;",
+		query.Cinema,
+		query.Location,
+		query.Screen,
+		query.Date.UTC(),
+		query.Cinema,
+		query.Location,
+		query.Screen,
+		query.Date.UTC(),
+		time.Now().UTC(),
+	)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select taken seats",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var seats []int32
+
+	for rows.Next() {
+		var seat int32
+
+		err = rows.Scan(&seat)
+		if err != nil {
+			s.Log.Logs.StorageLog.ErrorContext(
+				ctx,
+				"can't scan a seat",
+				slog.String("op", op),
+				slog.String("error", err.Error()),
+			)
+
+			return nil, err
+		}
+
+		seats = append(seats, seat)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't iterate over seats",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	return seats, nil
+}
+
+// CancelBooking() marks an existing booking as cancelled or declined, which releases its seat.
+//
+// Returns the cancelled booking, sql.ErrNoRows if the ticket is unknown, or ErrAlreadyCancelled if the booking was cancelled or declined before.
+func (s *Storage) CancelBooking(ctx context.Context, query *storage.CancelBookingQuery) (*storage.Booking, error) {
+	const op = "CancelBooking()"
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't start a transaction",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	booking, err := scanBooking(tx.QueryRowContext(ctx, "SELECT "+bookingColumns+" FROM bookings WHERE id = ?;", query.Ticket))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			s.Log.Logs.StorageLog.WarnContext(
+				ctx,
+				"booking not found",
+				slog.String("op", op),
+				slog.String("ticket", query.Ticket),
+			)
+
+			return nil, err
+		}
+
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't select a booking",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	if booking.Status != storage.StatusBooked {
+		s.Log.Logs.StorageLog.WarnContext(
+			ctx,
+			storage.ErrAlreadyCancelled.Error(),
+			slog.String("op", op),
+			slog.String("ticket", query.Ticket),
+		)
+
+		return nil, storage.ErrAlreadyCancelled
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE bookings SET status = ? WHERE id = ?;", query.NewStatus(), query.Ticket)
+	if err != nil {
+		s.Log.Logs.StorageLog.ErrorContext(
+			ctx,
+			"can't execute a statement",
+			slog.String("op", op),
+			slog.String("error", err.Error()),
+		)
+
+		return nil, err
+	}
+
+	if query.Outbox != nil {
+		err = s.insertOutbox(ctx, op, tx, query.Outbox)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	booking.Status = query.NewStatus()
+
+	return booking, nil
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	StatusBooked    = "booked"
+	StatusCancelled = "cancelled"
+	StatusDeclined  = "declined"
+)
+
+// Errors every storage backend reports the same way, whatever the database reports, so the service can't tell backends apart.
+//
+// ErrConstraintUnique means a seat is already booked or held, ErrConstraintPrimaryKey that a ticket is already taken.
+var (
+	ErrAlreadyCancelled     = fmt.Errorf("booking is already cancelled")
+	ErrConstraintUnique     = fmt.Errorf("UNIQUE constraint failed")
+	ErrConstraintPrimaryKey = fmt.Errorf("PRIMARY KEY constraint failed")
+)
+
+// BookQuery{} contains all necessary information for creating a booking.
+//
+// Hold is the ID of the hold being confirmed, if any. That hold doesn't block the booking and is released along with it. Outbox is the event written along with the booking, if any.
+type BookQuery struct {
+	Ticket string
+	Hold   string
+	Data   *bookrpc.BookRequest
+	Outbox *OutboxMessage
+}
+
+// BookManyQuery{} contains the bookings that have to be inserted together.
+//
+// Outbox is the single event written along with all of them, if any.
+type BookManyQuery struct {
+	Queries []*BookQuery
+	Outbox  *OutboxMessage
+}
+
+// Booking{} represents a single row of the bookings table.
+type Booking struct {
+	Ticket   string
+	Cinema   string
+	Location string
+	Movie    string
+	Screen   int32
+	Seat     int32
+	Date     time.Time
+	Status   string
+}
+
+// BookRequest() converts the Booking back into the BookRequest it was created from.
+func (b *Booking) BookRequest() *bookrpc.BookRequest {
+	return &bookrpc.BookRequest{
+		Cinema: &bookrpc.Cinema{
+			Name:     b.Cinema,
+			Location: b.Location,
+		},
+		Movie: &bookrpc.Movie{
+			Title: b.Movie,
+		},
+		Session: &bookrpc.Session{
+			Screen: b.Screen,
+			Seat:   b.Seat,
+			Date:   timestamppb.New(b.Date),
+		},
+	}
+}
+
+// GetBookingQuery{} contains all necessary information for looking up a booking.
+type GetBookingQuery struct {
+	Ticket string
+}
+
+// ListBookingsQuery{} contains the filters and the cursor for listing bookings.
+//
+// Empty or zero fields are not filtered on. From is inclusive, To is exclusive. When AfterTicket is set, only bookings ordered after (AfterDate, AfterTicket) are listed.
+type ListBookingsQuery struct {
+	Cinema   string
+	Location string
+	Movie    string
+	Screen   int32
+	From     time.Time
+	To       time.Time
+
+	AfterDate   time.Time
+	AfterTicket string
+	Limit       int
+}
+
+// TakenSeatsQuery{} identifies a single screen session.
+type TakenSeatsQuery struct {
+	Cinema   string
+	Location string
+	Screen   int32
+	Date     time.Time
+}
+
+// CancelBookingQuery{} contains all necessary information for cancelling a booking.
+//
+// Status is the status the booking ends up in: StatusCancelled if empty, or StatusDeclined if its payment was declined. Outbox is the event written along with the cancellation, if any.
+type CancelBookingQuery struct {
+	Ticket string
+	Status string
+	Outbox *OutboxMessage
+}
+
+// NewStatus() returns the status the booking ends up in.
+func (q *CancelBookingQuery) NewStatus() string {
+	if q.Status == "" {
+		return StatusCancelled
+	}
+
+	return q.Status
+}
+
+// UnimplementedStorage{} is a stub that satisfies the storage interface.
+//
+// Useful for testing or mock implementations.
+type UnimplementedStorage struct{}
+
+// Book() is a dummy implementation of the Book method, returning nil.
+func (u *UnimplementedStorage) Book(ctx context.Context, query *BookQuery) error { return nil }
+
+// BookMany() is a dummy implementation of the BookMany method, returning nil.
+func (u *UnimplementedStorage) BookMany(ctx context.Context, query *BookManyQuery) error { return nil }
+
+// Hold() is a dummy implementation of the Hold method, returning nil.
+func (u *UnimplementedStorage) Hold(ctx context.Context, query *HoldQuery) error { return nil }
+
+// GetHold() is a dummy implementation of the GetHold method, returning an empty hold.
+func (u *UnimplementedStorage) GetHold(ctx context.Context, query *GetHoldQuery) (*Hold, error) {
+	return &Hold{Data: &bookrpc.BookRequest{}}, nil
+}
+
+// ReleaseExpiredHolds() is a dummy implementation of the ReleaseExpiredHolds method, releasing nothing.
+func (u *UnimplementedStorage) ReleaseExpiredHolds(ctx context.Context, query *ReleaseExpiredHoldsQuery) (int64, error) {
+	return 0, nil
+}
+
+// GetBooking() is a dummy implementation of the GetBooking method, returning an empty booking.
+func (u *UnimplementedStorage) GetBooking(ctx context.Context, query *GetBookingQuery) (*Booking, error) {
+	return &Booking{}, nil
+}
+
+// ListBookings() is a dummy implementation of the ListBookings method, returning no bookings.
+func (u *UnimplementedStorage) ListBookings(ctx context.Context, query *ListBookingsQuery) ([]*Booking, error) {
+	return nil, nil
+}
+
+// TakenSeats() is a dummy implementation of the TakenSeats method, returning no seats.
+func (u *UnimplementedStorage) TakenSeats(ctx context.Context, query *TakenSeatsQuery) ([]int32, error) {
+	return nil, nil
+}
+
+// GetScreen() is a dummy implementation of the GetScreen method, reporting that the screen has no layout.
+func (u *UnimplementedStorage) GetScreen(ctx context.Context, query *GetScreenQuery) (*Screen, error) {
+	return nil, sql.ErrNoRows
+}
+
+// CancelBooking() is a dummy implementation of the CancelBooking method, returning an empty booking.
+func (u *UnimplementedStorage) CancelBooking(ctx context.Context, query *CancelBookingQuery) (*Booking, error) {
+	return &Booking{}, nil
+}
+
+// PendingOutbox() is a dummy implementation of the PendingOutbox method, returning no messages.
+func (u *UnimplementedStorage) PendingOutbox(ctx context.Context, query *PendingOutboxQuery) ([]*OutboxMessage, error) {
+	return nil, nil
+}
+
+// MarkOutbox() is a dummy implementation of the MarkOutbox method, returning nil.
+func (u *UnimplementedStorage) MarkOutbox(ctx context.Context, query *MarkOutboxQuery) error {
+	return nil
+}
+
+// ReserveIdempotencyKey() is a dummy implementation of the ReserveIdempotencyKey method, reporting the key as free.
+func (u *UnimplementedStorage) ReserveIdempotencyKey(ctx context.Context, query *ReserveIdempotencyKeyQuery) (*IdempotencyRecord, error) {
+	return nil, nil
+}
+
+// SaveIdempotencyKey() is a dummy implementation of the SaveIdempotencyKey method, returning nil.
+func (u *UnimplementedStorage) SaveIdempotencyKey(ctx context.Context, query *SaveIdempotencyKeyQuery) error {
+	return nil
+}
+
+// ReleaseIdempotencyKey() is a dummy implementation of the ReleaseIdempotencyKey method, returning nil.
+func (u *UnimplementedStorage) ReleaseIdempotencyKey(ctx context.Context, query *ReleaseIdempotencyKeyQuery) error {
+	return nil
+}
+
+// Ping() is a dummy implementation of the Ping method, returning nil.
+func (u *UnimplementedStorage) Ping(ctx context.Context) error { return nil }
+
+// PurgeExpiredIdempotencyKeys() is a dummy implementation of the PurgeExpiredIdempotencyKeys method, purging nothing.
+func (u *UnimplementedStorage) PurgeExpiredIdempotencyKeys(ctx context.Context, query *PurgeExpiredIdempotencyKeysQuery) (int64, error) {
+	return 0, nil
+}
+
+// Shutdown() is a dummy implementation of the Shutdown method, returning nil.
+func (u *UnimplementedStorage) Shutdown() {}
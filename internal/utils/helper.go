@@ -28,3 +28,125 @@ func ValidateBookRequest(req *bookrpc.BookRequest) bool {
 
 	return true
 }
+
+// ValidateBookManyRequest() validates the fields in the BookManyRequest.
+//
+// It checks the same fields as ValidateBookRequest(), and that at least one seat is requested, with no seat being zero or repeated.
+func ValidateBookManyRequest(req *bookrpc.BookManyRequest) bool {
+	switch {
+	case req.GetCinema().GetName() == "":
+		return false
+
+	case req.GetCinema().GetLocation() == "":
+		return false
+
+	case req.GetMovie().GetTitle() == "":
+		return false
+
+	case len(req.GetSeats()) == 0:
+		return false
+
+	case req.GetScreen() == 0:
+		return false
+
+	case req.GetDate() == nil:
+		return false
+	}
+
+	seen := make(map[int32]bool, len(req.GetSeats()))
+	for _, seat := range req.GetSeats() {
+		if seat == 0 || seen[seat] {
+			return false
+		}
+		seen[seat] = true
+	}
+
+	return true
+}
+
+// ValidateGetBookingRequest() validates the fields in the GetBookingRequest.
+//
+// It checks whether the ticket is set.
+func ValidateGetBookingRequest(req *bookrpc.GetBookingRequest) bool {
+	return req.GetTicket() != ""
+}
+
+// ValidateListBookingsRequest() validates the fields in the ListBookingsRequest.
+//
+// All filters are optional. It checks that the page size and screen are not negative, and that the date range is not reversed.
+func ValidateListBookingsRequest(req *bookrpc.ListBookingsRequest) bool {
+	switch {
+	case req.GetPageSize() < 0:
+		return false
+
+	case req.GetScreen() < 0:
+		return false
+
+	case req.GetFrom() != nil && req.GetTo() != nil && req.GetTo().AsTime().Before(req.GetFrom().AsTime()):
+		return false
+	}
+
+	return true
+}
+
+// ValidateGetSeatAvailabilityRequest() validates the fields in the GetSeatAvailabilityRequest.
+//
+// It checks whether the cinema name, location, screen, and session date are properly set.
+func ValidateGetSeatAvailabilityRequest(req *bookrpc.GetSeatAvailabilityRequest) bool {
+	switch {
+	case req.GetCinema().GetName() == "":
+		return false
+
+	case req.GetCinema().GetLocation() == "":
+		return false
+
+	case req.GetScreen() == 0:
+		return false
+
+	case req.GetDate() == nil:
+		return false
+	}
+
+	return true
+}
+
+// ValidateCancelBookingRequest() validates the fields in the CancelBookingRequest.
+//
+// It checks whether the ticket is set.
+func ValidateCancelBookingRequest(req *bookrpc.CancelBookingRequest) bool {
+	return req.GetTicket() != ""
+}
+
+// ValidateHoldSeatsRequest() validates the fields in the HoldSeatsRequest.
+//
+// It applies the same rules as ValidateBookManyRequest().
+func ValidateHoldSeatsRequest(req *bookrpc.HoldSeatsRequest) bool {
+	return ValidateBookManyRequest(&bookrpc.BookManyRequest{
+		Cinema: req.GetCinema(),
+		Movie:  req.GetMovie(),
+		Screen: req.GetScreen(),
+		Date:   req.GetDate(),
+		Seats:  req.GetSeats(),
+	})
+}
+
+// ValidateConfirmHoldRequest() validates the fields in the ConfirmHoldRequest.
+//
+// It checks whether the hold is set.
+func ValidateConfirmHoldRequest(req *bookrpc.ConfirmHoldRequest) bool {
+	return req.GetHold() != ""
+}
+
+// ValidateVerifyTicketRequest() validates the fields in the VerifyTicketRequest.
+//
+// It checks whether the token is set.
+func ValidateVerifyTicketRequest(req *bookrpc.VerifyTicketRequest) bool {
+	return req.GetToken() != ""
+}
+
+// ValidateRenderTicketRequest() validates the fields in the RenderTicketRequest.
+//
+// It checks whether the ticket is set and the size isn't negative. Format and symbology are checked by the service, which knows what it can render.
+func ValidateRenderTicketRequest(req *bookrpc.RenderTicketRequest) bool {
+	return req.GetTicket() != "" && req.GetSize() >= 0
+}
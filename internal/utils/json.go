@@ -4,9 +4,7 @@ import "encoding/json"
 
 // MarshalJSON() marshals a given value (v) into a JSON byte slice.
 //
-// It uses the standard library's json.Marshal function to convert the value into JSON format. The function does not handle errors explicitly, it will silently ignore any errors.
-func MarshalJSON(v any) []byte {
-	vByte, _ := json.Marshal(v)
-
-	return vByte
+// It uses the standard library's json.Marshal function to convert the value into JSON format, and returns its error as is, so values that can't be serialized are never stored or sent half-encoded.
+func MarshalJSON(v any) ([]byte, error) {
+	return json.Marshal(v)
 }
@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS bookings (id TEXT PRIMARY KEY, movie TEXT NOT NULL, screen INTEGER NOT NULL, seat INTEGER NOT NULL, date TIMESTAMPTZ NOT NULL, cinema TEXT NOT NULL, location TEXT NOT NULL);
\ No newline at end of file
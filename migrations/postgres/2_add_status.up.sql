@@ -0,0 +1 @@
+ALTER TABLE bookings ADD COLUMN status TEXT NOT NULL DEFAULT 'booked';CREATE UNIQUE INDEX IF NOT EXISTS bookings_seat_idx ON bookings (cinema, location, screen, date, seat) WHERE status = 'booked';
\ No newline at end of file
@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS screens (cinema TEXT NOT NULL, location TEXT NOT NULL, screen INTEGER NOT NULL, rows INTEGER NOT NULL, seats_per_row INTEGER NOT NULL, disabled JSONB NOT NULL DEFAULT '[]', PRIMARY KEY (cinema, location, screen));
\ No newline at end of file
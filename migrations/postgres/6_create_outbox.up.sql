@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS outbox (id BIGSERIAL PRIMARY KEY, type TEXT NOT NULL, payload BYTEA NOT NULL, created_at TIMESTAMPTZ NOT NULL, attempts INTEGER NOT NULL DEFAULT 0, last_error TEXT, sent_at TIMESTAMPTZ);CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (id) WHERE sent_at IS NULL;
\ No newline at end of file
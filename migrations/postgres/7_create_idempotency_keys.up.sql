@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (key TEXT PRIMARY KEY, request_hash TEXT NOT NULL, done BOOLEAN NOT NULL DEFAULT FALSE, ticket TEXT NOT NULL DEFAULT '', code INTEGER NOT NULL DEFAULT 0, message TEXT NOT NULL DEFAULT '', expires_at TIMESTAMPTZ NOT NULL);CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
\ No newline at end of file
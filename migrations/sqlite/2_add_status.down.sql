@@ -0,0 +1 @@
+DROP INDEX IF EXISTS bookings_seat_idx;ALTER TABLE bookings DROP COLUMN status;
\ No newline at end of file
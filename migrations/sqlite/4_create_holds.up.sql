@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS holds (id TEXT PRIMARY KEY, movie TEXT NOT NULL, screen INTEGER NOT NULL, seat INTEGER NOT NULL, date TEXT NOT NULL, cinema TEXT NOT NULL, location TEXT NOT NULL, expires_at TEXT NOT NULL);CREATE UNIQUE INDEX IF NOT EXISTS holds_seat_idx ON holds (cinema, location, screen, date, seat);CREATE INDEX IF NOT EXISTS holds_expires_at_idx ON holds (expires_at);
\ No newline at end of file
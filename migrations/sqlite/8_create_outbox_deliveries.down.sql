@@ -0,0 +1 @@
+DROP TABLE IF EXISTS outbox_deliveries;
\ No newline at end of file
@@ -0,0 +1,356 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: bookamovie/book/events/v1/events.proto
+
+package eventsv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Booking is a seat an event is about.
+type Booking struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ticket        string                 `protobuf:"bytes,1,opt,name=ticket,proto3" json:"ticket,omitempty"`
+	Cinema        string                 `protobuf:"bytes,2,opt,name=cinema,proto3" json:"cinema,omitempty"`
+	Location      string                 `protobuf:"bytes,3,opt,name=location,proto3" json:"location,omitempty"`
+	Movie         string                 `protobuf:"bytes,4,opt,name=movie,proto3" json:"movie,omitempty"`
+	Screen        int32                  `protobuf:"varint,5,opt,name=screen,proto3" json:"screen,omitempty"`
+	Seat          int32                  `protobuf:"varint,6,opt,name=seat,proto3" json:"seat,omitempty"`
+	Session       *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=session,proto3" json:"session,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Booking) Reset() {
+	*x = Booking{}
+	mi := &file_bookamovie_book_events_v1_events_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Booking) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Booking) ProtoMessage() {}
+
+func (x *Booking) ProtoReflect() protoreflect.Message {
+	mi := &file_bookamovie_book_events_v1_events_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Booking.ProtoReflect.Descriptor instead.
+func (*Booking) Descriptor() ([]byte, []int) {
+	return file_bookamovie_book_events_v1_events_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Booking) GetTicket() string {
+	if x != nil {
+		return x.Ticket
+	}
+	return ""
+}
+
+func (x *Booking) GetCinema() string {
+	if x != nil {
+		return x.Cinema
+	}
+	return ""
+}
+
+func (x *Booking) GetLocation() string {
+	if x != nil {
+		return x.Location
+	}
+	return ""
+}
+
+func (x *Booking) GetMovie() string {
+	if x != nil {
+		return x.Movie
+	}
+	return ""
+}
+
+func (x *Booking) GetScreen() int32 {
+	if x != nil {
+		return x.Screen
+	}
+	return 0
+}
+
+func (x *Booking) GetSeat() int32 {
+	if x != nil {
+		return x.Seat
+	}
+	return 0
+}
+
+func (x *Booking) GetSession() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Session
+	}
+	return nil
+}
+
+// Data is the data of every booking event.
+//
+// Events in binary mode carry it alone, their attributes being headers.
+type Data struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// One booking for book.created and book.cancelled, several for book.created.many.
+	Bookings      []*Booking `protobuf:"bytes,1,rep,name=bookings,proto3" json:"bookings,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Data) Reset() {
+	*x = Data{}
+	mi := &file_bookamovie_book_events_v1_events_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Data) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Data) ProtoMessage() {}
+
+func (x *Data) ProtoReflect() protoreflect.Message {
+	mi := &file_bookamovie_book_events_v1_events_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Data.ProtoReflect.Descriptor instead.
+func (*Data) Descriptor() ([]byte, []int) {
+	return file_bookamovie_book_events_v1_events_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Data) GetBookings() []*Booking {
+	if x != nil {
+		return x.Bookings
+	}
+	return nil
+}
+
+// Envelope is a booking event along with its CloudEvents attributes.
+//
+// Field names match CloudEvents attributes, so the JSON encoding of an Envelope is a CloudEvent in structured mode.
+type Envelope struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Specversion     string                 `protobuf:"bytes,1,opt,name=specversion,proto3" json:"specversion,omitempty"`
+	Id              string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Source          string                 `protobuf:"bytes,3,opt,name=source,proto3" json:"source,omitempty"`
+	Type            string                 `protobuf:"bytes,4,opt,name=type,proto3" json:"type,omitempty"`
+	Time            *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=time,proto3" json:"time,omitempty"`
+	Datacontenttype string                 `protobuf:"bytes,6,opt,name=datacontenttype,proto3" json:"datacontenttype,omitempty"`
+	Schemaversion   string                 `protobuf:"bytes,7,opt,name=schemaversion,proto3" json:"schemaversion,omitempty"`
+	Traceid         string                 `protobuf:"bytes,8,opt,name=traceid,proto3" json:"traceid,omitempty"`
+	Data            *Data                  `protobuf:"bytes,9,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Envelope) Reset() {
+	*x = Envelope{}
+	mi := &file_bookamovie_book_events_v1_events_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Envelope) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Envelope) ProtoMessage() {}
+
+func (x *Envelope) ProtoReflect() protoreflect.Message {
+	mi := &file_bookamovie_book_events_v1_events_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Envelope.ProtoReflect.Descriptor instead.
+func (*Envelope) Descriptor() ([]byte, []int) {
+	return file_bookamovie_book_events_v1_events_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Envelope) GetSpecversion() string {
+	if x != nil {
+		return x.Specversion
+	}
+	return ""
+}
+
+func (x *Envelope) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Envelope) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *Envelope) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Envelope) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Envelope) GetDatacontenttype() string {
+	if x != nil {
+		return x.Datacontenttype
+	}
+	return ""
+}
+
+func (x *Envelope) GetSchemaversion() string {
+	if x != nil {
+		return x.Schemaversion
+	}
+	return ""
+}
+
+func (x *Envelope) GetTraceid() string {
+	if x != nil {
+		return x.Traceid
+	}
+	return ""
+}
+
+func (x *Envelope) GetData() *Data {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+var File_bookamovie_book_events_v1_events_proto protoreflect.FileDescriptor
+
+const file_bookamovie_book_events_v1_events_proto_rawDesc = "" +
+	"\n" +
+	"&bookamovie/book/events/v1/events.proto\x12\x19bookamovie.book.events.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xcd\x01\n" +
+	"\aBooking\x12\x16\n" +
+	"\x06ticket\x18\x01 \x01(\tR\x06ticket\x12\x16\n" +
+	"\x06cinema\x18\x02 \x01(\tR\x06cinema\x12\x1a\n" +
+	"\blocation\x18\x03 \x01(\tR\blocation\x12\x14\n" +
+	"\x05movie\x18\x04 \x01(\tR\x05movie\x12\x16\n" +
+	"\x06screen\x18\x05 \x01(\x05R\x06screen\x12\x12\n" +
+	"\x04seat\x18\x06 \x01(\x05R\x04seat\x124\n" +
+	"\asession\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\asession\"F\n" +
+	"\x04Data\x12>\n" +
+	"\bbookings\x18\x01 \x03(\v2\".bookamovie.book.events.v1.BookingR\bbookings\"\xb7\x02\n" +
+	"\bEnvelope\x12 \n" +
+	"\vspecversion\x18\x01 \x01(\tR\vspecversion\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\x12\x16\n" +
+	"\x06source\x18\x03 \x01(\tR\x06source\x12\x12\n" +
+	"\x04type\x18\x04 \x01(\tR\x04type\x12.\n" +
+	"\x04time\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12(\n" +
+	"\x0fdatacontenttype\x18\x06 \x01(\tR\x0fdatacontenttype\x12$\n" +
+	"\rschemaversion\x18\a \x01(\tR\rschemaversion\x12\x18\n" +
+	"\atraceid\x18\b \x01(\tR\atraceid\x123\n" +
+	"\x04data\x18\t \x01(\v2\x1f.bookamovie.book.events.v1.DataR\x04dataB3Z1github.com/bookamovie/book/pkg/events/v1;eventsv1b\x06proto3"
+
+var (
+	file_bookamovie_book_events_v1_events_proto_rawDescOnce sync.Once
+	file_bookamovie_book_events_v1_events_proto_rawDescData []byte
+)
+
+func file_bookamovie_book_events_v1_events_proto_rawDescGZIP() []byte {
+	file_bookamovie_book_events_v1_events_proto_rawDescOnce.Do(func() {
+		file_bookamovie_book_events_v1_events_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_bookamovie_book_events_v1_events_proto_rawDesc), len(file_bookamovie_book_events_v1_events_proto_rawDesc)))
+	})
+	return file_bookamovie_book_events_v1_events_proto_rawDescData
+}
+
+var file_bookamovie_book_events_v1_events_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_bookamovie_book_events_v1_events_proto_goTypes = []any{
+	(*Booking)(nil),               // 0: bookamovie.book.events.v1.Booking
+	(*Data)(nil),                  // 1: bookamovie.book.events.v1.Data
+	(*Envelope)(nil),              // 2: bookamovie.book.events.v1.Envelope
+	(*timestamppb.Timestamp)(nil), // 3: google.protobuf.Timestamp
+}
+var file_bookamovie_book_events_v1_events_proto_depIdxs = []int32{
+	3, // 0: bookamovie.book.events.v1.Booking.session:type_name -> google.protobuf.Timestamp
+	0, // 1: bookamovie.book.events.v1.Data.bookings:type_name -> bookamovie.book.events.v1.Booking
+	3, // 2: bookamovie.book.events.v1.Envelope.time:type_name -> google.protobuf.Timestamp
+	1, // 3: bookamovie.book.events.v1.Envelope.data:type_name -> bookamovie.book.events.v1.Data
+	4, // [4:4] is the sub-list for method output_type
+	4, // [4:4] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_bookamovie_book_events_v1_events_proto_init() }
+func file_bookamovie_book_events_v1_events_proto_init() {
+	if File_bookamovie_book_events_v1_events_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_bookamovie_book_events_v1_events_proto_rawDesc), len(file_bookamovie_book_events_v1_events_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_bookamovie_book_events_v1_events_proto_goTypes,
+		DependencyIndexes: file_bookamovie_book_events_v1_events_proto_depIdxs,
+		MessageInfos:      file_bookamovie_book_events_v1_events_proto_msgTypes,
+	}.Build()
+	File_bookamovie_book_events_v1_events_proto = out.File
+	file_bookamovie_book_events_v1_events_proto_goTypes = nil
+	file_bookamovie_book_events_v1_events_proto_depIdxs = nil
+}
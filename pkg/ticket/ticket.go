@@ -0,0 +1,230 @@
+// Package ticket signs and verifies ticket tokens.
+//
+// A token proves that a booking was made, without asking the book service: door scanners embed this package along with the public keys, and verify tokens offline.
+//
+// Tokens look like v1.<key id>.<claims>.<signature>, where claims are base64url-encoded JSON and signature is the base64url-encoded signature of everything before it. Every key has an ID, so keys can be rotated: new tokens are signed with the active key, while retired keys keep verifying the tokens signed before.
+package ticket
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	AlgorithmEd25519 = "ed25519"
+	AlgorithmHMAC    = "hmac-sha256"
+)
+
+// version prefixes every token, so the format can change without breaking older scanners silently.
+const version = "v1"
+
+var (
+	ErrMalformedToken   = fmt.Errorf("ticket token is malformed")
+	ErrUnknownKey       = fmt.Errorf("ticket token is signed with an unknown key")
+	ErrInvalidSignature = fmt.Errorf("ticket token signature is invalid")
+	ErrUnknownAlgorithm = fmt.Errorf("signing algorithm is unknown")
+	ErrInvalidKey       = fmt.Errorf("signing key is invalid")
+	ErrNoActiveKey      = fmt.Errorf("no key to sign with")
+)
+
+// Claims{} is what a token vouches for: a ticket and the seat it books.
+type Claims struct {
+	Ticket   string    `json:"tkt"`
+	Cinema   string    `json:"cin"`
+	Location string    `json:"loc"`
+	Screen   int32     `json:"scr"`
+	Seat     int32     `json:"seat"`
+	Session  time.Time `json:"ses"`
+	IssuedAt time.Time `json:"iat"`
+}
+
+// Key{} is a key tokens are signed or verified with.
+//
+// For AlgorithmHMAC, Secret is the shared secret. For AlgorithmEd25519, Secret is either a 64-byte private key, which can sign and verify, or a 32-byte public key, which can only verify.
+type Key struct {
+	ID        string
+	Algorithm string
+	Secret    []byte
+}
+
+// Public() returns the part of the key that is safe to hand out to scanners.
+//
+// It is the public key for Ed25519 private keys, and the key itself otherwise: HMAC secrets have to be shared as is.
+func (k Key) Public() Key {
+	if k.Algorithm == AlgorithmEd25519 && len(k.Secret) == ed25519.PrivateKeySize {
+		return Key{
+			ID:        k.ID,
+			Algorithm: k.Algorithm,
+			Secret:    []byte(ed25519.PrivateKey(k.Secret).Public().(ed25519.PublicKey)),
+		}
+	}
+
+	return k
+}
+
+// validate() checks that the key can be used with its algorithm.
+func (k Key) validate() error {
+	if k.ID == "" || strings.Contains(k.ID, ".") {
+		return fmt.Errorf("%w: id %q must be non-empty and contain no dots", ErrInvalidKey, k.ID)
+	}
+
+	switch k.Algorithm {
+	case AlgorithmEd25519:
+		if len(k.Secret) != ed25519.PrivateKeySize && len(k.Secret) != ed25519.PublicKeySize {
+			return fmt.Errorf("%w: %s: ed25519 keys must be %d or %d bytes long", ErrInvalidKey, k.ID, ed25519.PrivateKeySize, ed25519.PublicKeySize)
+		}
+
+	case AlgorithmHMAC:
+		if len(k.Secret) < sha256.Size {
+			return fmt.Errorf("%w: %s: hmac secrets must be at least %d bytes long", ErrInvalidKey, k.ID, sha256.Size)
+		}
+
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownAlgorithm, k.Algorithm)
+	}
+
+	return nil
+}
+
+// KeyRing{} holds the keys tokens are signed and verified with.
+type KeyRing struct {
+	active string
+	keys   map[string]Key
+}
+
+// NewKeyRing() returns a KeyRing signing with the key whose ID is active, and verifying with all keys.
+//
+// Scanners, which only verify, leave active empty.
+func NewKeyRing(active string, keys ...Key) (*KeyRing, error) {
+	kr := &KeyRing{
+		active: active,
+		keys:   make(map[string]Key, len(keys)),
+	}
+
+	for _, key := range keys {
+		err := key.validate()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := kr.keys[key.ID]; ok {
+			return nil, fmt.Errorf("%w: %s is listed twice", ErrInvalidKey, key.ID)
+		}
+
+		kr.keys[key.ID] = key
+	}
+
+	if active != "" {
+		key, ok := kr.keys[active]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNoActiveKey, active)
+		}
+
+		if key.Algorithm == AlgorithmEd25519 && len(key.Secret) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("%w: %s is a public key", ErrNoActiveKey, active)
+		}
+	}
+
+	return kr, nil
+}
+
+// Public() returns a KeyRing with the public part of every key, which can verify tokens but not sign them.
+func (kr *KeyRing) Public() *KeyRing {
+	pub := &KeyRing{
+		keys: make(map[string]Key, len(kr.keys)),
+	}
+
+	for id, key := range kr.keys {
+		pub.keys[id] = key.Public()
+	}
+
+	return pub
+}
+
+// Sign() returns a token vouching for the claims, signed with the active key.
+func (kr *KeyRing) Sign(claims *Claims) (string, error) {
+	key, ok := kr.keys[kr.active]
+	if !ok {
+		return "", ErrNoActiveKey
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := version + "." + key.ID + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(key, []byte(signed))), nil
+}
+
+// Verify() checks the token signature and returns the claims it vouches for.
+//
+// Returns ErrMalformedToken, ErrUnknownKey or ErrInvalidSignature if the token can't be trusted.
+func (kr *KeyRing) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 || parts[0] != version {
+		return nil, ErrMalformedToken
+	}
+
+	key, ok := kr.keys[parts[1]]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	signed := []byte(token[:len(token)-len(parts[3])-1])
+
+	if !verify(key, signed, sig) {
+		return nil, ErrInvalidSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	var claims Claims
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&claims)
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	return &claims, nil
+}
+
+// sign() signs the data with the key.
+func sign(key Key, data []byte) []byte {
+	if key.Algorithm == AlgorithmEd25519 {
+		return ed25519.Sign(ed25519.PrivateKey(key.Secret), data)
+	}
+
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
+
+// verify() reports whether sig is a signature of the data made with the key.
+func verify(key Key, data, sig []byte) bool {
+	if key.Algorithm == AlgorithmEd25519 {
+		return ed25519.Verify(key.Public().Secret, data, sig)
+	}
+
+	return hmac.Equal(sign(key, data), sig)
+}
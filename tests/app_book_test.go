@@ -2,6 +2,9 @@ package tests
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,6 +16,7 @@ import (
 	bookrcp "github.com/bookamovie/proto/gen/go/book/v3"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
@@ -93,4 +97,592 @@ func TestBook_Functional(t *testing.T) {
 			}
 		})
 	}
+
+	suite.T.Run("book many", func(t *testing.T) {
+		testBookMany(t, suite.Client)
+	})
+
+	suite.T.Run("get booking", func(t *testing.T) {
+		testGetBooking(t, suite.Client)
+	})
+
+	suite.T.Run("list bookings", func(t *testing.T) {
+		testListBookings(t, suite.Client)
+	})
+
+	suite.T.Run("seat availability", func(t *testing.T) {
+		testGetSeatAvailability(t, suite.Client)
+	})
+
+	suite.T.Run("cancel booking", func(t *testing.T) {
+		testCancelBooking(t, suite.Client)
+	})
+
+	suite.T.Run("hold seats", func(t *testing.T) {
+		testHoldSeats(t, suite.Client)
+	})
+
+	suite.T.Run("confirm hold on a full screen", func(t *testing.T) {
+		testConfirmLastSeat(t, suite.Client, storage.DB)
+	})
+
+	suite.T.Run("screen layout", func(t *testing.T) {
+		testScreenLayout(t, suite.Client, storage.DB)
+	})
+
+	suite.T.Run("idempotency key", func(t *testing.T) {
+		testIdempotencyKey(t, suite.Client)
+	})
+
+	suite.T.Run("verify ticket", func(t *testing.T) {
+		testVerifyTicket(t, suite.Client)
+	})
+
+	suite.T.Run("render ticket", func(t *testing.T) {
+		testRenderTicket(t, suite.Client)
+	})
+}
+
+// testRenderTicket() renders a booked ticket in every supported format and symbology, then with options that can't be rendered.
+func testRenderTicket(t *testing.T, client bookrcp.BookClient) {
+	booked, err := client.Book(context.Background(), &bookrcp.BookRequest{
+		Cinema: &bookrcp.Cinema{
+			Name:     fmt.Sprintf("cinema-%d", time.Now().UnixNano()),
+			Location: "location",
+		},
+		Movie: &bookrcp.Movie{
+			Title: "title",
+		},
+		Session: &bookrcp.Session{
+			Screen: 1,
+			Seat:   1,
+			Date:   timestamppb.New(time.Now()),
+		},
+	})
+	assert.NoError(t, err)
+
+	cases := []struct {
+		name                string
+		format              string
+		symbology           string
+		size                int32
+		expectedCode        codes.Code
+		expectedContentType string
+		expectedPrefix      string
+	}{
+		{name: "default", expectedCode: codes.OK, expectedContentType: "image/png", expectedPrefix: "\x89PNG"},
+		{name: "svg qr", format: "svg", symbology: "qr", expectedCode: codes.OK, expectedContentType: "image/svg+xml", expectedPrefix: "<svg"},
+		{name: "png code128", format: "png", symbology: "code128", size: 512, expectedCode: codes.OK, expectedContentType: "image/png", expectedPrefix: "\x89PNG"},
+		{name: "unknown format", format: "gif", expectedCode: codes.InvalidArgument},
+		{name: "unknown symbology", symbology: "ean13", expectedCode: codes.InvalidArgument},
+		{name: "too small", size: 8, expectedCode: codes.InvalidArgument},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			resp, err := client.RenderTicket(context.Background(), &bookrcp.RenderTicketRequest{
+				Ticket:    booked.GetOrder().GetTicket(),
+				Format:    cs.format,
+				Symbology: cs.symbology,
+				Size:      cs.size,
+			})
+			assert.Equal(t, cs.expectedCode, status.Code(err))
+			assert.Equal(t, cs.expectedContentType, resp.GetContentType())
+			assert.True(t, strings.HasPrefix(string(resp.GetImage()), cs.expectedPrefix))
+		})
+	}
+
+	_, err = client.RenderTicket(context.Background(), &bookrcp.RenderTicketRequest{
+		Ticket: "unknown",
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// testVerifyTicket() verifies the token of a booking before and after it is cancelled, then a token that was tampered with.
+func testVerifyTicket(t *testing.T, client bookrcp.BookClient) {
+	in := &bookrcp.BookRequest{
+		Cinema: &bookrcp.Cinema{
+			Name:     fmt.Sprintf("cinema-%d", time.Now().UnixNano()),
+			Location: "location",
+		},
+		Movie: &bookrcp.Movie{
+			Title: "title",
+		},
+		Session: &bookrcp.Session{
+			Screen: 1,
+			Seat:   1,
+			Date:   timestamppb.New(time.Now()),
+		},
+	}
+
+	booked, err := client.Book(context.Background(), in)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, booked.GetToken())
+
+	resp, err := client.VerifyTicket(context.Background(), &bookrcp.VerifyTicketRequest{
+		Token: booked.GetToken(),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, booked.GetOrder().GetTicket(), resp.GetOrder().GetTicket())
+	assert.Equal(t, in.Session.Seat, resp.GetSession().GetSeat())
+	assert.Equal(t, "booked", resp.GetStatus())
+
+	_, err = client.CancelBooking(context.Background(), &bookrcp.CancelBookingRequest{
+		Ticket: booked.GetOrder().GetTicket(),
+	})
+	assert.NoError(t, err)
+
+	resp, err = client.VerifyTicket(context.Background(), &bookrcp.VerifyTicketRequest{
+		Token: booked.GetToken(),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "cancelled", resp.GetStatus())
+
+	_, err = client.VerifyTicket(context.Background(), &bookrcp.VerifyTicketRequest{
+		Token: booked.GetToken() + "x",
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// testIdempotencyKey() repeats a booking under one idempotency key, then reuses the key for another seat.
+func testIdempotencyKey(t *testing.T, client bookrcp.BookClient) {
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "idempotency-key", fmt.Sprintf("key-%d", time.Now().UnixNano()))
+
+	in := &bookrcp.BookRequest{
+		Cinema: &bookrcp.Cinema{
+			Name:     fmt.Sprintf("cinema-%d", time.Now().UnixNano()),
+			Location: "location",
+		},
+		Movie: &bookrcp.Movie{
+			Title: "title",
+		},
+		Session: &bookrcp.Session{
+			Screen: 1,
+			Seat:   1,
+			Date:   timestamppb.New(time.Now()),
+		},
+	}
+
+	first, err := client.Book(ctx, in)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, first.GetOrder().GetTicket())
+
+	again, err := client.Book(ctx, in)
+	assert.NoError(t, err)
+	assert.Equal(t, first.GetOrder().GetTicket(), again.GetOrder().GetTicket())
+
+	_, err = client.Book(context.Background(), in)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	in.Session.Seat = 2
+
+	_, err = client.Book(ctx, in)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// testScreenLayout() books a session of a screen with 2 rows of 2 seats, seat 4 being disabled.
+func testScreenLayout(t *testing.T, client bookrcp.BookClient, db *sql.DB) {
+	cinema := fmt.Sprintf("layout-%d", time.Now().UnixNano())
+
+	_, err := db.Exec("INSERT INTO screens (cinema, location, screen, rows, seats_per_row, disabled) VALUES (?, 'location', 1, 2, 2, '[4]');", cinema)
+	assert.NoError(t, err)
+
+	in := &bookrcp.BookRequest{
+		Cinema: &bookrcp.Cinema{
+			Name:     cinema,
+			Location: "location",
+		},
+		Movie: &bookrcp.Movie{
+			Title: "title",
+		},
+		Session: &bookrcp.Session{
+			Screen: 1,
+			Date:   timestamppb.New(time.Now()),
+		},
+	}
+
+	cases := []struct {
+		name         string
+		seat         int32
+		expectedCode codes.Code
+	}{
+		{name: "outside the layout", seat: 5, expectedCode: codes.InvalidArgument},
+		{name: "disabled seat", seat: 4, expectedCode: codes.InvalidArgument},
+		{name: "first seat", seat: 1, expectedCode: codes.OK},
+		{name: "second seat", seat: 2, expectedCode: codes.OK},
+		{name: "last seat", seat: 3, expectedCode: codes.OK},
+		{name: "screen is full", seat: 1, expectedCode: codes.ResourceExhausted},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			in.Session.Seat = cs.seat
+
+			_, err := client.Book(context.Background(), in)
+			assert.Equal(t, cs.expectedCode, status.Code(err))
+		})
+	}
+
+	resp, err := client.GetSeatAvailability(context.Background(), &bookrcp.GetSeatAvailabilityRequest{
+		Cinema: in.Cinema,
+		Screen: in.Session.Screen,
+		Date:   in.Session.Date,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int32{1, 2, 3}, resp.GetTaken())
+	assert.Empty(t, resp.GetFree())
+}
+
+// testHoldSeats() holds a seat, checks that it can't be booked by anyone else, then confirms the hold.
+func testHoldSeats(t *testing.T, client bookrcp.BookClient) {
+	cinema := &bookrcp.Cinema{
+		Name:     fmt.Sprintf("cinema-%d", time.Now().UnixNano()),
+		Location: "location",
+	}
+	movie := &bookrcp.Movie{
+		Title: "title",
+	}
+	date := timestamppb.New(time.Now())
+
+	held, err := client.HoldSeats(context.Background(), &bookrcp.HoldSeatsRequest{
+		Cinema: cinema,
+		Movie:  movie,
+		Screen: 1,
+		Date:   date,
+		Seats:  []int32{7},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, held.GetHolds(), 1)
+
+	_, err = client.Book(context.Background(), &bookrcp.BookRequest{
+		Cinema: cinema,
+		Movie:  movie,
+		Session: &bookrcp.Session{
+			Screen: 1,
+			Seat:   7,
+			Date:   date,
+		},
+	})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	confirmed, err := client.ConfirmHold(context.Background(), &bookrcp.ConfirmHoldRequest{
+		Hold: held.GetHolds()[0].GetId(),
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, confirmed.GetOrder().GetTicket())
+
+	_, err = client.ConfirmHold(context.Background(), &bookrcp.ConfirmHoldRequest{
+		Hold: held.GetHolds()[0].GetId(),
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// testConfirmLastSeat() holds the last free seat of a screen with 2 seats, checking that the hold can still be confirmed once the screen is full.
+func testConfirmLastSeat(t *testing.T, client bookrcp.BookClient, db *sql.DB) {
+	cinema := &bookrcp.Cinema{
+		Name:     fmt.Sprintf("full-%d", time.Now().UnixNano()),
+		Location: "location",
+	}
+	movie := &bookrcp.Movie{
+		Title: "title",
+	}
+	date := timestamppb.New(time.Now())
+
+	_, err := db.Exec("INSERT INTO screens (cinema, location, screen, rows, seats_per_row, disabled) VALUES (?, 'location', 1, 1, 2, '[]');", cinema.Name)
+	assert.NoError(t, err)
+
+	_, err = client.Book(context.Background(), &bookrcp.BookRequest{
+		Cinema: cinema,
+		Movie:  movie,
+		Session: &bookrcp.Session{
+			Screen: 1,
+			Seat:   1,
+			Date:   date,
+		},
+	})
+	assert.NoError(t, err)
+
+	held, err := client.HoldSeats(context.Background(), &bookrcp.HoldSeatsRequest{
+		Cinema: cinema,
+		Movie:  movie,
+		Screen: 1,
+		Date:   date,
+		Seats:  []int32{2},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, held.GetHolds(), 1)
+
+	_, err = client.HoldSeats(context.Background(), &bookrcp.HoldSeatsRequest{
+		Cinema: cinema,
+		Movie:  movie,
+		Screen: 1,
+		Date:   date,
+		Seats:  []int32{1},
+	})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	confirmed, err := client.ConfirmHold(context.Background(), &bookrcp.ConfirmHoldRequest{
+		Hold: held.GetHolds()[0].GetId(),
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, confirmed.GetOrder().GetTicket())
+}
+
+// testGetSeatAvailability() books a seat in a fresh cinema and checks that it is reported as taken, and only as taken.
+func testGetSeatAvailability(t *testing.T, client bookrcp.BookClient) {
+	cinema := &bookrcp.Cinema{
+		Name:     fmt.Sprintf("cinema-%d", time.Now().UnixNano()),
+		Location: "location",
+	}
+	date := timestamppb.New(time.Now())
+
+	_, err := client.Book(context.Background(), &bookrcp.BookRequest{
+		Cinema: cinema,
+		Movie: &bookrcp.Movie{
+			Title: "title",
+		},
+		Session: &bookrcp.Session{
+			Screen: 1,
+			Seat:   5,
+			Date:   date,
+		},
+	})
+	assert.NoError(t, err)
+
+	resp, err := client.GetSeatAvailability(context.Background(), &bookrcp.GetSeatAvailabilityRequest{
+		Cinema: cinema,
+		Screen: 1,
+		Date:   date,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int32{5}, resp.GetTaken())
+	assert.NotContains(t, resp.GetFree(), int32(5))
+	assert.Contains(t, resp.GetFree(), int32(1))
+
+	_, err = client.GetSeatAvailability(context.Background(), &bookrcp.GetSeatAvailabilityRequest{
+		Cinema: cinema,
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// testListBookings() books three seats in a fresh cinema, then pages through them two at a time.
+func testListBookings(t *testing.T, client bookrcp.BookClient) {
+	cinema := &bookrcp.Cinema{
+		Name:     fmt.Sprintf("cinema-%d", time.Now().UnixNano()),
+		Location: "location",
+	}
+	date := time.Now()
+
+	for seat := int32(1); seat <= 3; seat++ {
+		_, err := client.Book(context.Background(), &bookrcp.BookRequest{
+			Cinema: cinema,
+			Movie: &bookrcp.Movie{
+				Title: "title",
+			},
+			Session: &bookrcp.Session{
+				Screen: 1,
+				Seat:   seat,
+				Date:   timestamppb.New(date.Add(time.Duration(seat) * time.Hour)),
+			},
+		})
+		assert.NoError(t, err)
+	}
+
+	first, err := client.ListBookings(context.Background(), &bookrcp.ListBookingsRequest{
+		Cinema:   cinema,
+		PageSize: 2,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, first.GetBookings(), 2)
+	assert.NotEmpty(t, first.GetNextPageToken())
+	assert.Equal(t, int32(1), first.GetBookings()[0].GetSession().GetSeat())
+
+	second, err := client.ListBookings(context.Background(), &bookrcp.ListBookingsRequest{
+		Cinema:    cinema,
+		PageSize:  2,
+		PageToken: first.GetNextPageToken(),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, second.GetBookings(), 1)
+	assert.Empty(t, second.GetNextPageToken())
+	assert.Equal(t, int32(3), second.GetBookings()[0].GetSession().GetSeat())
+
+	ranged, err := client.ListBookings(context.Background(), &bookrcp.ListBookingsRequest{
+		Cinema: cinema,
+		From:   timestamppb.New(date.Add(90 * time.Minute)),
+		To:     timestamppb.New(date.Add(150 * time.Minute)),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, ranged.GetBookings(), 1)
+
+	_, err = client.ListBookings(context.Background(), &bookrcp.ListBookingsRequest{
+		Cinema:    cinema,
+		PageToken: "not a token",
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// testBookMany() books a group of seats, then checks that a group overlapping it is rejected without booking any of its seats.
+func testBookMany(t *testing.T, client bookrcp.BookClient) {
+	cinema := &bookrcp.Cinema{
+		Name:     fmt.Sprintf("cinema-%d", time.Now().UnixNano()),
+		Location: "location",
+	}
+	date := timestamppb.New(time.Now())
+
+	in := &bookrcp.BookManyRequest{
+		Cinema: cinema,
+		Movie: &bookrcp.Movie{
+			Title: "title",
+		},
+		Screen: 1,
+		Date:   date,
+		Seats:  []int32{1, 2, 3},
+	}
+
+	resp, err := client.BookMany(context.Background(), in)
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetOrders(), 3)
+
+	in.Seats = []int32{3, 4}
+
+	_, err = client.BookMany(context.Background(), in)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	seats, err := client.GetSeatAvailability(context.Background(), &bookrcp.GetSeatAvailabilityRequest{
+		Cinema: cinema,
+		Screen: 1,
+		Date:   date,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int32{1, 2, 3}, seats.GetTaken())
+
+	in.Seats = []int32{5, 5}
+
+	_, err = client.BookMany(context.Background(), in)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// testGetBooking() books a seat, then checks looking it up by its ticket, and looking up an unknown ticket.
+func testGetBooking(t *testing.T, client bookrcp.BookClient) {
+	in := &bookrcp.BookRequest{
+		Cinema: &bookrcp.Cinema{
+			Name:     "cinema",
+			Location: "location",
+		},
+		Movie: &bookrcp.Movie{
+			Title: "title",
+		},
+		Session: &bookrcp.Session{
+			Screen: 1,
+			Seat:   3,
+			Date:   timestamppb.New(time.Now()),
+		},
+	}
+
+	booked, err := client.Book(context.Background(), in)
+	assert.NoError(t, err)
+
+	cases := []struct {
+		name             string
+		in               *bookrcp.GetBookingRequest
+		expectedCode     codes.Code
+		expectedResponse bool
+	}{
+		{
+			name:             "happy case",
+			in:               &bookrcp.GetBookingRequest{Ticket: booked.GetOrder().GetTicket()},
+			expectedCode:     codes.OK,
+			expectedResponse: true,
+		},
+		{
+			name:             "unknown ticket",
+			in:               &bookrcp.GetBookingRequest{Ticket: "000000000000"},
+			expectedCode:     codes.NotFound,
+			expectedResponse: false,
+		},
+		{
+			name:             "bad request",
+			in:               &bookrcp.GetBookingRequest{},
+			expectedCode:     codes.InvalidArgument,
+			expectedResponse: false,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			resp, err := client.GetBooking(context.Background(), cs.in)
+			assert.Equal(t, cs.expectedCode, status.Code(err))
+			if cs.expectedResponse {
+				assert.Equal(t, cs.in.GetTicket(), resp.GetOrder().GetTicket())
+				assert.Equal(t, in.GetCinema().GetName(), resp.GetCinema().GetName())
+				assert.Equal(t, in.GetSession().GetSeat(), resp.GetSession().GetSeat())
+				assert.True(t, in.GetSession().GetDate().AsTime().Equal(resp.GetSession().GetDate().AsTime()))
+				assert.Equal(t, "booked", resp.GetStatus())
+			} else {
+				assert.Empty(t, resp.GetOrder().GetTicket())
+			}
+		})
+	}
+}
+
+// testCancelBooking() books a seat, then checks cancelling it once, twice, and for an unknown ticket.
+//
+// A cancelled seat must be free for booking again.
+func testCancelBooking(t *testing.T, client bookrcp.BookClient) {
+	in := &bookrcp.BookRequest{
+		Cinema: &bookrcp.Cinema{
+			Name:     "cinema",
+			Location: "location",
+		},
+		Movie: &bookrcp.Movie{
+			Title: "title",
+		},
+		Session: &bookrcp.Session{
+			Screen: 1,
+			Seat:   2,
+			Date:   timestamppb.New(time.Now()),
+		},
+	}
+
+	booked, err := client.Book(context.Background(), in)
+	assert.NoError(t, err)
+
+	cases := []struct {
+		name         string
+		in           *bookrcp.CancelBookingRequest
+		expectedCode codes.Code
+	}{
+		{
+			name:         "happy case",
+			in:           &bookrcp.CancelBookingRequest{Ticket: booked.GetOrder().GetTicket()},
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "already cancelled",
+			in:           &bookrcp.CancelBookingRequest{Ticket: booked.GetOrder().GetTicket()},
+			expectedCode: codes.FailedPrecondition,
+		},
+		{
+			name:         "unknown ticket",
+			in:           &bookrcp.CancelBookingRequest{Ticket: "000000000000"},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name:         "bad request",
+			in:           &bookrcp.CancelBookingRequest{},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			_, err := client.CancelBooking(context.Background(), cs.in)
+			assert.Equal(t, cs.expectedCode, status.Code(err))
+		})
+	}
+
+	rebooked, err := client.Book(context.Background(), in)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, rebooked.GetOrder().GetTicket())
 }
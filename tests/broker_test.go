@@ -0,0 +1,332 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	broker "github.com/bookamovie/book/internal/broker/kafka"
+	"github.com/bookamovie/book/internal/lib/logger"
+	bookservice "github.com/bookamovie/book/internal/services/book"
+	"github.com/bookamovie/book/internal/storage"
+	"github.com/bookamovie/book/internal/storage/memory"
+	"github.com/bookamovie/book/internal/utils"
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestBroker_Unit() produces events in async mode, checking that Shutdown() flushes them and their outcome is logged.
+func TestBroker_Unit(t *testing.T) {
+	var logs bytes.Buffer
+
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			BrokerLog: slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		},
+	}
+
+	saramaCfg := sarama.NewConfig()
+	saramaCfg.Producer.Return.Successes = true
+
+	producer := mocks.NewAsyncProducer(t, saramaCfg)
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(fmt.Errorf("broker is down"))
+
+	var cfg utils.Config
+	cfg.KafkaConfig.Topic = "notifications"
+	cfg.EventsConfig.Encoding = broker.EncodingJSON
+
+	br := broker.NewAsync(cfg, log, producer)
+
+	assert.NoError(t, br.BookNotify(context.Background(), &broker.BookNotifyEvent{Ticket: "1"}))
+	assert.NoError(t, br.BookCancelNotify(context.Background(), &broker.BookCancelEvent{Ticket: "1"}))
+	assert.NoError(t, br.BookNotify(context.Background(), &broker.BookNotifyEvent{Ticket: "2"}))
+
+	br.Shutdown()
+
+	out := logs.String()
+	assert.Equal(t, 2, strings.Count(out, "message produced"))
+	assert.Equal(t, 1, strings.Count(out, "can't produce a message"))
+	assert.Contains(t, out, "broker is down")
+	assert.Contains(t, out, `"op":"BookCancelNotify()"`)
+}
+
+// TestBrokerFlush_Unit() relays outbox messages through Kafka in async mode, checking that only the ones Kafka acknowledged are marked as sent.
+func TestBrokerFlush_Unit(t *testing.T) {
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			BookLog:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+			StorageLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			BrokerLog:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	}
+
+	var cfg utils.Config
+	cfg.KafkaConfig.Topic = "notifications"
+	cfg.EventsConfig.Encoding = broker.EncodingJSON
+	cfg.OutboxConfig.BatchSize = 10
+
+	s := memory.New(cfg, log)
+	writeOutbox(t, s, "1", "2", "3")
+
+	saramaCfg := sarama.NewConfig()
+	saramaCfg.Producer.Return.Successes = true
+
+	// The second message is never delivered, while the third one is.
+	producer := mocks.NewAsyncProducer(t, saramaCfg)
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(fmt.Errorf("broker is down"))
+	producer.ExpectInputAndSucceed()
+
+	br := broker.NewAsync(cfg, log, producer)
+	service := bookservice.New(cfg, log, s, br, &bookservice.LuhnGenerator{Length: 8}, nil)
+
+	published, err := service.RelayOutbox(context.Background())
+	assert.ErrorContains(t, err, "broker is down")
+	assert.Equal(t, 1, published)
+
+	pending, err := s.PendingOutbox(context.Background(), &storage.PendingOutboxQuery{Limit: 10})
+	assert.NoError(t, err)
+	if assert.Len(t, pending, 2) {
+		assert.Equal(t, 1, pending[0].Attempts)
+		assert.Equal(t, 0, pending[1].Attempts)
+	}
+
+	// The next relay publishes both again.
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndSucceed()
+
+	published, err = service.RelayOutbox(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, published)
+
+	pending, err = s.PendingOutbox(context.Background(), &storage.PendingOutboxQuery{Limit: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	br.Shutdown()
+}
+
+// TestBrokerKeys_Unit() produces events keyed by ticket and by session, checking their keys and that the hash partitioner sends a session to a single partition.
+func TestBrokerKeys_Unit(t *testing.T) {
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			BrokerLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	}
+
+	session := time.Date(2025, time.April, 16, 19, 0, 0, 0, time.UTC)
+	booking := func(ticket string, seat int32) *bookrpc.BookRequest {
+		return &bookrpc.BookRequest{
+			Cinema: &bookrpc.Cinema{Name: "Cinema", Location: "Street"},
+			Movie:  &bookrpc.Movie{Title: "Movie"},
+			Session: &bookrpc.Session{
+				Screen: 2,
+				Seat:   seat,
+				Date:   timestamppb.New(session),
+			},
+		}
+	}
+
+	var keys []string
+
+	producer := mocks.NewSyncProducer(t, nil)
+	for range 4 {
+		producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			key, err := msg.Key.Encode()
+			keys = append(keys, string(key))
+
+			return err
+		})
+	}
+
+	var cfg utils.Config
+	cfg.EventsConfig.Encoding = broker.EncodingJSON
+	cfg.KafkaConfig.Key = broker.KeySession
+
+	br := broker.NewSync(cfg, log, producer)
+	assert.NoError(t, br.BookNotify(context.Background(), &broker.BookNotifyEvent{Ticket: "1", Data: booking("1", 7)}))
+	assert.NoError(t, br.BookCancelNotify(context.Background(), &broker.BookCancelEvent{Ticket: "2", Data: booking("2", 8)}))
+
+	cfg.KafkaConfig.Key = broker.KeyTicket
+
+	br = broker.NewSync(cfg, log, producer)
+	assert.NoError(t, br.BookNotify(context.Background(), &broker.BookNotifyEvent{Ticket: "1", Data: booking("1", 7)}))
+	assert.NoError(t, br.BookManyNotify(context.Background(), &broker.BookManyNotifyEvent{
+		Bookings: []*broker.BookNotifyEvent{{Ticket: "3", Data: booking("3", 9)}, {Ticket: "4", Data: booking("4", 10)}},
+	}))
+	br.Shutdown()
+
+	assert.Equal(t, []string{"Cinema/Street/2/2025-04-16T19:00:00Z", "Cinema/Street/2/2025-04-16T19:00:00Z", "1", "3"}, keys)
+
+	constructor, err := broker.Partitioner(broker.PartitionerHash)
+	assert.NoError(t, err)
+
+	partitioner := constructor("notifications")
+	first, err := partitioner.Partition(&sarama.ProducerMessage{Key: sarama.StringEncoder(keys[0])}, 12)
+	assert.NoError(t, err)
+
+	for range 10 {
+		partition, err := partitioner.Partition(&sarama.ProducerMessage{Key: sarama.StringEncoder(keys[1])}, 12)
+		assert.NoError(t, err)
+		assert.Equal(t, first, partition)
+	}
+
+	_, err = broker.Partitioner("random")
+	assert.ErrorIs(t, err, broker.ErrUnknownPartitioner)
+}
+
+// TestKafkaConfig_Unit() maps client ID, version, TLS and SASL settings into Sarama configurations, checking that invalid ones are rejected.
+func TestKafkaConfig_Unit(t *testing.T) {
+	dir := t.TempDir()
+	caFile, certFile, keyFile := writeCertificates(t, dir)
+
+	var cfg utils.Config
+	cfg.KafkaConfig = utils.KafkaConfig{
+		Mode:        broker.ModeSync,
+		Compression: "none",
+		Partitioner: broker.PartitionerHash,
+		ClientID:    "book",
+		Version:     "3.6.0",
+		TLS: utils.KafkaTLSConfig{
+			Enabled:    true,
+			CAFile:     caFile,
+			CertFile:   certFile,
+			KeyFile:    keyFile,
+			ServerName: "kafka.internal",
+		},
+		SASL: utils.KafkaSASLConfig{
+			Mechanism: broker.SASLScramSHA512,
+			Username:  "book",
+			Password:  "secret",
+		},
+	}
+
+	saramaCfg, err := broker.NewConfig(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "book", saramaCfg.ClientID)
+	assert.Equal(t, sarama.V3_6_0_0, saramaCfg.Version)
+	assert.True(t, saramaCfg.Net.TLS.Enable)
+	assert.Equal(t, "kafka.internal", saramaCfg.Net.TLS.Config.ServerName)
+	assert.NotNil(t, saramaCfg.Net.TLS.Config.RootCAs)
+	assert.Len(t, saramaCfg.Net.TLS.Config.Certificates, 1)
+	assert.True(t, saramaCfg.Net.SASL.Enable)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), saramaCfg.Net.SASL.Mechanism)
+
+	// The SCRAM client opens the conversation with the username and a nonce.
+	client := saramaCfg.Net.SASL.SCRAMClientGeneratorFunc()
+	assert.NoError(t, client.Begin("book", "secret", ""))
+
+	first, err := client.Step("")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(first, "n,,n=book,r="))
+	assert.False(t, client.Done())
+
+	invalid := []struct {
+		edit func(c *utils.KafkaConfig)
+		err  error
+	}{
+		{func(c *utils.KafkaConfig) { c.Version = "latest" }, broker.ErrInvalidVersion},
+		{func(c *utils.KafkaConfig) { c.TLS.Enabled = false }, broker.ErrTLSDisabled},
+		{func(c *utils.KafkaConfig) { c.TLS.CAFile = keyFile }, broker.ErrInvalidCA},
+		{func(c *utils.KafkaConfig) { c.TLS.KeyFile = "" }, broker.ErrIncompleteCert},
+		{func(c *utils.KafkaConfig) { c.SASL.Mechanism = "GSSAPI" }, broker.ErrUnknownMechanism},
+		{func(c *utils.KafkaConfig) { c.SASL.Password = "" }, broker.ErrNoCredentials},
+		{func(c *utils.KafkaConfig) { c.Mode = "batch" }, broker.ErrUnknownMode},
+		{func(c *utils.KafkaConfig) { c.Version, c.Compression = "1.0.0", "zstd" }, broker.ErrInvalidConfig},
+	}
+
+	for _, tt := range invalid {
+		bad := cfg
+		tt.edit(&bad.KafkaConfig)
+
+		_, err := broker.NewConfig(bad)
+		assert.ErrorIs(t, err, tt.err)
+	}
+
+	// Without TLS or SASL, connections stay plain.
+	cfg.KafkaConfig.TLS = utils.KafkaTLSConfig{}
+	cfg.KafkaConfig.SASL = utils.KafkaSASLConfig{}
+
+	saramaCfg, err = broker.NewConfig(cfg)
+	assert.NoError(t, err)
+	assert.False(t, saramaCfg.Net.TLS.Enable)
+	assert.False(t, saramaCfg.Net.SASL.Enable)
+}
+
+// writeCertificates() writes a self-signed CA, along with a client certificate and key it issued, as PEM files.
+func writeCertificates(t *testing.T, dir string) (string, string, string) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "book"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+	assert.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	files := []struct {
+		name  string
+		block *pem.Block
+	}{
+		{"ca.pem", &pem.Block{Type: "CERTIFICATE", Bytes: caDER}},
+		{"cert.pem", &pem.Block{Type: "CERTIFICATE", Bytes: certDER}},
+		{"key.pem", &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}},
+	}
+
+	var paths []string
+
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		assert.NoError(t, os.WriteFile(path, pem.EncodeToMemory(f.block), 0600))
+
+		paths = append(paths, path)
+	}
+
+	return paths[0], paths[1], paths[2]
+}
@@ -0,0 +1,344 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/bookamovie/book/internal/app/consumer"
+	"github.com/bookamovie/book/internal/lib/logger"
+	bookservice "github.com/bookamovie/book/internal/services/book"
+	"github.com/bookamovie/book/internal/storage"
+	"github.com/bookamovie/book/internal/storage/memory"
+	"github.com/bookamovie/book/internal/storage/sqlite"
+	"github.com/bookamovie/book/internal/utils"
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestConsumer_Unit() feeds inbound events to the consumer handler, checking the bookings they update and the offsets they commit, against every storage backend.
+func TestConsumer_Unit(t *testing.T) {
+	var cfg utils.Config
+	cfg.SQLiteConfig.Address = "storage/db.sqlite"
+	cfg.ConsumerConfig.Backoff = time.Millisecond
+	cfg.ConsumerConfig.MaxBackoff = 4 * time.Millisecond
+
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			StorageLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			BrokerLog:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	}
+
+	sqliteStorage, err := sqlite.New(cfg, log)
+	assert.NoError(t, err)
+	defer sqliteStorage.Shutdown()
+
+	backends := map[string]bookservice.Querier{
+		"sqlite": sqliteStorage,
+		"memory": memory.New(cfg, log),
+	}
+
+	for name, s := range backends {
+		t.Run(name, func(t *testing.T) {
+			testConsumerEvents(t, cfg, log, s)
+		})
+	}
+
+	// Failed writes are retried until they succeed, and only then marked for the next commit.
+	flaky := &flakyTransitioner{failures: 2}
+	session := newFakeSession()
+
+	handler := consumer.NewHandler(log, cfg, flaky)
+	assert.NoError(t, handler.ConsumeClaim(session, newFakeClaim(inbound(consumer.PaymentDeclinedEventType, `{"ticket":"1"}`))))
+	assert.Equal(t, 3, flaky.calls)
+	assert.Equal(t, []int64{0}, session.marked)
+
+	// Events still failing when the session ends aren't committed, so the next owner of the partition gets them again.
+	flaky = &flakyTransitioner{failures: 1000}
+	session = newFakeSession()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	session.ctx = ctx
+
+	handler = consumer.NewHandler(log, cfg, flaky)
+	assert.NoError(t, handler.ConsumeClaim(session, newFakeClaim(inbound(consumer.PaymentDeclinedEventType, `{"ticket":"1"}`))))
+	assert.Empty(t, session.marked)
+	assert.Equal(t, 0, session.commits)
+}
+
+// TestConsumerGroup_Unit() runs the consumer over two partitions, one of them stuck on an event that keeps failing, checking that it holds back the rest of its partition only, and that once Shutdown() ends the session only the offsets of applied events are committed.
+func TestConsumerGroup_Unit(t *testing.T) {
+	var cfg utils.Config
+	cfg.ConsumerConfig.Backoff = time.Millisecond
+	cfg.ConsumerConfig.MaxBackoff = 4 * time.Millisecond
+
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			BrokerLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	}
+
+	app, err := consumer.New(log, cfg, nil)
+	assert.NoError(t, err)
+
+	service := &stuckTransitioner{stuck: "stuck", calls: map[string]int{}}
+	app.Handler = consumer.NewHandler(log, cfg, service)
+
+	group := &fakeGroup{
+		claims: []*fakeClaim{
+			newFakePartition(0, inbound(consumer.PaymentDeclinedEventType, `{"ticket":"stuck"}`), inbound(consumer.PaymentDeclinedEventType, `{"ticket":"held back"}`)),
+			newFakePartition(1, inbound(consumer.PaymentDeclinedEventType, `{"ticket":"1"}`), inbound(consumer.PaymentDeclinedEventType, `{"ticket":"2"}`)),
+		},
+	}
+	app.Group = group
+
+	done := make(chan error)
+	go func() { done <- app.Run() }()
+
+	assert.Eventually(t, func() bool {
+		return service.attempts("2") == 1 && service.attempts("stuck") >= 3
+	}, time.Second, time.Millisecond)
+
+	app.Shutdown()
+	assert.NoError(t, <-done)
+
+	assert.Equal(t, 0, service.attempts("held back"))
+	assert.Equal(t, map[int32]int64{1: 2}, group.committed)
+	assert.True(t, group.closed)
+}
+
+// testConsumerEvents() applies a declined payment and a cancelled screening to a storage backend, along with events that must be skipped.
+func testConsumerEvents(t *testing.T, cfg utils.Config, log *logger.Logger, s bookservice.Querier) {
+	id := time.Now().UnixNano()
+	date := time.Date(2030, time.April, 16, 19, 0, 0, 0, time.UTC)
+	cinema := fmt.Sprintf("cinema-%d", id)
+
+	request := func(screen int32, seat int32) *bookrpc.BookRequest {
+		return &bookrpc.BookRequest{
+			Cinema:  &bookrpc.Cinema{Name: cinema, Location: "location"},
+			Movie:   &bookrpc.Movie{Title: "title"},
+			Session: &bookrpc.Session{Screen: screen, Seat: seat, Date: timestamppb.New(date)},
+		}
+	}
+	ticket := func(n int) string {
+		return fmt.Sprintf("%d-%d", id, n)
+	}
+
+	// Ticket 1 is paid for, then declined. Tickets 2 and 3 share the cancelled screening, ticket 4 is on another screen.
+	assert.NoError(t, s.Book(context.Background(), &storage.BookQuery{Ticket: ticket(1), Data: request(1, 1)}))
+	assert.NoError(t, s.Book(context.Background(), &storage.BookQuery{Ticket: ticket(2), Data: request(2, 1)}))
+	assert.NoError(t, s.Book(context.Background(), &storage.BookQuery{Ticket: ticket(3), Data: request(2, 2)}))
+	assert.NoError(t, s.Book(context.Background(), &storage.BookQuery{Ticket: ticket(4), Data: request(3, 1)}))
+
+	cancelled := &sarama.ConsumerMessage{
+		Headers: []*sarama.RecordHeader{{Key: []byte("ce_type"), Value: []byte(consumer.ScreeningCancelledEventType)}},
+		Value:   []byte(fmt.Sprintf(`{"cinema":%q,"location":"location","screen":2,"date":%q}`, cinema, date.Format(time.RFC3339))),
+	}
+
+	session := newFakeSession()
+	claim := newFakeClaim(
+		inbound(consumer.PaymentDeclinedEventType, fmt.Sprintf(`{"ticket":%q}`, ticket(1))),
+		cancelled,
+		// Redelivered events, unknown tickets, unknown types and malformed events are skipped.
+		inbound(consumer.PaymentDeclinedEventType, fmt.Sprintf(`{"ticket":%q}`, ticket(1))),
+		inbound(consumer.PaymentDeclinedEventType, `{"ticket":"unknown"}`),
+		inbound("payment.refunded", `{}`),
+		&sarama.ConsumerMessage{Value: []byte("{")},
+	)
+
+	handler := consumer.NewHandler(log, cfg, bookservice.New(cfg, log, s, nil, nil, nil))
+	assert.NoError(t, handler.ConsumeClaim(session, claim))
+
+	// Offsets are committed in the background, not once per event.
+	assert.Equal(t, []int64{0, 1, 2, 3, 4, 5}, session.marked)
+	assert.Equal(t, 0, session.commits)
+
+	statuses := map[string]string{
+		ticket(1): storage.StatusDeclined,
+		ticket(2): storage.StatusCancelled,
+		ticket(3): storage.StatusCancelled,
+		ticket(4): storage.StatusBooked,
+	}
+	for tkt, status := range statuses {
+		booking, err := s.GetBooking(context.Background(), &storage.GetBookingQuery{Ticket: tkt})
+		assert.NoError(t, err)
+		assert.Equal(t, status, booking.Status, tkt)
+	}
+
+	// The declined seat is free to book again.
+	assert.NoError(t, s.Book(context.Background(), &storage.BookQuery{Ticket: ticket(5), Data: request(1, 1)}))
+}
+
+// inbound() returns a message holding an inbound event in CloudEvents structured JSON.
+func inbound(eventType string, data string) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Value: []byte(fmt.Sprintf(`{"specversion":"1.0","type":%q,"data":%s}`, eventType, data)),
+	}
+}
+
+// flakyTransitioner{} fails to decline payments a given number of times before succeeding.
+type flakyTransitioner struct {
+	failures int
+	calls    int
+}
+
+func (f *flakyTransitioner) DeclinePayment(ctx context.Context, ticket string) error {
+	f.calls++
+	if f.calls <= f.failures {
+		return fmt.Errorf("database is locked")
+	}
+
+	return nil
+}
+
+func (f *flakyTransitioner) CancelScreening(ctx context.Context, screening *bookservice.Screening) (int, error) {
+	return 0, nil
+}
+
+// stuckTransitioner{} fails to decline the payment of one ticket forever, and counts the attempts for every ticket.
+type stuckTransitioner struct {
+	mu    sync.Mutex
+	stuck string
+	calls map[string]int
+}
+
+func (s *stuckTransitioner) DeclinePayment(ctx context.Context, ticket string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls[ticket]++
+	if ticket == s.stuck {
+		return fmt.Errorf("database is locked")
+	}
+
+	return nil
+}
+
+func (s *stuckTransitioner) CancelScreening(ctx context.Context, screening *bookservice.Screening) (int, error) {
+	return 0, nil
+}
+
+func (s *stuckTransitioner) attempts(ticket string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[ticket]
+}
+
+// fakeGroup{} implements sarama.ConsumerGroup with a single session over the given claims.
+//
+// Like Sarama with automatic commits, it commits the offsets marked during the session once the session ends.
+type fakeGroup struct {
+	claims    []*fakeClaim
+	committed map[int32]int64
+	closed    bool
+}
+
+func (f *fakeGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	session := &fakeSession{ctx: ctx}
+
+	err := handler.Setup(session)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, claim := range f.claims {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = handler.ConsumeClaim(session, claim)
+		}()
+	}
+	wg.Wait()
+
+	f.committed = session.offsets
+
+	return handler.Cleanup(session)
+}
+
+func (f *fakeGroup) Errors() <-chan error                 { return nil }
+func (f *fakeGroup) Close() error                         { f.closed = true; return nil }
+func (f *fakeGroup) Pause(partitions map[string][]int32)  {}
+func (f *fakeGroup) Resume(partitions map[string][]int32) {}
+func (f *fakeGroup) PauseAll()                            {}
+func (f *fakeGroup) ResumeAll()                           {}
+
+// fakeSession{} implements sarama.ConsumerGroupSession, recording the offsets marked, the next offset of every partition, and the commits.
+type fakeSession struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	marked  []int64
+	offsets map[int32]int64
+	commits int
+}
+
+func newFakeSession() *fakeSession {
+	return &fakeSession{ctx: context.Background()}
+}
+
+func (f *fakeSession) Claims() map[string][]int32 { return nil }
+func (f *fakeSession) MemberID() string           { return "member" }
+func (f *fakeSession) GenerationID() int32        { return 1 }
+func (f *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+}
+func (f *fakeSession) Commit() { f.commits++ }
+func (f *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+}
+func (f *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.offsets == nil {
+		f.offsets = map[int32]int64{}
+	}
+
+	f.marked = append(f.marked, msg.Offset)
+	f.offsets[msg.Partition] = msg.Offset + 1
+}
+func (f *fakeSession) Context() context.Context { return f.ctx }
+
+// fakeClaim{} implements sarama.ConsumerGroupClaim, delivering the given messages at consecutive offsets.
+type fakeClaim struct {
+	partition int32
+	messages  chan *sarama.ConsumerMessage
+}
+
+func newFakeClaim(msgs ...*sarama.ConsumerMessage) *fakeClaim {
+	messages := make(chan *sarama.ConsumerMessage, len(msgs))
+	for i, msg := range msgs {
+		msg.Topic = "payments"
+		msg.Offset = int64(i)
+		messages <- msg
+	}
+	close(messages)
+
+	return &fakeClaim{messages: messages}
+}
+
+// newFakePartition() returns a claim of the given partition delivering the given messages, which stays open like a partition nothing new is produced to.
+func newFakePartition(partition int32, msgs ...*sarama.ConsumerMessage) *fakeClaim {
+	messages := make(chan *sarama.ConsumerMessage, len(msgs))
+	for i, msg := range msgs {
+		msg.Topic = "payments"
+		msg.Partition = partition
+		msg.Offset = int64(i)
+		messages <- msg
+	}
+
+	return &fakeClaim{partition: partition, messages: messages}
+}
+
+func (f *fakeClaim) Topic() string                            { return "payments" }
+func (f *fakeClaim) Partition() int32                         { return f.partition }
+func (f *fakeClaim) InitialOffset() int64                     { return 0 }
+func (f *fakeClaim) HighWaterMarkOffset() int64               { return 0 }
+func (f *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return f.messages }
@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	broker "github.com/bookamovie/book/internal/broker/kafka"
+	eventsv1 "github.com/bookamovie/book/pkg/events/v1"
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestEnvelope_Unit() wraps events in envelopes, checking their CloudEvents JSON encoding in structured mode and protobuf one in binary mode, along with their Kafka headers.
+func TestEnvelope_Unit(t *testing.T) {
+	session := time.Date(2025, time.April, 16, 19, 0, 0, 0, time.UTC)
+	meta := broker.Meta{
+		ID:      "01JRX0000000000000000000AB",
+		Time:    time.Date(2025, time.April, 10, 12, 30, 0, 500, time.UTC),
+		TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+	}
+
+	booking := func(ticket string, seat int32) *broker.BookNotifyEvent {
+		return &broker.BookNotifyEvent{
+			Ticket: ticket,
+			Data: &bookrpc.BookRequest{
+				Cinema: &bookrpc.Cinema{Name: "Cinema", Location: "Street"},
+				Movie:  &bookrpc.Movie{Title: "Movie"},
+				Session: &bookrpc.Session{
+					Screen: 2,
+					Seat:   seat,
+					Date:   timestamppb.New(session),
+				},
+			},
+		}
+	}
+
+	env, err := broker.NewEnvelope("/bookamovie/book", broker.BookManyNotifyEventType, &broker.BookManyNotifyEvent{
+		Meta:     meta,
+		Bookings: []*broker.BookNotifyEvent{booking("1", 7), booking("2", 8)},
+	})
+	assert.NoError(t, err)
+
+	raw, err := env.Encode(broker.EncodingJSON)
+	assert.NoError(t, err)
+
+	var event struct {
+		SpecVersion     string    `json:"specversion"`
+		ID              string    `json:"id"`
+		Source          string    `json:"source"`
+		Type            string    `json:"type"`
+		Time            time.Time `json:"time"`
+		DataContentType string    `json:"datacontenttype"`
+		SchemaVersion   string    `json:"schemaversion"`
+		TraceID         string    `json:"traceid"`
+		Data            struct {
+			Bookings []struct {
+				Ticket  string    `json:"ticket"`
+				Cinema  string    `json:"cinema"`
+				Seat    int32     `json:"seat"`
+				Session time.Time `json:"session"`
+			} `json:"bookings"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(raw, &event))
+
+	assert.Equal(t, broker.SpecVersion, event.SpecVersion)
+	assert.Equal(t, meta.ID, event.ID)
+	assert.Equal(t, "/bookamovie/book", event.Source)
+	assert.Equal(t, broker.BookManyNotifyEventType, event.Type)
+	assert.True(t, meta.Time.Equal(event.Time))
+	assert.Equal(t, "application/json", event.DataContentType)
+	assert.Equal(t, broker.SchemaVersion, event.SchemaVersion)
+	assert.Equal(t, meta.TraceID, event.TraceID)
+	assert.Len(t, event.Data.Bookings, 2)
+	assert.Equal(t, "2", event.Data.Bookings[1].Ticket)
+	assert.Equal(t, "Cinema", event.Data.Bookings[1].Cinema)
+	assert.Equal(t, int32(8), event.Data.Bookings[1].Seat)
+	assert.True(t, session.Equal(event.Data.Bookings[1].Session))
+
+	// The whole envelope is the generated Envelope message.
+	var decoded eventsv1.Envelope
+	assert.NoError(t, protojson.Unmarshal(raw, &decoded))
+	assert.Equal(t, meta.ID, decoded.GetId())
+	assert.Equal(t, "Street", decoded.GetData().GetBookings()[0].GetLocation())
+
+	// Structured mode keeps the attributes in the value only.
+	headers := map[string]string{}
+	for _, h := range env.Headers(broker.EncodingJSON) {
+		headers[string(h.Key)] = string(h.Value)
+	}
+
+	assert.Equal(t, map[string]string{
+		"event":        broker.BookManyNotifyEventType,
+		"content-type": "application/cloudevents+json",
+	}, headers)
+
+	// Binary mode puts the data alone in the value, and the attributes in headers.
+	raw, err = env.Encode(broker.EncodingProto)
+	assert.NoError(t, err)
+
+	var data eventsv1.Data
+	assert.NoError(t, proto.Unmarshal(raw, &data))
+	assert.Len(t, data.GetBookings(), 2)
+	assert.Equal(t, "1", data.GetBookings()[0].GetTicket())
+	assert.Equal(t, int32(8), data.GetBookings()[1].GetSeat())
+	assert.True(t, session.Equal(data.GetBookings()[1].GetSession().AsTime()))
+
+	headers = map[string]string{}
+	for _, h := range env.Headers(broker.EncodingProto) {
+		headers[string(h.Key)] = string(h.Value)
+	}
+
+	assert.Equal(t, map[string]string{
+		"event":            broker.BookManyNotifyEventType,
+		"content-type":     "application/protobuf; messageType=bookamovie.book.events.v1.Data",
+		"ce_specversion":   broker.SpecVersion,
+		"ce_id":            meta.ID,
+		"ce_source":        "/bookamovie/book",
+		"ce_type":          broker.BookManyNotifyEventType,
+		"ce_time":          "2025-04-10T12:30:00.0000005Z",
+		"ce_schemaversion": broker.SchemaVersion,
+		"ce_traceid":       meta.TraceID,
+	}, headers)
+
+	// Events stored before they had a Meta get one when published.
+	env, err = broker.NewEnvelope("/bookamovie/book", broker.BookCancelEventType, &broker.BookCancelEvent{Ticket: "1"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, env.ID)
+	assert.Len(t, env.TraceID, 32)
+	assert.Len(t, env.Bookings, 1)
+
+	_, err = env.Encode("xml")
+	assert.ErrorIs(t, err, broker.ErrUnknownEncoding)
+
+	_, err = broker.NewEnvelope("/bookamovie/book", "book.unknown", "event")
+	assert.ErrorIs(t, err, broker.ErrUnknownEvent)
+}
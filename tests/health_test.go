@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	bookapp "github.com/bookamovie/book/internal/app/book"
+	"github.com/bookamovie/book/internal/broker/jsonl"
+	broker "github.com/bookamovie/book/internal/broker/kafka"
+	"github.com/bookamovie/book/internal/lib/logger"
+	bookservice "github.com/bookamovie/book/internal/services/book"
+	"github.com/bookamovie/book/internal/storage"
+	"github.com/bookamovie/book/internal/storage/sqlite"
+	"github.com/bookamovie/book/internal/utils"
+	"github.com/stretchr/testify/assert"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// TestHealth_Unit() checks that the health service follows the state of the storage and the broker, and that Shutdown() reports the service as NOT_SERVING for good.
+func TestHealth_Unit(t *testing.T) {
+	var cfg utils.Config
+	cfg.SQLiteConfig.Address = "storage/db.sqlite"
+	cfg.FileConfig.Path = filepath.Join(t.TempDir(), "events.jsonl")
+	cfg.BookConfig.HealthInterval = time.Second
+
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			BookLog:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+			StorageLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			BrokerLog:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	}
+
+	s, err := sqlite.New(cfg, log)
+	assert.NoError(t, err)
+
+	br, err := jsonl.New(cfg, log)
+	assert.NoError(t, err)
+
+	app := bookapp.New(log, cfg, s, br, &bookservice.LuhnGenerator{Length: 8}, nil)
+
+	// Nothing is served before the dependencies are checked.
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, serving(t, app, ""))
+
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, app.Check())
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, serving(t, app, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, serving(t, app, "book.Book"))
+
+	// A broker that can't be used anymore makes the service unusable.
+	br.Shutdown()
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, app.Check())
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, serving(t, app, "book.Book"))
+
+	// So does an unreachable database.
+	app = bookapp.New(log, cfg, s, &broker.UnimplementedBroker{}, &bookservice.LuhnGenerator{Length: 8}, nil)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, app.Check())
+
+	s.Shutdown()
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, app.Check())
+
+	// Once shut down, the service stays NOT_SERVING whatever its dependencies.
+	app = bookapp.New(log, cfg, &storage.UnimplementedStorage{}, &broker.UnimplementedBroker{}, &bookservice.LuhnGenerator{Length: 8}, nil)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, app.Check())
+
+	app.Shutdown()
+	app.Check()
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, serving(t, app, ""))
+}
+
+// TestKafkaPing_Unit() pings a Kafka cluster through the producer's client, while its broker is up, once it is down, and once the producer is shut down.
+func TestKafkaPing_Unit(t *testing.T) {
+	mockBroker := sarama.NewMockBroker(t, 1)
+	mockBroker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(mockBroker.Addr(), mockBroker.BrokerID()).
+			SetLeader("notifications", 0, mockBroker.BrokerID()),
+	})
+
+	var cfg utils.Config
+	cfg.EventsConfig.Encoding = broker.EncodingJSON
+	cfg.KafkaConfig = utils.KafkaConfig{
+		Addresses:   []string{mockBroker.Addr()},
+		Topic:       "notifications",
+		Mode:        broker.ModeSync,
+		Key:         broker.KeySession,
+		Compression: "none",
+		Partitioner: broker.PartitionerHash,
+		ClientID:    "book",
+	}
+
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			BrokerLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	}
+
+	br, err := broker.New(cfg, log)
+	assert.NoError(t, err)
+	assert.NoError(t, br.Ping(context.Background()))
+
+	mockBroker.Close()
+	assert.ErrorIs(t, br.Ping(context.Background()), broker.ErrUnavailable)
+
+	br.Shutdown()
+	assert.ErrorIs(t, br.Ping(context.Background()), broker.ErrUnavailable)
+}
+
+// serving() returns the status the health service reports for a service.
+func serving(t *testing.T, app *bookapp.App, service string) healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := app.Health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	assert.NoError(t, err)
+
+	return resp.GetStatus()
+}
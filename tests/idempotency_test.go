@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	bookservice "github.com/bookamovie/book/internal/services/book"
+	"github.com/bookamovie/book/internal/storage/memory"
+	"github.com/bookamovie/book/internal/storage/sqlite"
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestIdempotencyLease_Unit() reserves idempotency keys for requests that outlive their lease, checking that a retry takes the key over, that the stale request can then neither save a result nor release the key, and that saved results are kept for the whole window.
+func TestIdempotencyLease_Unit(t *testing.T) {
+	const lease = 50 * time.Millisecond
+
+	cfg, log := outboxConfig()
+	cfg.SQLiteConfig.Address = "storage/db.sqlite"
+	cfg.IdempotencyConfig.Window = time.Hour
+	cfg.IdempotencyConfig.Lease = lease
+
+	sqliteStorage, err := sqlite.New(cfg, log)
+	assert.NoError(t, err)
+	defer sqliteStorage.Shutdown()
+
+	backends := map[string]bookservice.Querier{
+		"sqlite": sqliteStorage,
+		"memory": memory.New(cfg, log),
+	}
+
+	req := &bookrpc.BookRequest{
+		Cinema:  &bookrpc.Cinema{Name: "Cinema", Location: "Street"},
+		Movie:   &bookrpc.Movie{Title: "Movie"},
+		Session: &bookrpc.Session{Screen: 1, Seat: 1, Date: timestamppb.New(time.Date(2030, time.April, 16, 19, 0, 0, 0, time.UTC))},
+	}
+
+	for name, s := range backends {
+		t.Run(name, func(t *testing.T) {
+			service := bookservice.New(cfg, log, s, nil, nil, nil)
+
+			// A deadline shorter than the lease doesn't shorten it.
+			key := fmt.Sprintf("deadline-%d", time.Now().UnixNano())
+
+			ctx, cancel := context.WithTimeout(context.Background(), lease/5)
+			defer cancel()
+
+			result, stale, err := service.ReserveIdempotencyKey(ctx, key, req)
+			assert.NoError(t, err)
+			assert.Nil(t, result)
+			assert.NotEmpty(t, stale)
+
+			<-ctx.Done()
+
+			_, _, err = service.ReserveIdempotencyKey(context.Background(), key, req)
+			assert.ErrorIs(t, err, bookservice.ErrKeyInProgress)
+
+			// Once the lease ends, the retry takes the key over.
+			time.Sleep(lease)
+
+			result, token, err := service.ReserveIdempotencyKey(context.Background(), key, req)
+			assert.NoError(t, err)
+			assert.Nil(t, result)
+			assert.NotEqual(t, stale, token)
+
+			// The stale request can't touch the reservation of the retry.
+			assert.NoError(t, service.SaveIdempotencyKey(context.Background(), key, stale, &bookservice.IdempotentResult{Ticket: "stale"}))
+			assert.NoError(t, service.ReleaseIdempotencyKey(context.Background(), key, stale))
+
+			_, _, err = service.ReserveIdempotencyKey(context.Background(), key, req)
+			assert.ErrorIs(t, err, bookservice.ErrKeyInProgress)
+
+			// The result of the retry outlives the lease.
+			assert.NoError(t, service.SaveIdempotencyKey(context.Background(), key, token, &bookservice.IdempotentResult{Ticket: "1"}))
+
+			time.Sleep(2 * lease)
+
+			result, _, err = service.ReserveIdempotencyKey(context.Background(), key, req)
+			assert.NoError(t, err)
+			if assert.NotNil(t, result) {
+				assert.Equal(t, "1", result.Ticket)
+			}
+
+			// Released keys are processed again.
+			key = fmt.Sprintf("release-%d", time.Now().UnixNano())
+
+			_, token, err = service.ReserveIdempotencyKey(context.Background(), key, req)
+			assert.NoError(t, err)
+			assert.NoError(t, service.ReleaseIdempotencyKey(context.Background(), key, token))
+
+			result, _, err = service.ReserveIdempotencyKey(context.Background(), key, req)
+			assert.NoError(t, err)
+			assert.Nil(t, result)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama/mocks"
+	bookapp "github.com/bookamovie/book/internal/app/book"
+	broker "github.com/bookamovie/book/internal/broker/kafka"
+	"github.com/bookamovie/book/internal/lib/logger"
+	bookservice "github.com/bookamovie/book/internal/services/book"
+	"github.com/bookamovie/book/internal/storage"
+	"github.com/bookamovie/book/internal/storage/memory"
+	"github.com/bookamovie/book/internal/utils"
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestInterceptors_Unit() runs calls through the unary interceptors, checking request IDs, panic recovery, and that the request ID reaches the storage and broker logs.
+func TestInterceptors_Unit(t *testing.T) {
+	var bookLogs, storageLogs, brokerLogs bytes.Buffer
+
+	handler := func(w *bytes.Buffer) *slog.Logger {
+		return slog.New(logger.NewContextHandler(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	}
+
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			BookLog:    handler(&bookLogs),
+			StorageLog: handler(&storageLogs),
+			BrokerLog:  handler(&brokerLogs),
+		},
+	}
+
+	var cfg utils.Config
+	cfg.EventsConfig.Encoding = broker.EncodingJSON
+	cfg.OutboxConfig.BatchSize = 10
+	cfg.BookConfig.Seats = 10
+
+	s := memory.New(cfg, log)
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+
+	service := bookservice.New(cfg, log, s, broker.NewSync(cfg, log, producer), &bookservice.LuhnGenerator{Length: 8}, nil)
+
+	// The request ID sent by the client is kept, sent back, and carried by the storage and broker logs.
+	ctx, headers := incoming(metadata.Pairs(bookapp.RequestIDHeader, "client-id-1"))
+
+	_, err := unary(ctx, log, func(ctx context.Context, req any) (any, error) {
+		assert.Equal(t, "client-id-1", logger.RequestID(ctx))
+
+		_, err := s.GetBooking(ctx, &storage.GetBookingQuery{Ticket: "unknown"})
+		assert.Error(t, err)
+
+		return service.Book(ctx, &bookrpc.BookRequest{
+			Cinema:  &bookrpc.Cinema{Name: "Cinema", Location: "Street"},
+			Movie:   &bookrpc.Movie{Title: "Movie"},
+			Session: &bookrpc.Session{Screen: 1, Seat: 1, Date: timestamppb.New(time.Date(2030, time.April, 16, 19, 0, 0, 0, time.UTC))},
+		})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"client-id-1"}, headers.Get(bookapp.RequestIDHeader))
+	assert.Equal(t, "client-id-1", logged(t, &storageLogs, "booking not found")["request_id"])
+
+	call := logged(t, &bookLogs, "handled a call")
+	assert.Equal(t, "client-id-1", call["request_id"])
+	assert.Equal(t, "/book.Book/Book", call["method"])
+	assert.Equal(t, codes.OK.String(), call["code"])
+	assert.Equal(t, slog.LevelInfo.String(), call["level"])
+
+	// The relay runs outside of any request, and still logs the event with the request it comes from.
+	published, err := service.RelayOutbox(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, published)
+	assert.Equal(t, "client-id-1", logged(t, &brokerLogs, "message produced")["request_id"])
+
+	// Missing and invalid request IDs are replaced with new ones.
+	for _, md := range []metadata.MD{nil, metadata.Pairs(bookapp.RequestIDHeader, "with space"), metadata.Pairs(bookapp.RequestIDHeader, strings.Repeat("a", 129))} {
+		ctx, headers = incoming(md)
+
+		var id string
+		_, err = unary(ctx, log, func(ctx context.Context, req any) (any, error) {
+			id = logger.RequestID(ctx)
+
+			return nil, nil
+		})
+		assert.NoError(t, err)
+		assert.Len(t, id, 26)
+		assert.Equal(t, []string{id}, headers.Get(bookapp.RequestIDHeader))
+	}
+
+	// Panics end the call with Internal, logged as errors.
+	bookLogs.Reset()
+	ctx, _ = incoming(metadata.Pairs(bookapp.RequestIDHeader, "client-id-2"))
+
+	_, err = unary(ctx, log, func(ctx context.Context, req any) (any, error) {
+		panic("boom")
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	recovered := logged(t, &bookLogs, "recovered from a panic")
+	assert.Equal(t, "boom", recovered["panic"])
+	assert.Equal(t, "client-id-2", recovered["request_id"])
+	assert.Contains(t, recovered["stack"], "interceptor_test.go")
+
+	call = logged(t, &bookLogs, "handled a call")
+	assert.Equal(t, codes.Internal.String(), call["code"])
+	assert.Equal(t, slog.LevelError.String(), call["level"])
+}
+
+// unary() runs a unary call to Book through the interceptors, outermost first, as the server chains them.
+func unary(ctx context.Context, log *logger.Logger, handler grpc.UnaryHandler) (any, error) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/book.Book/Book"}
+	interceptors := bookapp.UnaryInterceptors(log)
+
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, req any) (any, error) {
+			return interceptor(ctx, req, info, next)
+		}
+	}
+
+	return handler(ctx, nil)
+}
+
+// incoming() returns the context of a call with the given incoming metadata, and the headers the server sets on it.
+func incoming(md metadata.MD) (context.Context, metadata.MD) {
+	stream := &fakeTransportStream{header: metadata.MD{}}
+	ctx := grpc.NewContextWithServerTransportStream(metadata.NewIncomingContext(context.Background(), md), stream)
+
+	return ctx, stream.header
+}
+
+// logged() returns the last record logged with the given message.
+func logged(t *testing.T, logs *bytes.Buffer, msg string) map[string]any {
+	var last map[string]any
+
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+
+		if record["msg"] == msg {
+			last = record
+		}
+	}
+
+	assert.NotNil(t, last, msg)
+
+	return last
+}
+
+// fakeTransportStream{} implements grpc.ServerTransportStream, recording the headers set.
+type fakeTransportStream struct {
+	header metadata.MD
+}
+
+func (f *fakeTransportStream) Method() string { return "/book.Book/Book" }
+func (f *fakeTransportStream) SetHeader(md metadata.MD) error {
+	for k, v := range md {
+		f.header[k] = append(f.header[k], v...)
+	}
+
+	return nil
+}
+func (f *fakeTransportStream) SendHeader(md metadata.MD) error { return f.SetHeader(md) }
+func (f *fakeTransportStream) SetTrailer(md metadata.MD) error { return nil }
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bookamovie/book/internal/broker/jsonl"
+	broker "github.com/bookamovie/book/internal/broker/kafka"
+	"github.com/bookamovie/book/internal/lib/logger"
+	"github.com/bookamovie/book/internal/utils"
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestJSONL_Unit() appends events to a JSON lines file, checking its lines and its rotation by size and by date.
+func TestJSONL_Unit(t *testing.T) {
+	dir := t.TempDir()
+
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			BrokerLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	}
+
+	var cfg utils.Config
+	cfg.EventsConfig.Source = "/bookamovie/book"
+	cfg.FileConfig = utils.FileConfig{
+		Path:    filepath.Join(dir, "events", "book.jsonl"),
+		MaxSize: 1 << 20,
+		Daily:   true,
+	}
+
+	br, err := jsonl.New(cfg, log)
+	assert.NoError(t, err)
+
+	booking := func(ticket string, seat int32) *broker.BookNotifyEvent {
+		return &broker.BookNotifyEvent{
+			Ticket: ticket,
+			Data: &bookrpc.BookRequest{
+				Cinema:  &bookrpc.Cinema{Name: "Cinema", Location: "Street"},
+				Movie:   &bookrpc.Movie{Title: "Movie"},
+				Session: &bookrpc.Session{Screen: 2, Seat: seat, Date: timestamppb.New(time.Date(2030, time.April, 16, 19, 0, 0, 0, time.UTC))},
+			},
+		}
+	}
+
+	assert.NoError(t, br.BookNotify(context.Background(), booking("1", 7)))
+	assert.NoError(t, br.BookCancelNotify(context.Background(), &broker.BookCancelEvent{Ticket: "1"}))
+	assert.NoError(t, br.BookManyNotify(context.Background(), &broker.BookManyNotifyEvent{
+		Meta:     broker.NewMeta(),
+		Bookings: []*broker.BookNotifyEvent{booking("2", 8), booking("3", 9)},
+	}))
+	br.Shutdown()
+
+	// Every line is a flat object, and events booking several seats take a line per seat.
+	lines := readLines(t, cfg.FileConfig.Path)
+	assert.Len(t, lines, 4)
+	assert.Equal(t, broker.BookNotifyEventType, lines[0]["type"])
+	assert.Equal(t, "1", lines[0]["ticket"])
+	assert.Equal(t, "Cinema", lines[0]["cinema"])
+	assert.Equal(t, "Street", lines[0]["location"])
+	assert.Equal(t, "Movie", lines[0]["movie"])
+	assert.Equal(t, float64(2), lines[0]["screen"])
+	assert.Equal(t, float64(7), lines[0]["seat"])
+	assert.Equal(t, "2030-04-16T19:00:00Z", lines[0]["session"])
+	assert.NotEmpty(t, lines[0]["event_id"])
+	assert.Equal(t, broker.BookCancelEventType, lines[1]["type"])
+	assert.Equal(t, "1", lines[1]["ticket"])
+	assert.Equal(t, broker.BookManyNotifyEventType, lines[2]["type"])
+	assert.Equal(t, "2", lines[2]["ticket"])
+	assert.Equal(t, "3", lines[3]["ticket"])
+	assert.Equal(t, lines[2]["event_id"], lines[3]["event_id"])
+
+	for _, line := range lines {
+		assert.NotContains(t, line, "data")
+	}
+
+	// A file last written yesterday is rotated by the first event of today.
+	yesterday := time.Now().Add(-24 * time.Hour)
+	assert.NoError(t, os.Chtimes(cfg.FileConfig.Path, yesterday, yesterday))
+
+	br, err = jsonl.New(cfg, log)
+	assert.NoError(t, err)
+
+	assert.NoError(t, br.BookNotify(context.Background(), &broker.BookNotifyEvent{Ticket: "2"}))
+	br.Shutdown()
+
+	assert.Len(t, readLines(t, cfg.FileConfig.Path), 1)
+
+	rotated, err := filepath.Glob(filepath.Join(dir, "events", "book-*.jsonl"))
+	assert.NoError(t, err)
+	assert.Len(t, rotated, 1)
+
+	// Every event past the maximum size starts a new file.
+	cfg.FileConfig.MaxSize = 1
+
+	br, err = jsonl.New(cfg, log)
+	assert.NoError(t, err)
+
+	assert.NoError(t, br.BookNotify(context.Background(), &broker.BookNotifyEvent{Ticket: "3"}))
+	assert.NoError(t, br.BookNotify(context.Background(), &broker.BookNotifyEvent{Ticket: "4"}))
+	br.Shutdown()
+
+	rotated, err = filepath.Glob(filepath.Join(dir, "events", "book-*.jsonl"))
+	assert.NoError(t, err)
+	assert.Len(t, rotated, 3)
+	assert.Len(t, readLines(t, cfg.FileConfig.Path), 1)
+}
+
+// readLines() decodes every line of a JSON lines file.
+func readLines(t *testing.T, path string) []map[string]any {
+	t.Helper()
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	var lines []map[string]any
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line map[string]any
+
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+
+	return lines
+}
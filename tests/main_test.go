@@ -0,0 +1,25 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+// TestMain() migrates the test database up before running the tests, so its schema always comes from the test migrations.
+func TestMain(m *testing.M) {
+	mig, err := migrate.New("file://migrations/sqlite", "sqlite3://storage/db.sqlite")
+	if err != nil {
+		panic(err)
+	}
+
+	err = mig.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		panic(err)
+	}
+	mig.Close()
+
+	os.Exit(m.Run())
+}
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama/mocks"
+	bookapp "github.com/bookamovie/book/internal/app/book"
+	broker "github.com/bookamovie/book/internal/broker/kafka"
+	"github.com/bookamovie/book/internal/lib/logger"
+	"github.com/bookamovie/book/internal/lib/metrics"
+	bookservice "github.com/bookamovie/book/internal/services/book"
+	"github.com/bookamovie/book/internal/storage/memory"
+	"github.com/bookamovie/book/internal/storage/sqlite"
+	"github.com/bookamovie/book/internal/utils"
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestMetrics_Unit() checks that bookings are counted by outcome and timed, that Kafka produce errors are counted, and that the database pool and Go runtime stats are exposed.
+func TestMetrics_Unit(t *testing.T) {
+	var cfg utils.Config
+	cfg.SQLiteConfig.Address = "storage/db.sqlite"
+	cfg.EventsConfig.Encoding = broker.EncodingJSON
+	cfg.BookConfig.Seats = 10
+
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			BookLog:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+			StorageLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			BrokerLog:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	}
+
+	api := &bookapp.Api{Service: bookservice.New(cfg, log, memory.New(cfg, log), &broker.UnimplementedBroker{}, &bookservice.LuhnGenerator{Length: 8}, nil)}
+
+	req := &bookrpc.BookRequest{
+		Cinema:  &bookrpc.Cinema{Name: "Cinema", Location: "Street"},
+		Movie:   &bookrpc.Movie{Title: "Movie"},
+		Session: &bookrpc.Session{Screen: 1, Seat: 1, Date: timestamppb.New(time.Date(2030, time.April, 16, 19, 0, 0, 0, time.UTC))},
+	}
+
+	before := map[string]float64{}
+	for _, outcome := range []string{metrics.OutcomeOK, metrics.OutcomeDuplicate, metrics.OutcomeInvalid, metrics.OutcomeInternal} {
+		before[outcome] = gathered(t, "book_bookings_total", "outcome", outcome)
+	}
+	apiCalls := gathered(t, "book_operation_duration_seconds", "operation", metrics.OperationAPIBook)
+	storageCalls := gathered(t, "book_operation_duration_seconds", "operation", metrics.OperationStorageBook)
+
+	// A booking, the same seat again, and a request missing its session.
+	_, err := api.Book(context.Background(), req)
+	assert.NoError(t, err)
+
+	_, err = api.Book(context.Background(), req)
+	assert.Error(t, err)
+
+	_, err = api.Book(context.Background(), &bookrpc.BookRequest{Cinema: req.Cinema, Movie: req.Movie})
+	assert.Error(t, err)
+
+	assert.Equal(t, before[metrics.OutcomeOK]+1, gathered(t, "book_bookings_total", "outcome", metrics.OutcomeOK))
+	assert.Equal(t, before[metrics.OutcomeDuplicate]+1, gathered(t, "book_bookings_total", "outcome", metrics.OutcomeDuplicate))
+	assert.Equal(t, before[metrics.OutcomeInvalid]+1, gathered(t, "book_bookings_total", "outcome", metrics.OutcomeInvalid))
+	assert.Equal(t, before[metrics.OutcomeInternal], gathered(t, "book_bookings_total", "outcome", metrics.OutcomeInternal))
+
+	// Every call is timed, while only the valid ones reach the storage.
+	assert.Equal(t, apiCalls+3, gathered(t, "book_operation_duration_seconds", "operation", metrics.OperationAPIBook))
+	assert.Equal(t, storageCalls+2, gathered(t, "book_operation_duration_seconds", "operation", metrics.OperationStorageBook))
+
+	// BookMany calls count once per requested seat, all of them booked or none.
+	for _, outcome := range []string{metrics.OutcomeOK, metrics.OutcomeDuplicate} {
+		before[outcome] = gathered(t, "book_bookings_total", "outcome", outcome)
+	}
+	apiManyCalls := gathered(t, "book_operation_duration_seconds", "operation", metrics.OperationAPIBookMany)
+	storageManyCalls := gathered(t, "book_operation_duration_seconds", "operation", metrics.OperationStorageBookMany)
+
+	many := &bookrpc.BookManyRequest{Cinema: req.Cinema, Movie: req.Movie, Screen: 1, Seats: []int32{2, 3}, Date: req.Session.Date}
+
+	_, err = api.BookMany(context.Background(), many)
+	assert.NoError(t, err)
+
+	_, err = api.BookMany(context.Background(), many)
+	assert.Error(t, err)
+
+	assert.Equal(t, before[metrics.OutcomeOK]+2, gathered(t, "book_bookings_total", "outcome", metrics.OutcomeOK))
+	assert.Equal(t, before[metrics.OutcomeDuplicate]+2, gathered(t, "book_bookings_total", "outcome", metrics.OutcomeDuplicate))
+	assert.Equal(t, apiManyCalls+2, gathered(t, "book_operation_duration_seconds", "operation", metrics.OperationAPIBookMany))
+	assert.Equal(t, storageManyCalls+2, gathered(t, "book_operation_duration_seconds", "operation", metrics.OperationStorageBookMany))
+
+	// Messages Kafka refuses are counted.
+	produceErrors := gathered(t, "book_kafka_produce_errors_total")
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndFail(errors.New("kafka is down"))
+
+	br := broker.NewSync(cfg, log, producer)
+	assert.Error(t, br.BookNotify(context.Background(), &broker.BookNotifyEvent{Ticket: "1"}))
+	assert.Equal(t, produceErrors+1, gathered(t, "book_kafka_produce_errors_total"))
+
+	// The pool stats of a database are exposed under its name, which can't be taken twice.
+	s, err := sqlite.New(cfg, log)
+	assert.NoError(t, err)
+	defer s.Shutdown()
+
+	name := fmt.Sprintf("sqlite-%d", time.Now().UnixNano())
+	assert.NoError(t, metrics.RegisterDB(name, s.DB))
+	assert.Error(t, metrics.RegisterDB(name, s.DB))
+	assert.Equal(t, float64(s.DB.Stats().MaxOpenConnections), gathered(t, "go_sql_max_open_connections", "db_name", name))
+
+	// The handler serves all of it, along with the Go runtime metrics.
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	for _, name := range []string{"book_bookings_total", "book_operation_duration_seconds_bucket", "book_kafka_produce_errors_total", "go_sql_open_connections", "go_goroutines"} {
+		assert.Contains(t, rec.Body.String(), name)
+	}
+}
+
+// gathered() returns the value of a counter or gauge, or the sample count of a histogram, with the given label pair if any.
+func gathered(t *testing.T, name string, label ...string) float64 {
+	families, err := metrics.Registry.Gather()
+	assert.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+	metric:
+		for _, m := range family.GetMetric() {
+			if len(label) == 2 {
+				for _, pair := range m.GetLabel() {
+					if pair.GetName() == label[0] && pair.GetValue() != label[1] {
+						continue metric
+					}
+				}
+			}
+
+			switch {
+			case m.GetCounter() != nil:
+				return m.GetCounter().GetValue()
+
+			case m.GetGauge() != nil:
+				return m.GetGauge().GetValue()
+
+			case m.GetHistogram() != nil:
+				return float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	t.Fatalf("metric %s%v not found", name, label)
+
+	return 0
+}
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS bookings_date_idx;DROP INDEX IF EXISTS bookings_movie_date_idx;DROP INDEX IF EXISTS bookings_screen_date_idx;DROP INDEX IF EXISTS bookings_cinema_date_idx;
\ No newline at end of file
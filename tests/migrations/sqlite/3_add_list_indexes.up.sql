@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS bookings_cinema_date_idx ON bookings (cinema, location, date, id);CREATE INDEX IF NOT EXISTS bookings_screen_date_idx ON bookings (cinema, location, screen, date, id);CREATE INDEX IF NOT EXISTS bookings_movie_date_idx ON bookings (movie, date, id);CREATE INDEX IF NOT EXISTS bookings_date_idx ON bookings (date, id);
\ No newline at end of file
@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS outbox_deliveries (outbox_id INTEGER NOT NULL REFERENCES outbox (id) ON DELETE CASCADE, sink TEXT NOT NULL, delivered_at TEXT NOT NULL, PRIMARY KEY (outbox_id, sink));
\ No newline at end of file
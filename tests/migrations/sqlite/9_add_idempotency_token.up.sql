@@ -0,0 +1 @@
+ALTER TABLE idempotency_keys ADD COLUMN token TEXT NOT NULL DEFAULT '';
\ No newline at end of file
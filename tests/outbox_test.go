@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/bookamovie/book/internal/broker/fanout"
+	broker "github.com/bookamovie/book/internal/broker/kafka"
+	"github.com/bookamovie/book/internal/lib/logger"
+	bookservice "github.com/bookamovie/book/internal/services/book"
+	"github.com/bookamovie/book/internal/storage"
+	"github.com/bookamovie/book/internal/storage/memory"
+	"github.com/bookamovie/book/internal/utils"
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestOutboxSinks_Unit() relays the outbox to two sinks, one of them down, checking that it holds back neither the other sink nor the events the other sink already got.
+func TestOutboxSinks_Unit(t *testing.T) {
+	cfg, log := outboxConfig()
+
+	s := memory.New(cfg, log)
+	writeOutbox(t, s, "1", "2", "3")
+
+	kafka, webhook := &recordingBroker{}, &recordingBroker{err: fmt.Errorf("partner is down")}
+	service := bookservice.New(cfg, log, s, fanout.New(
+		bookservice.Sink{Name: "kafka", Broker: kafka},
+		bookservice.Sink{Name: "webhook", Broker: webhook},
+	), nil, nil)
+
+	published, err := service.RelayOutbox(context.Background())
+	assert.ErrorContains(t, err, "webhook: partner is down")
+	assert.Equal(t, 3, published)
+	assert.Equal(t, []string{"1", "2", "3"}, kafka.tickets)
+	assert.Empty(t, webhook.tickets)
+
+	// Kafka is done, while the webhook still has everything to deliver.
+	published, err = service.RelayOutbox(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 0, published)
+	assert.Equal(t, []string{"1", "2", "3"}, kafka.tickets)
+
+	webhook.err = nil
+
+	published, err = service.RelayOutbox(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, published)
+	assert.Equal(t, []string{"1", "2", "3"}, kafka.tickets)
+	assert.Equal(t, []string{"1", "2", "3"}, webhook.tickets)
+
+	pending, err := s.PendingOutbox(context.Background(), &storage.PendingOutboxQuery{Limit: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+// TestOutboxRelay_Unit() relays the outbox through a broker failing on one event, checking that events go out oldest first, that the relay stops at the failed one, and that the events it held back go out once the broker recovers.
+func TestOutboxRelay_Unit(t *testing.T) {
+	cfg, log := outboxConfig()
+
+	s := memory.New(cfg, log)
+	writeOutbox(t, s, "1", "2", "3", "4", "5")
+
+	br := &recordingBroker{err: fmt.Errorf("broker is down"), failing: "3"}
+	service := bookservice.New(cfg, log, s, br, nil, nil)
+
+	published, err := service.RelayOutbox(context.Background())
+	assert.ErrorContains(t, err, "broker is down")
+	assert.Equal(t, 2, published)
+	assert.Equal(t, []string{"1", "2"}, br.tickets)
+
+	// The failed event and the ones after it stay pending, in order, with the failure counted.
+	pending, err := s.PendingOutbox(context.Background(), &storage.PendingOutboxQuery{Sink: bookservice.DefaultSink, Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3", "4", "5"}, outboxTickets(t, pending))
+	assert.Equal(t, []int{1, 0, 0}, []int{pending[0].Attempts, pending[1].Attempts, pending[2].Attempts})
+
+	// Nothing after the failed event is published while it keeps failing.
+	published, err = service.RelayOutbox(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 0, published)
+	assert.Equal(t, []string{"1", "2"}, br.tickets)
+
+	br.err = nil
+
+	published, err = service.RelayOutbox(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, published)
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, br.tickets)
+
+	pending, err = s.PendingOutbox(context.Background(), &storage.PendingOutboxQuery{Sink: bookservice.DefaultSink, Limit: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+// outboxConfig() returns the config and the silent logger of the outbox tests.
+func outboxConfig() (utils.Config, *logger.Logger) {
+	var cfg utils.Config
+	cfg.OutboxConfig.BatchSize = 10
+
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			BookLog:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+			StorageLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			BrokerLog:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	}
+
+	return cfg, log
+}
+
+// writeOutbox() books a seat for every ticket, along with its BookNotifyEvent, in order.
+func writeOutbox(t *testing.T, s bookservice.Querier, tickets ...string) {
+	for i, ticket := range tickets {
+		payload, err := json.Marshal(&broker.BookNotifyEvent{Ticket: ticket})
+		assert.NoError(t, err)
+
+		err = s.Book(context.Background(), &storage.BookQuery{
+			Ticket: ticket,
+			Data: &bookrpc.BookRequest{
+				Cinema:  &bookrpc.Cinema{Name: "Cinema", Location: "Street"},
+				Movie:   &bookrpc.Movie{Title: "Movie"},
+				Session: &bookrpc.Session{Screen: 1, Seat: int32(i + 1), Date: timestamppb.New(time.Date(2030, time.April, 16, 19, 0, 0, 0, time.UTC))},
+			},
+			Outbox: &storage.OutboxMessage{Type: broker.BookNotifyEventType, Payload: payload},
+		})
+		assert.NoError(t, err)
+	}
+}
+
+// outboxTickets() returns the tickets of BookNotifyEvent outbox messages.
+func outboxTickets(t *testing.T, msgs []*storage.OutboxMessage) []string {
+	tickets := make([]string, 0, len(msgs))
+
+	for _, msg := range msgs {
+		var event broker.BookNotifyEvent
+
+		err := json.Unmarshal(msg.Payload, &event)
+		assert.NoError(t, err)
+
+		tickets = append(tickets, event.Ticket)
+	}
+
+	return tickets
+}
+
+// recordingBroker{} records the tickets of the events it sends, and fails while err is set, on every event or only on the one of the failing ticket if set.
+type recordingBroker struct {
+	broker.UnimplementedBroker
+
+	tickets []string
+	err     error
+	failing string
+}
+
+// BookNotify() records the ticket of the event, unless the broker fails.
+func (r *recordingBroker) BookNotify(ctx context.Context, event *broker.BookNotifyEvent) error {
+	if r.err != nil && (r.failing == "" || r.failing == event.Ticket) {
+		return r.err
+	}
+
+	r.tickets = append(r.tickets, event.Ticket)
+
+	return nil
+}
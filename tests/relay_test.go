@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bookamovie/book/internal/app/relay"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRelay_Unit() runs the relay against a service failing a few times in a row, checking that the wait doubles with every failure, that full batches are followed right away, and that a success resets the wait.
+func TestRelay_Unit(t *testing.T) {
+	const poll = 50 * time.Millisecond
+
+	cfg, log := outboxConfig()
+	cfg.OutboxConfig.PollInterval = poll
+
+	failure := fmt.Errorf("broker is down")
+	service := &scriptedRelayer{
+		results: []relayResult{
+			{err: failure},
+			{err: failure},
+			{err: failure},
+			{published: cfg.OutboxConfig.BatchSize},
+			{published: 1},
+			{err: failure},
+			{},
+		},
+		done: make(chan struct{}),
+	}
+
+	app := relay.New(log, cfg, nil, nil)
+	app.Service = service
+
+	start := time.Now()
+	go app.Run()
+
+	select {
+	case <-service.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the relay didn't go through the script")
+	}
+	app.Shutdown()
+
+	calls := service.times()
+	assert.Len(t, calls, 7)
+
+	gaps := make([]time.Duration, 0, len(calls))
+	for i, call := range calls {
+		gaps = append(gaps, call.Sub(start))
+		start = calls[i]
+	}
+
+	// The first poll, then one, two and four poll intervals after each failure.
+	for i, wait := range []time.Duration{poll, poll, 2 * poll, 4 * poll} {
+		assert.GreaterOrEqual(t, gaps[i], wait, "call %d", i+1)
+	}
+
+	// The full batch is followed by the next one right away.
+	assert.Less(t, gaps[4], poll)
+
+	// The partial batch waits a poll interval, and the failure after it a single one again.
+	assert.GreaterOrEqual(t, gaps[5], poll)
+	assert.GreaterOrEqual(t, gaps[6], poll)
+	assert.Less(t, gaps[6], 2*poll)
+}
+
+// relayResult{} is the outcome of a RelayOutbox() call of a scriptedRelayer.
+type relayResult struct {
+	published int
+	err       error
+}
+
+// scriptedRelayer{} returns the given results in order, recording when it was called, and closes done once they run out.
+type scriptedRelayer struct {
+	mu      sync.Mutex
+	results []relayResult
+	calls   []time.Time
+	done    chan struct{}
+}
+
+// RelayOutbox() returns the next result of the script.
+func (r *scriptedRelayer) RelayOutbox(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.results) == 0 {
+		return 0, nil
+	}
+
+	r.calls = append(r.calls, time.Now())
+
+	result := r.results[0]
+	r.results = r.results[1:]
+
+	if len(r.results) == 0 {
+		close(r.done)
+	}
+
+	return result.published, result.err
+}
+
+// times() returns when RelayOutbox() was called.
+func (r *scriptedRelayer) times() []time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.calls
+}
+
+// TestRelayShutdown_Unit() shuts the relay down while a batch waits on a broker that never answers, checking that the batch is cancelled rather than waited for.
+func TestRelayShutdown_Unit(t *testing.T) {
+	cfg, log := outboxConfig()
+	cfg.OutboxConfig.PollInterval = time.Millisecond
+
+	service := &blockingRelayer{started: make(chan struct{})}
+
+	app := relay.New(log, cfg, nil, nil)
+	app.Service = service
+
+	go app.Run()
+	<-service.started
+
+	stopped := make(chan struct{})
+	go func() {
+		app.Shutdown()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the relay waited for the batch in progress")
+	}
+}
+
+// blockingRelayer{} blocks every RelayOutbox() call until its context is done, closing started on the first one.
+type blockingRelayer struct {
+	once    sync.Once
+	started chan struct{}
+}
+
+// RelayOutbox() waits for ctx to be done, and returns its error.
+func (r *blockingRelayer) RelayOutbox(ctx context.Context) (int, error) {
+	r.once.Do(func() { close(r.started) })
+
+	<-ctx.Done()
+
+	return 0, ctx.Err()
+}
@@ -0,0 +1,363 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bookamovie/book/internal/lib/logger"
+	bookservice "github.com/bookamovie/book/internal/services/book"
+	"github.com/bookamovie/book/internal/storage"
+	"github.com/bookamovie/book/internal/storage/memory"
+	"github.com/bookamovie/book/internal/storage/postgres"
+	"github.com/bookamovie/book/internal/storage/sqlite"
+	"github.com/bookamovie/book/internal/utils"
+	bookrcp "github.com/bookamovie/proto/gen/go/book/v3"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestStorage_Unit() runs the same bookings, holds and outbox messages against every storage backend, checking they enforce the same rules with the same errors.
+//
+// PostgreSQL is only tested when POSTGRES_DSN points to a database migrated with migrations/postgres.
+func TestStorage_Unit(t *testing.T) {
+	var cfg utils.Config
+	cfg.SQLiteConfig.Address = "storage/db.sqlite"
+
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			StorageLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	}
+
+	sqliteStorage, err := sqlite.New(cfg, log)
+	assert.NoError(t, err)
+	defer sqliteStorage.Shutdown()
+
+	backends := map[string]bookservice.Querier{
+		"sqlite": sqliteStorage,
+		"memory": memory.New(cfg, log),
+	}
+
+	if dsn := os.Getenv("POSTGRES_DSN"); dsn != "" {
+		cfg.PostgresConfig.Address = dsn
+
+		postgresStorage, err := postgres.New(cfg, log)
+		assert.NoError(t, err)
+		defer postgresStorage.Shutdown()
+
+		backends["postgres"] = postgresStorage
+	}
+
+	for name, s := range backends {
+		t.Run(name, func(t *testing.T) {
+			testStorageRules(t, s)
+		})
+	}
+}
+
+// testStorageRules() checks the uniqueness rules of a storage backend.
+func testStorageRules(t *testing.T, s bookservice.Querier) {
+	id := time.Now().UnixNano()
+	date := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+
+	request := func(seat int32) *bookrcp.BookRequest {
+		return &bookrcp.BookRequest{
+			Cinema: &bookrcp.Cinema{
+				Name:     fmt.Sprintf("cinema-%d", id),
+				Location: "location",
+			},
+			Movie: &bookrcp.Movie{
+				Title: "title",
+			},
+			Session: &bookrcp.Session{
+				Screen: 1,
+				Seat:   seat,
+				Date:   timestamppb.New(date),
+			},
+		}
+	}
+	ticket := func(n int) string {
+		return fmt.Sprintf("%d-%d", id, n)
+	}
+
+	err := s.Book(context.Background(), &storage.BookQuery{Ticket: ticket(1), Data: request(1)})
+	assert.NoError(t, err)
+
+	err = s.Book(context.Background(), &storage.BookQuery{Ticket: ticket(2), Data: request(1)})
+	assert.ErrorIs(t, err, storage.ErrConstraintUnique)
+
+	err = s.Book(context.Background(), &storage.BookQuery{Ticket: ticket(1), Data: request(2)})
+	assert.ErrorIs(t, err, storage.ErrConstraintPrimaryKey)
+
+	err = s.BookMany(context.Background(), &storage.BookManyQuery{
+		Queries: []*storage.BookQuery{
+			{Ticket: ticket(3), Data: request(3)},
+			{Ticket: ticket(4), Data: request(3)},
+		},
+	})
+	assert.ErrorIs(t, err, storage.ErrConstraintUnique)
+
+	_, err = s.GetBooking(context.Background(), &storage.GetBookingQuery{Ticket: ticket(3)})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	cancelled, err := s.CancelBooking(context.Background(), &storage.CancelBookingQuery{Ticket: ticket(1)})
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusCancelled, cancelled.Status)
+
+	_, err = s.CancelBooking(context.Background(), &storage.CancelBookingQuery{Ticket: ticket(1)})
+	assert.ErrorIs(t, err, storage.ErrAlreadyCancelled)
+
+	err = s.Book(context.Background(), &storage.BookQuery{Ticket: ticket(5), Data: request(1)})
+	assert.NoError(t, err)
+
+	hold := &storage.Hold{
+		ID:        ticket(6),
+		Data:      request(7),
+		ExpiresAt: time.Now().Add(time.Minute),
+	}
+
+	err = s.Hold(context.Background(), &storage.HoldQuery{Holds: []*storage.Hold{hold}})
+	assert.NoError(t, err)
+
+	err = s.Hold(context.Background(), &storage.HoldQuery{Holds: []*storage.Hold{{ID: ticket(7), Data: request(7), ExpiresAt: hold.ExpiresAt}}})
+	assert.ErrorIs(t, err, storage.ErrConstraintUnique)
+
+	err = s.Hold(context.Background(), &storage.HoldQuery{Holds: []*storage.Hold{{ID: ticket(8), Data: request(1), ExpiresAt: hold.ExpiresAt}}})
+	assert.ErrorIs(t, err, storage.ErrConstraintUnique)
+
+	taken, err := s.TakenSeats(context.Background(), &storage.TakenSeatsQuery{
+		Cinema:   fmt.Sprintf("cinema-%d", id),
+		Location: "location",
+		Screen:   1,
+		Date:     date,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int32{1, 7}, taken)
+
+	err = s.Book(context.Background(), &storage.BookQuery{Ticket: ticket(9), Data: request(7)})
+	assert.ErrorIs(t, err, storage.ErrConstraintUnique)
+
+	err = s.Book(context.Background(), &storage.BookQuery{Ticket: ticket(9), Hold: hold.ID, Data: request(7)})
+	assert.NoError(t, err)
+
+	_, err = s.GetHold(context.Background(), &storage.GetHoldQuery{ID: hold.ID})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	bookings, err := s.ListBookings(context.Background(), &storage.ListBookingsQuery{
+		Cinema: fmt.Sprintf("cinema-%d", id),
+		Limit:  10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, bookings, 3)
+
+	// An event is written along with its booking, and stays pending until it is published.
+	payload := []byte(ticket(10))
+
+	err = s.Book(context.Background(), &storage.BookQuery{Ticket: ticket(10), Data: request(10), Outbox: &storage.OutboxMessage{Type: "book", Payload: payload}})
+	assert.NoError(t, err)
+
+	// Every sink has its own pending messages, and a message is sent once every sink got it.
+	sinks := []string{"kafka", "webhook"}
+
+	pendingMessage := func(sink string) *storage.OutboxMessage {
+		msgs, err := s.PendingOutbox(context.Background(), &storage.PendingOutboxQuery{Sink: sink, Limit: 100000})
+		assert.NoError(t, err)
+
+		for _, msg := range msgs {
+			if string(msg.Payload) == string(payload) {
+				return msg
+			}
+		}
+
+		return nil
+	}
+
+	msg := pendingMessage("kafka")
+	if !assert.NotNil(t, msg) {
+		return
+	}
+	assert.Equal(t, "book", msg.Type)
+	assert.Equal(t, 0, msg.Attempts)
+
+	err = s.MarkOutbox(context.Background(), &storage.MarkOutboxQuery{ID: msg.ID, Sink: "kafka", Sinks: sinks, Error: "broker is down"})
+	assert.NoError(t, err)
+
+	msg = pendingMessage("kafka")
+	if !assert.NotNil(t, msg) {
+		return
+	}
+	assert.Equal(t, 1, msg.Attempts)
+
+	err = s.MarkOutbox(context.Background(), &storage.MarkOutboxQuery{ID: msg.ID, Sink: "kafka", Sinks: sinks})
+	assert.NoError(t, err)
+	assert.Nil(t, pendingMessage("kafka"))
+	assert.NotNil(t, pendingMessage("webhook"))
+
+	err = s.MarkOutbox(context.Background(), &storage.MarkOutboxQuery{ID: msg.ID, Sink: "webhook", Sinks: sinks})
+	assert.NoError(t, err)
+	assert.Nil(t, pendingMessage("webhook"))
+	assert.Nil(t, pendingMessage("file"))
+}
+
+// TestPostgresRelayLock_Unit() polls the outbox from two replicas sharing one PostgreSQL database, checking that only one of them relays to a sink at a time, and that the other takes over once the first shuts down.
+//
+// It only runs when POSTGRES_DSN points to a database migrated with migrations/postgres.
+func TestPostgresRelayLock_Unit(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN is not set")
+	}
+
+	var cfg utils.Config
+	cfg.PostgresConfig.Address = dsn
+
+	_, log := outboxConfig()
+
+	first, err := postgres.New(cfg, log)
+	assert.NoError(t, err)
+	defer first.Shutdown()
+
+	second, err := postgres.New(cfg, log)
+	assert.NoError(t, err)
+	defer second.Shutdown()
+
+	id := time.Now().UnixNano()
+	sink := fmt.Sprintf("sink-%d", id)
+
+	err = first.Book(context.Background(), &storage.BookQuery{
+		Ticket: fmt.Sprintf("%d", id),
+		Data: &bookrcp.BookRequest{
+			Cinema:  &bookrcp.Cinema{Name: fmt.Sprintf("cinema-%d", id), Location: "location"},
+			Movie:   &bookrcp.Movie{Title: "title"},
+			Session: &bookrcp.Session{Screen: 1, Seat: 1, Date: timestamppb.New(time.Now().Add(24 * time.Hour))},
+		},
+		Outbox: &storage.OutboxMessage{Type: "book", Payload: []byte("{}")},
+	})
+	assert.NoError(t, err)
+
+	msgs, err := first.PendingOutbox(context.Background(), &storage.PendingOutboxQuery{Sink: sink, Limit: 100000})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, msgs)
+
+	msgs, err = second.PendingOutbox(context.Background(), &storage.PendingOutboxQuery{Sink: sink, Limit: 100000})
+	assert.NoError(t, err)
+	assert.Empty(t, msgs)
+
+	first.Shutdown()
+
+	msgs, err = second.PendingOutbox(context.Background(), &storage.PendingOutboxQuery{Sink: sink, Limit: 100000})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, msgs)
+}
+
+// TestMigrations_Unit() migrates a database holding a seat booked twice, as older versions allowed, checking that the earliest booking is kept and the other one cancelled.
+func TestMigrations_Unit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+
+	m, err := migrate.New("file://migrations/sqlite", "sqlite3://"+path)
+	assert.NoError(t, err)
+	defer m.Close()
+
+	assert.NoError(t, m.Steps(1))
+
+	db, err := sql.Open("sqlite3", path)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for _, id := range []string{"2", "1", "3"} {
+		seat := 7
+		if id == "3" {
+			seat = 8
+		}
+
+		_, err = db.Exec(
+			"INSERT INTO bookings(id, movie, screen, seat, date, cinema, location) VALUES(?, 'movie', 1, ?, '2030-04-16T19:00:00Z', 'cinema', 'location');",
+			id,
+			seat,
+		)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, m.Up())
+
+	statuses := map[string]string{}
+
+	rows, err := db.Query("SELECT id, status FROM bookings;")
+	assert.NoError(t, err)
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, status string
+		assert.NoError(t, rows.Scan(&id, &status))
+
+		statuses[id] = status
+	}
+
+	assert.Equal(t, map[string]string{"2": storage.StatusBooked, "1": storage.StatusCancelled, "3": storage.StatusBooked}, statuses)
+}
+
+// TestMemoryScreens_Unit() reads screen layouts from the config into the memory storage, checking that it returns them and that the service books seats by them.
+func TestMemoryScreens_Unit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := "book:\n  seats: 10\nstorage:\n  driver: memory\n  screens:\n    - cinema: Cinema\n      location: Street\n      screen: 1\n      rows: 2\n      seats_per_row: 2\n      disabled: [4]\n"
+	assert.NoError(t, os.WriteFile(path, []byte(yaml), 0o600))
+
+	cfg, err := utils.ReadConfig(path)
+	assert.NoError(t, err)
+
+	_, log := outboxConfig()
+	s := memory.New(cfg, log)
+
+	screen, err := s.GetScreen(context.Background(), &storage.GetScreenQuery{Cinema: "Cinema", Location: "Street", Screen: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, &storage.Screen{Cinema: "Cinema", Location: "Street", Screen: 1, Rows: 2, SeatsPerRow: 2, Disabled: []int32{4}}, screen)
+
+	// Callers get a copy of the layout.
+	screen.Disabled[0] = 1
+
+	screen, err = s.GetScreen(context.Background(), &storage.GetScreenQuery{Cinema: "Cinema", Location: "Street", Screen: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []int32{4}, screen.Disabled)
+
+	_, err = s.GetScreen(context.Background(), &storage.GetScreenQuery{Cinema: "Cinema", Location: "Street", Screen: 2})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	service := bookservice.New(cfg, log, s, nil, nil, nil)
+	date := timestamppb.New(time.Date(2030, time.April, 16, 19, 0, 0, 0, time.UTC))
+
+	availability, err := service.GetSeatAvailability(context.Background(), &bookrcp.GetSeatAvailabilityRequest{
+		Cinema: &bookrcp.Cinema{Name: "Cinema", Location: "Street"},
+		Screen: 1,
+		Date:   date,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int32{1, 2, 3}, availability.GetFree())
+
+	for _, seat := range []int32{4, 5} {
+		_, err = service.Book(context.Background(), &bookrcp.BookRequest{
+			Cinema:  &bookrcp.Cinema{Name: "Cinema", Location: "Street"},
+			Movie:   &bookrcp.Movie{Title: "Movie"},
+			Session: &bookrcp.Session{Screen: 1, Seat: seat, Date: date},
+		})
+		assert.ErrorIs(t, err, bookservice.ErrSeatNotInLayout, "seat %d", seat)
+	}
+
+	// Screens without a layout keep the configured number of seats.
+	availability, err = service.GetSeatAvailability(context.Background(), &bookrcp.GetSeatAvailabilityRequest{
+		Cinema: &bookrcp.Cinema{Name: "Cinema", Location: "Street"},
+		Screen: 2,
+		Date:   date,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, availability.GetFree(), 10)
+}
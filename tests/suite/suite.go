@@ -5,7 +5,12 @@ import (
 
 	"github.com/bookamovie/book/internal/app"
 	bookapp "github.com/bookamovie/book/internal/app/book"
+	"github.com/bookamovie/book/internal/app/consumer"
+	metricsapp "github.com/bookamovie/book/internal/app/metrics"
+	"github.com/bookamovie/book/internal/app/relay"
+	"github.com/bookamovie/book/internal/app/sweeper"
 	"github.com/bookamovie/book/internal/lib/logger"
+	"github.com/bookamovie/book/internal/lib/tracing"
 	bookservice "github.com/bookamovie/book/internal/services/book"
 	"github.com/bookamovie/book/internal/utils"
 	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
@@ -28,12 +33,37 @@ func New(t *testing.T, cfg utils.Config, log *logger.Logger, storage bookservice
 	t.Helper()
 	t.Parallel()
 
+	tickets, err := bookservice.NewTicketGenerator(cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	keys, err := bookservice.NewKeyRing(cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	tp, err := tracing.New(cfg, log)
+	if err != nil {
+		panic(err)
+	}
+
+	cons, err := consumer.New(log, cfg, storage)
+	if err != nil {
+		panic(err)
+	}
+
 	app := &app.App{
-		Book:    bookapp.New(log, cfg, storage, broker),
-		Storage: storage,
-		Broker:  broker,
-		Log:     log,
-		Config:  cfg,
+		Book:     bookapp.New(log, cfg, storage, broker, tickets, keys),
+		Sweeper:  sweeper.New(log, cfg, storage),
+		Relay:    relay.New(log, cfg, storage, broker),
+		Consumer: cons,
+		Metrics:  metricsapp.New(log, cfg),
+		Tracing:  tp,
+		Storage:  storage,
+		Broker:   broker,
+		Log:      log,
+		Config:   cfg,
 	}
 
 	conn, err := grpc.NewClient(cfg.BookConfig.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
@@ -0,0 +1,239 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	bookservice "github.com/bookamovie/book/internal/services/book"
+	"github.com/bookamovie/book/internal/storage"
+	"github.com/bookamovie/book/internal/storage/memory"
+	"github.com/bookamovie/book/internal/utils"
+	"github.com/bookamovie/book/pkg/ticket"
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+	"github.com/oklog/ulid/v2"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestTicket_Unit() signs ticket tokens and verifies them the way door scanners do, across a key rotation.
+func TestTicket_Unit(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	retired := ticket.Key{
+		ID:        "retired",
+		Algorithm: ticket.AlgorithmHMAC,
+		Secret:    []byte(strings.Repeat("s", 32)),
+	}
+	active := ticket.Key{
+		ID:        "active",
+		Algorithm: ticket.AlgorithmEd25519,
+		Secret:    priv,
+	}
+
+	claims := &ticket.Claims{
+		Ticket:   "123456789012",
+		Cinema:   "cinema",
+		Location: "location",
+		Screen:   1,
+		Seat:     7,
+		Session:  time.Date(2025, 4, 16, 19, 0, 0, 0, time.UTC),
+		IssuedAt: time.Now().UTC(),
+	}
+
+	before, err := ticket.NewKeyRing(retired.ID, retired)
+	assert.NoError(t, err)
+
+	oldToken, err := before.Sign(claims)
+	assert.NoError(t, err)
+
+	after, err := ticket.NewKeyRing(active.ID, active, retired)
+	assert.NoError(t, err)
+
+	newToken, err := after.Sign(claims)
+	assert.NoError(t, err)
+
+	scanner := after.Public()
+
+	for _, token := range []string{oldToken, newToken} {
+		got, err := scanner.Verify(token)
+		assert.NoError(t, err)
+		assert.Equal(t, claims.Ticket, got.Ticket)
+		assert.Equal(t, claims.Seat, got.Seat)
+		assert.True(t, claims.Session.Equal(got.Session))
+	}
+
+	_, err = scanner.Sign(claims)
+	assert.ErrorIs(t, err, ticket.ErrNoActiveKey)
+
+	_, err = before.Verify(newToken)
+	assert.ErrorIs(t, err, ticket.ErrUnknownKey)
+
+	parts := strings.Split(newToken, ".")
+	forged, err := before.Sign(&ticket.Claims{Ticket: "forged"})
+	assert.NoError(t, err)
+	parts[2] = strings.Split(forged, ".")[2]
+
+	_, err = scanner.Verify(strings.Join(parts, "."))
+	assert.ErrorIs(t, err, ticket.ErrInvalidSignature)
+
+	_, err = scanner.Verify("not a token")
+	assert.ErrorIs(t, err, ticket.ErrMalformedToken)
+
+	_, err = ticket.NewKeyRing(active.ID, active.Public())
+	assert.ErrorIs(t, err, ticket.ErrNoActiveKey)
+}
+
+// TestKeyRingSecretFile_Unit() checks that signing secrets can be read from files kept out of the config.
+func TestKeyRingSecretFile_Unit(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "signing.key")
+	assert.NoError(t, os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(priv)+"\n"), 0o600))
+
+	var cfg utils.Config
+	cfg.SigningConfig.KeyID = "file"
+	cfg.SigningConfig.Keys = []utils.SigningKey{{ID: "file", Algorithm: ticket.AlgorithmEd25519, SecretFile: path}}
+
+	keys, err := bookservice.NewKeyRing(cfg)
+	assert.NoError(t, err)
+
+	token, err := keys.Sign(&ticket.Claims{Ticket: "123456789012", IssuedAt: time.Now().UTC()})
+	assert.NoError(t, err)
+
+	scanner, err := ticket.NewKeyRing("", ticket.Key{ID: "file", Algorithm: ticket.AlgorithmEd25519, Secret: priv.Public().(ed25519.PublicKey)})
+	assert.NoError(t, err)
+
+	_, err = scanner.Verify(token)
+	assert.NoError(t, err)
+
+	// A missing file fails the startup rather than leaving tickets unsigned.
+	cfg.SigningConfig.Keys[0].SecretFile = filepath.Join(t.TempDir(), "missing.key")
+	_, err = bookservice.NewKeyRing(cfg)
+	assert.ErrorIs(t, err, bookservice.ErrSecretFile)
+}
+
+// TestTicketGenerator_Unit() checks the tickets of every generator, and that generators producing nothing but check digits are rejected.
+func TestTicketGenerator_Unit(t *testing.T) {
+	// The check digit of the worked example of the Luhn algorithm.
+	assert.Equal(t, byte('3'), bookservice.LuhnCheckDigit("7992739871"))
+	assert.Equal(t, byte('0'), bookservice.LuhnCheckDigit("0"))
+
+	cfg, _ := outboxConfig()
+	cfg.TicketConfig.Length = 12
+	cfg.TicketConfig.Prefixes = map[string]string{"IMAX Hall": "IMX"}
+
+	cfg.TicketConfig.Generator = bookservice.GeneratorLuhn
+	luhn, err := bookservice.NewTicketGenerator(cfg)
+	assert.NoError(t, err)
+
+	tkt := luhn.Generate(&bookrpc.Cinema{Name: "Cinema"})
+	assert.Regexp(t, `^[0-9]{12}$`, tkt)
+	assert.Equal(t, tkt[11], bookservice.LuhnCheckDigit(tkt[:11]))
+
+	cfg.TicketConfig.Generator = bookservice.GeneratorPrefix
+	prefix, err := bookservice.NewTicketGenerator(cfg)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		cinema string
+		prefix string
+	}{
+		{name: "configured", cinema: "IMAX Hall", prefix: "IMX"},
+		{name: "derived", cinema: "le cinéma 7", prefix: "LEC"},
+		{name: "short", cinema: "K-2", prefix: "K2"},
+		{name: "default", cinema: "★ ★ ★", prefix: "TKT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tkt := prefix.Generate(&bookrpc.Cinema{Name: tt.cinema})
+			assert.Regexp(t, "^"+regexp.QuoteMeta(tt.prefix)+`-[0-9]{12}$`, tkt)
+		})
+	}
+
+	cfg.TicketConfig.Generator = bookservice.GeneratorULID
+	ulids, err := bookservice.NewTicketGenerator(cfg)
+	assert.NoError(t, err)
+
+	tkt = ulids.Generate(&bookrpc.Cinema{Name: "Cinema"})
+	assert.Len(t, tkt, ulid.EncodedSize)
+	_, err = ulid.ParseStrict(tkt)
+	assert.NoError(t, err)
+
+	for _, length := range []int{-1, 0, 1} {
+		cfg.TicketConfig.Length = length
+
+		for _, generator := range []string{bookservice.GeneratorLuhn, bookservice.GeneratorPrefix} {
+			cfg.TicketConfig.Generator = generator
+			_, err = bookservice.NewTicketGenerator(cfg)
+			assert.ErrorIs(t, err, bookservice.ErrInvalidLength)
+		}
+	}
+
+	// ULID tickets don't depend on the length.
+	cfg.TicketConfig.Generator = bookservice.GeneratorULID
+	_, err = bookservice.NewTicketGenerator(cfg)
+	assert.NoError(t, err)
+}
+
+// TestTicketCollision_Unit() books with a generator that repeats tickets, checking that taken tickets are generated again until the attempts run out.
+func TestTicketCollision_Unit(t *testing.T) {
+	cfg, log := outboxConfig()
+	cfg.BookConfig.Seats = 10
+
+	s := memory.New(cfg, log)
+	tickets := &sequenceGenerator{}
+	service := bookservice.New(cfg, log, s, nil, tickets, nil)
+
+	book := func(seat int32) (*bookrpc.BookResponse, error) {
+		return service.Book(context.Background(), &bookrpc.BookRequest{
+			Cinema:  &bookrpc.Cinema{Name: "Cinema", Location: "Street"},
+			Movie:   &bookrpc.Movie{Title: "Movie"},
+			Session: &bookrpc.Session{Screen: 1, Seat: seat, Date: timestamppb.New(time.Date(2030, time.April, 16, 19, 0, 0, 0, time.UTC))},
+		})
+	}
+
+	tickets.tickets = []string{"A"}
+	resp, err := book(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "A", resp.GetOrder().GetTicket())
+
+	tickets.tickets = []string{"A", "A", "B"}
+	resp, err = book(2)
+	assert.NoError(t, err)
+	assert.Equal(t, "B", resp.GetOrder().GetTicket())
+	assert.Empty(t, tickets.tickets)
+
+	// Every attempt collides, so the booking fails and the seat stays free.
+	tickets.tickets = []string{"A", "B", "A", "B", "A", "C"}
+	_, err = book(3)
+	assert.ErrorIs(t, err, storage.ErrConstraintPrimaryKey)
+	assert.Equal(t, []string{"C"}, tickets.tickets)
+
+	resp, err = book(3)
+	assert.NoError(t, err)
+	assert.Equal(t, "C", resp.GetOrder().GetTicket())
+}
+
+// sequenceGenerator{} hands out the given tickets in order.
+type sequenceGenerator struct {
+	tickets []string
+}
+
+// Generate() returns the next ticket of the sequence.
+func (g *sequenceGenerator) Generate(cinema *bookrpc.Cinema) string {
+	tkt := g.tickets[0]
+	g.tickets = g.tickets[1:]
+
+	return tkt
+}
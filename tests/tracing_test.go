@@ -0,0 +1,214 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	bookapp "github.com/bookamovie/book/internal/app/book"
+	broker "github.com/bookamovie/book/internal/broker/kafka"
+	"github.com/bookamovie/book/internal/lib/logger"
+	"github.com/bookamovie/book/internal/lib/tracing"
+	bookservice "github.com/bookamovie/book/internal/services/book"
+	"github.com/bookamovie/book/internal/storage"
+	"github.com/bookamovie/book/internal/storage/sqlite"
+	"github.com/bookamovie/book/internal/utils"
+	bookrpc "github.com/bookamovie/proto/gen/go/book/v3"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestTracing_Unit() follows a booking from its request to the Kafka message its event is published with, checking the spans recorded on the way and the trace context injected into the message headers.
+func TestTracing_Unit(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	db, err := os.ReadFile("storage/db.sqlite")
+	assert.NoError(t, err)
+
+	var cfg utils.Config
+	cfg.SQLiteConfig.Address = filepath.Join(t.TempDir(), "db.sqlite")
+	cfg.EventsConfig.Encoding = broker.EncodingProto
+	cfg.KafkaConfig.Topic = "notifications"
+	cfg.OutboxConfig.BatchSize = 1000
+	cfg.BookConfig.Seats = 10
+	assert.NoError(t, os.WriteFile(cfg.SQLiteConfig.Address, db, 0o600))
+
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			BookLog:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+			StorageLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			BrokerLog:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	}
+
+	s, err := sqlite.New(cfg, log)
+	assert.NoError(t, err)
+	defer s.Shutdown()
+
+	// Events left pending by other tests are published first, the one of the booking last.
+	pending, err := s.PendingOutbox(context.Background(), &storage.PendingOutboxQuery{Limit: 1000})
+	assert.NoError(t, err)
+
+	var headers []sarama.RecordHeader
+
+	producer := mocks.NewSyncProducer(t, nil)
+	for range len(pending) + 1 {
+		producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			headers = msg.Headers
+
+			return nil
+		})
+	}
+
+	service := bookservice.New(cfg, log, s, broker.NewSync(cfg, log, producer), &bookservice.LuhnGenerator{Length: 8}, nil)
+	api := &bookapp.Api{Service: service}
+
+	req := &bookrpc.BookRequest{
+		Cinema:  &bookrpc.Cinema{Name: fmt.Sprintf("cinema-%d", time.Now().UnixNano()), Location: "Street"},
+		Movie:   &bookrpc.Movie{Title: "Movie"},
+		Session: &bookrpc.Session{Screen: 1, Seat: 1, Date: timestamppb.New(time.Date(2030, time.April, 16, 19, 0, 0, 0, time.UTC))},
+	}
+
+	// The request span stands for the one the gRPC server starts for every call.
+	ctx, request := tracing.Start(context.Background(), "book.Book/Book", trace.WithSpanKind(trace.SpanKindServer))
+	_, err = api.Book(ctx, req)
+	assert.NoError(t, err)
+
+	// The same seat again makes the transaction fail.
+	_, err = api.Book(ctx, req)
+	assert.Error(t, err)
+	request.End()
+
+	// The relay runs outside of any request, and still publishes the event within the trace of the request.
+	published, err := service.RelayOutbox(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, len(pending)+1, published)
+
+	traceID := request.SpanContext().TraceID()
+
+	spans := map[string][]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID() == traceID {
+			spans[span.Name] = append(spans[span.Name], span)
+		}
+	}
+
+	assert.Len(t, spans["Storage.Book"], 2)
+	for _, span := range spans["Storage.Book"] {
+		assert.Equal(t, request.SpanContext().SpanID(), span.Parent.SpanID())
+	}
+	assert.Equal(t, codes.Unset, spans["Storage.Book"][0].Status.Code)
+	assert.Equal(t, codes.Error, spans["Storage.Book"][1].Status.Code)
+
+	assert.Len(t, spans["Broker.BookNotify"], 1)
+	produce := spans["Broker.BookNotify"][0]
+	assert.Equal(t, trace.SpanKindProducer, produce.SpanKind)
+	assert.Equal(t, traceID, produce.SpanContext.TraceID())
+	assert.Equal(t, request.SpanContext().SpanID(), produce.Parent.SpanID())
+	assert.True(t, produce.Parent.IsRemote())
+
+	// Consumers continue the trace from the produce span, and find the trace ID in the CloudEvents attributes too.
+	values := map[string]string{}
+	for _, h := range headers {
+		values[string(h.Key)] = string(h.Value)
+	}
+
+	assert.Equal(t, "00-"+traceID.String()+"-"+produce.SpanContext.SpanID().String()+"-01", values["traceparent"])
+	assert.Equal(t, traceID.String(), values["ce_traceid"])
+
+	// Unknown exporters are rejected, while none records nothing.
+	_, err = tracing.New(utils.Config{TracingConfig: utils.TracingConfig{Exporter: "jaeger"}}, log)
+	assert.ErrorIs(t, err, tracing.ErrUnknownExporter)
+
+	tp, err := tracing.New(utils.Config{TracingConfig: utils.TracingConfig{Exporter: tracing.ExporterNone}}, log)
+	assert.NoError(t, err)
+	tp.Shutdown()
+
+	// Without a trace, the context is left alone.
+	assert.Empty(t, tracing.TraceParent(context.Background()))
+	assert.Equal(t, context.Background(), tracing.WithTraceParent(context.Background(), ""))
+}
+
+// TestTracingBatches_Unit() checks that BookMany and HoldSeats trace their transactions like Book, within the trace of the request.
+func TestTracingBatches_Unit(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	db, err := os.ReadFile("storage/db.sqlite")
+	assert.NoError(t, err)
+
+	var cfg utils.Config
+	cfg.SQLiteConfig.Address = filepath.Join(t.TempDir(), "db.sqlite")
+	cfg.EventsConfig.Encoding = broker.EncodingJSON
+	cfg.BookConfig.Seats = 10
+	cfg.HoldConfig.TTL = time.Minute
+	assert.NoError(t, os.WriteFile(cfg.SQLiteConfig.Address, db, 0o600))
+
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			BookLog:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+			StorageLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			BrokerLog:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	}
+
+	s, err := sqlite.New(cfg, log)
+	assert.NoError(t, err)
+	defer s.Shutdown()
+
+	api := &bookapp.Api{Service: bookservice.New(cfg, log, s, &broker.UnimplementedBroker{}, &bookservice.LuhnGenerator{Length: 8}, nil)}
+
+	cinema := &bookrpc.Cinema{Name: fmt.Sprintf("cinema-%d", time.Now().UnixNano()), Location: "Street"}
+	movie := &bookrpc.Movie{Title: "Movie"}
+	date := timestamppb.New(time.Date(2030, time.April, 16, 19, 0, 0, 0, time.UTC))
+
+	ctx, request := tracing.Start(context.Background(), "book.Book/BookMany", trace.WithSpanKind(trace.SpanKindServer))
+	_, err = api.BookMany(ctx, &bookrpc.BookManyRequest{Cinema: cinema, Movie: movie, Screen: 1, Date: date, Seats: []int32{1, 2}})
+	assert.NoError(t, err)
+
+	// Holding a booked seat makes the transaction fail.
+	_, err = api.HoldSeats(ctx, &bookrpc.HoldSeatsRequest{Cinema: cinema, Movie: movie, Screen: 1, Date: date, Seats: []int32{3}})
+	assert.NoError(t, err)
+
+	_, err = api.HoldSeats(ctx, &bookrpc.HoldSeatsRequest{Cinema: cinema, Movie: movie, Screen: 1, Date: date, Seats: []int32{2}})
+	assert.Error(t, err)
+	request.End()
+
+	spans := map[string][]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID() == request.SpanContext().TraceID() {
+			spans[span.Name] = append(spans[span.Name], span)
+		}
+	}
+
+	assert.Len(t, spans["Storage.BookMany"], 1)
+	assert.Len(t, spans["Storage.Hold"], 2)
+	for _, span := range append(spans["Storage.BookMany"], spans["Storage.Hold"]...) {
+		assert.Equal(t, trace.SpanKindClient, span.SpanKind)
+		assert.Equal(t, request.SpanContext().SpanID(), span.Parent.SpanID())
+	}
+	assert.Equal(t, codes.Unset, spans["Storage.BookMany"][0].Status.Code)
+	assert.Equal(t, codes.Unset, spans["Storage.Hold"][0].Status.Code)
+	assert.Equal(t, codes.Error, spans["Storage.Hold"][1].Status.Code)
+}
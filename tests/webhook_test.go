@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bookamovie/book/internal/broker/fanout"
+	broker "github.com/bookamovie/book/internal/broker/kafka"
+	"github.com/bookamovie/book/internal/broker/webhook"
+	"github.com/bookamovie/book/internal/lib/logger"
+	bookservice "github.com/bookamovie/book/internal/services/book"
+	"github.com/bookamovie/book/internal/utils"
+	eventsv1 "github.com/bookamovie/book/pkg/events/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+// TestWebhook_Unit() posts events to httptest webhooks, checking signatures, retries, binary mode and the fan-out.
+func TestWebhook_Unit(t *testing.T) {
+	const secret = "webhook-secret"
+
+	var flakyHits, rejectingHits atomic.Int32
+	var received atomic.Value
+
+	// flaky fails the first attempt, then accepts correctly signed events.
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if flakyHits.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+
+		if r.Header.Get(webhook.SignatureHeader) != webhook.Sign([]byte(secret), r.Header.Get(webhook.TimestampHeader), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var event struct {
+			Type string `json:"type"`
+			Data struct {
+				Bookings []struct {
+					Ticket string `json:"ticket"`
+				} `json:"bookings"`
+			} `json:"data"`
+		}
+		_ = json.Unmarshal(body, &event)
+
+		if r.Header.Get("Content-Type") != broker.ContentType(broker.EncodingJSON) || len(event.Data.Bookings) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received.Store(event.Type + ":" + event.Data.Bookings[0].Ticket)
+	}))
+	defer flaky.Close()
+
+	// rejecting refuses every event, which must not be retried.
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rejectingHits.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+
+	log := &logger.Logger{
+		Logs: logger.Logs{
+			BrokerLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	}
+
+	var cfg utils.Config
+	cfg.EventsConfig = utils.EventsConfig{
+		Source:   "/bookamovie/book",
+		Encoding: broker.EncodingJSON,
+	}
+	cfg.WebhookConfig = utils.WebhookConfig{
+		URLs:       []string{flaky.URL, rejecting.URL},
+		Secret:     secret,
+		Timeout:    time.Second,
+		Retries:    3,
+		Backoff:    time.Millisecond,
+		MaxBackoff: 4 * time.Millisecond,
+	}
+
+	br, err := webhook.New(cfg, log)
+	assert.NoError(t, err)
+	defer br.Shutdown()
+
+	err = br.BookNotify(context.Background(), &broker.BookNotifyEvent{Ticket: "1"})
+	assert.ErrorIs(t, err, webhook.ErrUnexpectedStatus)
+	assert.Equal(t, int32(2), flakyHits.Load())
+	assert.Equal(t, int32(1), rejectingHits.Load())
+	assert.Equal(t, broker.BookNotifyEventType+":1", received.Load())
+
+	cfg.WebhookConfig.URLs = []string{flaky.URL}
+
+	single, err := webhook.New(cfg, log)
+	assert.NoError(t, err)
+
+	both := fanout.New(bookservice.Sink{Name: "webhook", Broker: single}, bookservice.Sink{Name: "none", Broker: &broker.UnimplementedBroker{}})
+	defer both.Shutdown()
+
+	err = both.BookNotify(context.Background(), &broker.BookNotifyEvent{Ticket: "2"})
+	assert.NoError(t, err)
+	assert.Equal(t, broker.BookNotifyEventType+":2", received.Load())
+
+	// Webhooks that respond at all are healthy, even if they reject the request, while closed ones aren't.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	cfg.WebhookConfig.URLs = []string{rejecting.URL}
+
+	healthy, err := webhook.New(cfg, log)
+	assert.NoError(t, err)
+	assert.NoError(t, healthy.Ping(context.Background()))
+
+	cfg.WebhookConfig.URLs = []string{rejecting.URL, closed.URL}
+
+	unhealthy, err := webhook.New(cfg, log)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, unhealthy.Ping(context.Background()), webhook.ErrUnreachable)
+
+	// Retries stop waiting once the context is done.
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	cfg.WebhookConfig.URLs = []string{down.URL}
+	cfg.WebhookConfig.Backoff = time.Hour
+	cfg.WebhookConfig.MaxBackoff = time.Hour
+
+	slow, err := webhook.New(cfg, log)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = slow.BookNotify(ctx, &broker.BookNotifyEvent{Ticket: "3"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, err, webhook.ErrUnexpectedStatus)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Protobuf events are in binary mode: the data is the body, the attributes are headers, and the signature still covers the body.
+	var binary atomic.Value
+
+	recording := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		var data eventsv1.Data
+		if r.Header.Get(webhook.SignatureHeader) != webhook.Sign([]byte(secret), r.Header.Get(webhook.TimestampHeader), body) || proto.Unmarshal(body, &data) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		binary.Store(r.Header.Get("Content-Type") + " " + r.Header.Get("ce-type") + ":" + data.GetBookings()[0].GetTicket() + " " + r.Header.Get("ce-id"))
+	}))
+	defer recording.Close()
+
+	cfg.WebhookConfig.URLs = []string{recording.URL}
+	cfg.EventsConfig.Encoding = broker.EncodingProto
+
+	protobuf, err := webhook.New(cfg, log)
+	assert.NoError(t, err)
+
+	err = protobuf.BookNotify(context.Background(), &broker.BookNotifyEvent{Meta: broker.Meta{ID: "event-4"}, Ticket: "4"})
+	assert.NoError(t, err)
+	assert.Equal(t, broker.ContentType(broker.EncodingProto)+" "+broker.BookNotifyEventType+":4 event-4", binary.Load())
+
+	cfg.EventsConfig.Encoding = broker.EncodingJSON
+	cfg.WebhookConfig.URLs = []string{"ftp://example.com"}
+
+	_, err = webhook.New(cfg, log)
+	assert.ErrorIs(t, err, webhook.ErrInvalidURL)
+
+	cfg.WebhookConfig.URLs = []string{flaky.URL}
+	cfg.EventsConfig.Encoding = "xml"
+
+	_, err = webhook.New(cfg, log)
+	assert.ErrorIs(t, err, broker.ErrUnknownEncoding)
+}